@@ -4,9 +4,11 @@ go 1.22
 
 require gopkg.in/yaml.v3 v3.0.1
 
+require golang.org/x/crypto v0.31.0
+
 require (
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.19 // indirect
 	github.com/rs/zerolog v1.33.0
-	golang.org/x/sys v0.12.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
 )
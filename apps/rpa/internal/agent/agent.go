@@ -4,29 +4,65 @@
 package agent
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"net"
-	"os/exec"
+	"net/netip"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"reverse-proxy-agent/internal/supervisor"
+	"reverse-proxy-agent/pkg/audit"
 	"reverse-proxy-agent/pkg/buildinfo"
 	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/conntrack"
+	"reverse-proxy-agent/pkg/forward"
+	"reverse-proxy-agent/pkg/httpproxy"
 	"reverse-proxy-agent/pkg/logging"
 	"reverse-proxy-agent/pkg/monitor"
+	"reverse-proxy-agent/pkg/probe"
+	"reverse-proxy-agent/pkg/relay"
 	"reverse-proxy-agent/pkg/restart"
+	"reverse-proxy-agent/pkg/sshutil"
 	"reverse-proxy-agent/pkg/state"
 	"reverse-proxy-agent/pkg/statefile"
 )
 
+// targetLookupTimeout bounds resolving forward targets while counting
+// active connections.
+const targetLookupTimeout = 2 * time.Second
+
 type Agent struct {
 	cfg    *config.Config
 	runner *supervisor.Runner
 
-	forwardMu sync.Mutex
+	forwardMu  sync.Mutex
+	configPath string
+
+	relayMu    sync.Mutex
+	relays     map[string]*relay.Listener
+	relayStats map[string]*relay.Stats
+	logger     *logging.Logger
+	audit      *audit.Writer
+	origins    *originTracker
+
+	proxyMu sync.Mutex
+	proxy   *httpproxy.Proxy
+
+	certs    *certStore
+	certStop chan struct{}
+
+	grantMu      sync.Mutex
+	grantBusy    bool
+	grantLastTry time.Time
+
+	ports     *portTracker
+	statePath string
+	stateMu   sync.Mutex
+	lastState statefile.Snapshot
 }
 
 func New(cfg *config.Config) *Agent {
@@ -35,21 +71,27 @@ func New(cfg *config.Config) *Agent {
 		path = ""
 	}
 	runner := supervisor.New(restart.ParsePolicy(cfg.Agent.RestartPolicy), restart.NewBackoff(cfg.Agent.Restart))
+	a := &Agent{
+		cfg:        cfg,
+		runner:     runner,
+		relays:     make(map[string]*relay.Listener),
+		relayStats: make(map[string]*relay.Stats),
+		origins:    newOriginTracker(),
+		certs:      newCertStore(),
+		ports:      newPortTracker(),
+		statePath:  path,
+	}
 	if path != "" {
 		runner.SetStateWriter(func(snap statefile.Snapshot) {
-			_ = statefile.Write(path, snap)
+			a.writeState(&snap)
 		})
 	}
-	return &Agent{
-		cfg:    cfg,
-		runner: runner,
-	}
+	runner.SetStderrHook(a.observeSSH)
+	return a
 }
 
 func (a *Agent) Start() error {
-	return a.runner.Start(func() (*exec.Cmd, error) {
-		return buildSSHCommand(a.cfg, a.currentRemoteForwards())
-	})
+	return a.runner.Start(a.buildCommand)
 }
 
 func (a *Agent) Stop() error {
@@ -61,7 +103,11 @@ func (a *Agent) State() state.State {
 }
 
 func (a *Agent) ConfigSummary() string {
-	return fmt.Sprintf("%s@%s:%d", a.cfg.SSH.User, a.cfg.SSH.Host, a.cfg.SSH.Port)
+	summary := fmt.Sprintf("%s@%s:%d", a.cfg.SSH.User, a.cfg.SSH.Host, a.cfg.SSH.Port)
+	if len(a.cfg.SSH.JumpHosts) > 0 {
+		summary += " via " + config.JumpChain(a.cfg.SSH.JumpHosts)
+	}
+	return summary
 }
 
 func (a *Agent) RunWithLogger(logger *logging.Logger) error {
@@ -74,14 +120,134 @@ func (a *Agent) RunWithLogger(logger *logging.Logger) error {
 			SleepGapSec:    a.cfg.Agent.SleepGapSec,
 			NetworkPollSec: a.cfg.Agent.NetworkPollSec,
 		},
-		PeriodicRestartSec: a.cfg.Agent.PeriodicRestartSec,
-		DebounceMs:         a.cfg.Agent.Restart.DebounceMs,
-		TCPCheckSec:        a.cfg.SSH.CheckSec,
-		TCPCheckAddr:       net.JoinHostPort(a.cfg.SSH.Host, strconv.Itoa(a.cfg.SSH.Port)),
+		PeriodicRestartSec:  a.cfg.Agent.PeriodicRestartSec,
+		DebounceMs:          a.cfg.Agent.Restart.DebounceMs,
+		TCPCheckSec:         a.cfg.SSH.CheckSec,
+		TCPCheckAddr:        config.SSHDialAddr(a.cfg),
+		Probes:              a.buildProbes(),
+		PeriodicJitterSec:   a.cfg.Agent.PeriodicRestartJitterSec,
+		PeriodicMaxDeferSec: a.cfg.Agent.PeriodicRestartMaxDeferSec,
+		QuietHours:          config.QuietHoursFunc(a.cfg.Agent.PeriodicRestartQuietHours),
+		ActiveConnections:   a.activeConnections,
+	}
+	a.setRelayLogger(logger)
+	a.syncRelays()
+	defer a.stopRelays()
+	expiryStop := make(chan struct{})
+	defer close(expiryStop)
+	go a.expireLoop(logger, expiryStop)
+	a.startHTTPProxy(logger)
+	defer a.stopHTTPProxy()
+	return a.runner.RunWithLogger(logger, a.buildCommand, opts)
+}
+
+func (a *Agent) ProbeStatuses() []supervisor.ProbeStatus {
+	return a.runner.ProbeStatuses()
+}
+
+func (a *Agent) buildProbes() []*probe.Probe {
+	enabled := a.currentRemoteForwards()
+	out := make([]*probe.Probe, 0, len(a.cfg.Agent.Probes))
+	for _, pc := range a.cfg.Agent.Probes {
+		if !containsForward(enabled, pc.Forward) {
+			continue
+		}
+		out = append(out, probe.New(pc, a.probeDialer(pc)))
+	}
+	return out
+}
+
+// probeDialer reaches the server-side end of a remote forward. Wildcard and
+// public binds are dialed directly; loopback binds go through `ssh -W`.
+func (a *Agent) probeDialer(pc config.ProbeConfig) probe.DialFunc {
+	if strings.TrimSpace(pc.Address) != "" {
+		return probe.TCPDialer(pc.Address)
+	}
+	f, err := forward.Parse(pc.Forward)
+	if err == nil && f.Bind.IsSocket() {
+		err = fmt.Errorf("forward %s binds a unix socket on the server; set probe address", pc.Forward)
 	}
-	return a.runner.RunWithLogger(logger, func() (*exec.Cmd, error) {
-		return buildSSHCommand(a.cfg, a.currentRemoteForwards())
-	}, opts)
+	if err != nil {
+		return func(context.Context) (io.ReadWriteCloser, error) {
+			return nil, err
+		}
+	}
+	bindHost, bindPort := f.Bind.Host, strconv.Itoa(f.Bind.Port)
+	switch strings.ToLower(bindHost) {
+	case "", "*", "0.0.0.0", "::":
+		return probe.TCPDialer(net.JoinHostPort(a.cfg.SSH.Host, bindPort))
+	case "127.0.0.1", "localhost", "::1":
+		target := net.JoinHostPort("127.0.0.1", bindPort)
+		return func(ctx context.Context) (io.ReadWriteCloser, error) {
+			return sshutil.DialCommand(buildStdioCommand(a.cfg, target))
+		}
+	default:
+		return probe.TCPDialer(net.JoinHostPort(bindHost, bindPort))
+	}
+}
+
+func (a *Agent) PeriodicStatus() supervisor.PeriodicStatus {
+	return a.runner.PeriodicStatus()
+}
+
+// activeConnections counts live forwarded connections. Relayed forwards
+// report their own count; for the rest only connections to the exact target
+// address are counted, since the port alone also matches unrelated sockets.
+func (a *Agent) activeConnections() (int, error) {
+	a.relayMu.Lock()
+	relayed := make(map[string]bool, len(a.relays))
+	active := 0
+	for value, ln := range a.relays {
+		relayed[value] = true
+		active += int(ln.Stats.Snapshot().Active)
+	}
+	a.relayMu.Unlock()
+
+	var filter conntrack.Filter
+	for _, value := range a.currentRemoteForwards() {
+		if relayed[value] {
+			continue
+		}
+		f, err := forward.Parse(value)
+		if err != nil || f.Dynamic || f.Target.IsSocket() {
+			continue
+		}
+		filter.Remotes = append(filter.Remotes, targetAddrs(f.Target)...)
+	}
+	n, err := conntrack.Established(filter)
+	if err != nil {
+		if active > 0 {
+			return active, nil
+		}
+		return 0, err
+	}
+	return active + n, nil
+}
+
+// targetAddrs resolves a forward target to the addresses ssh connects to.
+func targetAddrs(target forward.Endpoint) []netip.AddrPort {
+	port := uint16(target.Port)
+	host := strings.Trim(target.Host, "[]")
+	if host == "" || strings.EqualFold(host, "localhost") {
+		return []netip.AddrPort{
+			netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), port),
+			netip.AddrPortFrom(netip.IPv6Loopback(), port),
+		}
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.AddrPort{netip.AddrPortFrom(addr, port)}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), targetLookupTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil
+	}
+	out := make([]netip.AddrPort, 0, len(addrs))
+	for _, addr := range addrs {
+		out = append(out, netip.AddrPortFrom(addr.Unmap(), port))
+	}
+	return out
 }
 
 func (a *Agent) RequestStop() {
@@ -136,50 +302,96 @@ func (a *Agent) CurrentBackoff() time.Duration {
 	return a.runner.CurrentBackoff()
 }
 
-func (a *Agent) AddRemoteForward(forward string) (bool, error) {
-	trimmed := strings.TrimSpace(forward)
+// AddRemoteForward adds entry, or every forward of a port range, and
+// re-enables it if it was disabled. Members already configured only get
+// their metadata updated; any conflict rejects the whole entry.
+func (a *Agent) AddRemoteForward(entry config.ForwardEntry) (bool, error) {
+	trimmed := strings.TrimSpace(entry.Spec)
 	if trimmed == "" {
 		return false, fmt.Errorf("remote forward is required")
 	}
+	members, err := forward.Expand(trimmed)
+	if err != nil {
+		return false, err
+	}
 	a.forwardMu.Lock()
-	defer a.forwardMu.Unlock()
 	current := config.NormalizeRemoteForwards(a.cfg)
-	for _, existing := range current {
-		if existing == trimmed {
-			return false, nil
+	proxyForward := config.HTTPProxyForward(a.cfg)
+	for _, added := range members {
+		if proxy, err := forward.Parse(proxyForward); err == nil && proxy.Conflicts(added) {
+			a.forwardMu.Unlock()
+			return false, fmt.Errorf("%s binds the same address as the HTTP proxy forward %s", added.Spec, proxyForward)
+		}
+		for _, existing := range current {
+			f, err := forward.Parse(existing)
+			if err != nil || f.Key() == added.Key() {
+				continue
+			}
+			if f.Conflicts(added) {
+				a.forwardMu.Unlock()
+				return false, fmt.Errorf("%s binds the same address as %s", added.Spec, existing)
+			}
 		}
 	}
-	current = append(current, trimmed)
-	config.SetRemoteForwards(a.cfg, current)
+	entry.Spec = trimmed
+	a.cfg.SSH.RemoteForwards = config.UpsertForwardEntry(a.cfg.SSH.RemoteForwards, entry)
+	added := len(config.NormalizeRemoteForwards(a.cfg)) > len(current)
+	a.forwardMu.Unlock()
+	if !added {
+		return false, nil
+	}
+	// A reverse dynamic forward needs its SOCKS server before ssh restarts.
+	a.syncRelays()
 	a.RequestRestart("remote forward added")
 	return true, nil
 }
 
-func (a *Agent) RemoveRemoteForward(forward string) (bool, error) {
-	trimmed := strings.TrimSpace(forward)
+// DisableRemoteForward stops forwarding the entry named or specified by ref
+// but keeps it, with its relay and probe settings, for a later enable.
+func (a *Agent) DisableRemoteForward(ref string) (bool, error) {
+	a.forwardMu.Lock()
+	defer a.forwardMu.Unlock()
+	entries := a.cfg.SSH.RemoteForwards
+	i := config.FindForwardEntry(entries, ref)
+	if i < 0 || !entries[i].IsEnabled() {
+		return false, nil
+	}
+	if len(config.NormalizeRemoteForwards(a.cfg)) == 1 && config.HTTPProxyForward(a.cfg) == "" {
+		return false, fmt.Errorf("at least one remote forward is required")
+	}
+	disabled := false
+	entries[i].Enabled = &disabled
+	a.RequestRestart("remote forward disabled")
+	go a.syncRelays()
+	return true, nil
+}
+
+// RemoveRemoteForward removes value, or every forward of a port range.
+func (a *Agent) RemoveRemoteForward(value string) (bool, error) {
+	trimmed := strings.TrimSpace(value)
 	if trimmed == "" {
 		return false, fmt.Errorf("remote forward is required")
 	}
 	a.forwardMu.Lock()
 	defer a.forwardMu.Unlock()
 	current := config.NormalizeRemoteForwards(a.cfg)
+	removed := config.ForwardKeys(trimmed)
 	next := make([]string, 0, len(current))
-	removed := false
 	for _, existing := range current {
-		if existing == trimmed {
-			removed = true
+		if _, ok := removed[config.ForwardKey(existing)]; ok {
 			continue
 		}
 		next = append(next, existing)
 	}
-	if !removed {
+	if len(next) == len(current) {
 		return false, nil
 	}
-	if len(next) == 0 {
+	if len(next) == 0 && config.HTTPProxyForward(a.cfg) == "" {
 		return false, fmt.Errorf("at least one remote forward is required")
 	}
 	config.SetRemoteForwards(a.cfg, next)
 	a.RequestRestart("remote forward removed")
+	go a.syncRelays()
 	return true, nil
 }
 
@@ -192,6 +404,7 @@ func (a *Agent) ClearRemoteForwards() bool {
 	}
 	config.SetRemoteForwards(a.cfg, nil)
 	a.RequestStop()
+	go a.syncRelays()
 	return true
 }
 
@@ -204,3 +417,34 @@ func (a *Agent) currentRemoteForwards() []string {
 func (a *Agent) RemoteForwards() []string {
 	return a.currentRemoteForwards()
 }
+
+// ForwardLabel returns the configured name of a forward, or its spec.
+func (a *Agent) ForwardLabel(spec string) string {
+	a.forwardMu.Lock()
+	defer a.forwardMu.Unlock()
+	return config.ForwardLabel(a.cfg.SSH.RemoteForwards, spec)
+}
+
+// PortPool describes agent.port_pool usage, or returns "" when no pool is set.
+func (a *Agent) PortPool() string {
+	a.forwardMu.Lock()
+	defer a.forwardMu.Unlock()
+	used, size, err := config.PoolUsage(a.cfg)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s used=%d free=%d", strings.TrimSpace(a.cfg.Agent.PortPool), used, size-used)
+}
+
+// DisabledForwards returns the labels of forwards kept in config but not forwarded.
+func (a *Agent) DisabledForwards() []string {
+	a.forwardMu.Lock()
+	defer a.forwardMu.Unlock()
+	var out []string
+	for _, e := range a.cfg.SSH.RemoteForwards {
+		if !e.IsEnabled() {
+			out = append(out, e.Label())
+		}
+	}
+	return out
+}
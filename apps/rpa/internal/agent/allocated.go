@@ -0,0 +1,185 @@
+// Package agent tracks ports the server assigns to port-0 remote forwards.
+// ssh reports each one as "Allocated port N for remote forward to host:port"; the mapping is kept per session.
+
+package agent
+
+import (
+	"regexp"
+	"strconv"
+	"sync"
+
+	"reverse-proxy-agent/pkg/forward"
+	"reverse-proxy-agent/pkg/statefile"
+)
+
+var allocatedPattern = regexp.MustCompile(`Allocated port (\d+) for remote forward to (\S*)`)
+
+// pendingPort is a port-0 forward of the current session: the configured
+// spec and the target ssh was given, which differs for relayed forwards.
+type pendingPort struct {
+	spec   string
+	target string
+}
+
+type portTracker struct {
+	mu      sync.Mutex
+	pending []pendingPort
+	current map[string]int
+	last    map[string]int
+}
+
+func newPortTracker() *portTracker {
+	return &portTracker{current: make(map[string]int), last: make(map[string]int)}
+}
+
+// begin starts a session with the port-0 forwards in the order they are
+// passed to ssh. Allocations of forwards no longer configured are dropped.
+func (t *portTracker) begin(pending []pendingPort) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = pending
+	t.current = make(map[string]int)
+	keep := make(map[string]int, len(pending))
+	for _, p := range pending {
+		if port, ok := t.last[p.spec]; ok {
+			keep[p.spec] = port
+		}
+	}
+	t.last = keep
+}
+
+// observe records an allocation line and returns the forward, its port and
+// the port it had before, or ok=false.
+func (t *portTracker) observe(line string) (string, int, int, bool) {
+	m := allocatedPattern.FindStringSubmatch(line)
+	if m == nil {
+		return "", 0, 0, false
+	}
+	port, err := strconv.Atoi(m[1])
+	if err != nil {
+		return "", 0, 0, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.pending) == 0 {
+		return "", 0, 0, false
+	}
+	// ssh confirms forwards in request order; the target only helps when
+	// a reply is missing.
+	i := 0
+	for k, p := range t.pending {
+		if p.target == m[2] {
+			i = k
+			break
+		}
+	}
+	spec := t.pending[i].spec
+	t.pending = append(t.pending[:i:i], t.pending[i+1:]...)
+	previous := t.last[spec]
+	t.current[spec] = port
+	t.last[spec] = port
+	return spec, port, previous, true
+}
+
+// ports returns the allocations of the running session.
+func (t *portTracker) ports() map[string]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make(map[string]int, len(t.current))
+	for spec, port := range t.current {
+		out[spec] = port
+	}
+	return out
+}
+
+// lastPorts returns the latest allocation of each forward across sessions.
+func (t *portTracker) lastPorts() map[string]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.last) == 0 {
+		return nil
+	}
+	out := make(map[string]int, len(t.last))
+	for spec, port := range t.last {
+		out[spec] = port
+	}
+	return out
+}
+
+// allocatedTarget returns the target part ssh echoes in its allocation line;
+// ok is false when spec does not ask the server for a port.
+func allocatedTarget(spec string) (string, bool) {
+	f, err := forward.Parse(spec)
+	if err != nil || f.Bind.IsSocket() || f.Bind.Port != 0 {
+		return "", false
+	}
+	if f.Dynamic || f.Target.IsSocket() {
+		return "", true
+	}
+	return f.Target.Host + ":" + strconv.Itoa(f.Target.Port), true
+}
+
+// AllocatedPorts returns the server-assigned port of each port-0 forward in
+// the current session, by label.
+func (a *Agent) AllocatedPorts() map[string]int {
+	out := make(map[string]int)
+	for spec, port := range a.ports.ports() {
+		out[a.ForwardLabel(spec)] = port
+	}
+	return out
+}
+
+// observeSSH feeds ssh output to the origin and port trackers.
+func (a *Agent) observeSSH(line string) {
+	a.origins.observe(line)
+	spec, port, previous, ok := a.ports.observe(line)
+	if !ok {
+		return
+	}
+	a.relayMu.Lock()
+	logger := a.logger
+	a.relayMu.Unlock()
+	label := a.ForwardLabel(spec)
+	name := ""
+	if label != spec {
+		name = label
+	}
+	if logger != nil {
+		logger.Event("INFO", "remote_port_allocated", map[string]any{
+			"forward": label,
+			"port":    port,
+		})
+	}
+	if previous != 0 && previous != port {
+		if logger != nil {
+			logger.Event("WARN", "allocated_port_changed", map[string]any{
+				"forward":  label,
+				"port":     port,
+				"previous": previous,
+			})
+			a.runEventHook(logger, "allocated_port_changed", map[string]string{
+				"RPA_FORWARD":        spec,
+				"RPA_FORWARD_NAME":   name,
+				"RPA_ALLOCATED_PORT": strconv.Itoa(port),
+				"RPA_PREVIOUS_PORT":  strconv.Itoa(previous),
+			})
+		}
+	}
+	a.writeState(nil)
+}
+
+// writeState writes the state file with the last allocations. A nil snap
+// rewrites the latest runner snapshot.
+func (a *Agent) writeState(snap *statefile.Snapshot) {
+	if a.statePath == "" {
+		return
+	}
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	if snap != nil {
+		a.lastState = *snap
+	}
+	next := a.lastState
+	next.AllocatedPorts = a.ports.lastPorts()
+	_ = statefile.Write(a.statePath, next)
+}
@@ -0,0 +1,182 @@
+// Package agent keeps TLS certificates for HTTP proxy routes issued and fresh.
+// Certificates come from the rpa local CA and are renewed before they expire.
+
+package agent
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"reverse-proxy-agent/pkg/ca"
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/httpproxy"
+)
+
+const certCheckInterval = time.Hour
+
+// certStore serves the loaded certificate for the SNI name, falling back to a
+// wildcard certificate for the parent domain.
+type certStore struct {
+	mu    sync.RWMutex
+	certs map[string]*tls.Certificate
+}
+
+func newCertStore() *certStore {
+	return &certStore{certs: make(map[string]*tls.Certificate)}
+}
+
+func (s *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := httpproxy.NormalizeHost(hello.ServerName)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if cert, ok := s.certs[name]; ok {
+		return cert, nil
+	}
+	if i := strings.Index(name, "."); i > 0 {
+		if cert, ok := s.certs["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+	return nil, fmt.Errorf("no certificate for %q", hello.ServerName)
+}
+
+func (s *certStore) set(host string, cert *tls.Certificate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.certs[host] = cert
+}
+
+func (s *certStore) expiries() map[string]time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]time.Time, len(s.certs))
+	for host, cert := range s.certs {
+		out[host] = cert.Leaf.NotAfter
+	}
+	return out
+}
+
+func (a *Agent) tlsEnabled() bool {
+	return a.cfg.Agent.HTTPProxy.TLS.Enabled
+}
+
+func (a *Agent) certHosts() []string {
+	a.forwardMu.Lock()
+	defer a.forwardMu.Unlock()
+	seen := make(map[string]struct{})
+	out := make([]string, 0, len(a.cfg.Agent.HTTPProxy.Routes))
+	for _, rc := range a.cfg.Agent.HTTPProxy.Routes {
+		host := httpproxy.NormalizeHost(rc.Host)
+		if _, ok := seen[host]; ok {
+			continue
+		}
+		seen[host] = struct{}{}
+		out = append(out, host)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// refreshCerts loads the certificate of every route host from the CA dir and
+// issues a new one when it is missing or inside the renewal window. A CA that
+// has not been initialized only disables issuing; existing files still load.
+func (a *Agent) refreshCerts() {
+	if !a.tlsEnabled() {
+		return
+	}
+	a.relayMu.Lock()
+	logger := a.logger
+	a.relayMu.Unlock()
+	if logger == nil {
+		return
+	}
+	dir, err := config.CADir(a.cfg)
+	if err != nil {
+		logger.Event("ERROR", "cert_load_failed", map[string]any{"error": err.Error()})
+		return
+	}
+	tlsCfg := a.cfg.Agent.HTTPProxy.TLS
+	validity := time.Duration(tlsCfg.CertDays) * 24 * time.Hour
+	renewBefore := time.Duration(tlsCfg.RenewDays) * 24 * time.Hour
+
+	var authority *ca.CA
+	var caErr error
+	loadCA := func() (*ca.CA, error) {
+		if authority == nil && caErr == nil {
+			authority, caErr = ca.Load(dir)
+		}
+		return authority, caErr
+	}
+	now := time.Now()
+	for _, host := range a.certHosts() {
+		cert, err := ca.LoadLeaf(dir, host)
+		if err == nil && cert.Leaf.NotAfter.Sub(now) > renewBefore {
+			a.certs.set(host, cert)
+			continue
+		}
+		event := "cert_renewed"
+		if err != nil {
+			event = "cert_issued"
+			cert = nil
+		}
+		issuer, err := loadCA()
+		if err != nil {
+			if cert != nil {
+				a.certs.set(host, cert)
+			}
+			logger.Event("ERROR", "cert_issue_failed", map[string]any{"host": host, "error": err.Error()})
+			continue
+		}
+		if _, err := issuer.Issue(host, validity); err != nil {
+			logger.Event("ERROR", "cert_issue_failed", map[string]any{"host": host, "error": err.Error()})
+			continue
+		}
+		cert, err = ca.LoadLeaf(dir, host)
+		if err != nil {
+			logger.Event("ERROR", "cert_load_failed", map[string]any{"host": host, "error": err.Error()})
+			continue
+		}
+		a.certs.set(host, cert)
+		logger.Event("INFO", event, map[string]any{
+			"host":       host,
+			"expires_at": cert.Leaf.NotAfter.UTC().Format(time.RFC3339),
+		})
+	}
+}
+
+func (a *Agent) rotateCerts(stop <-chan struct{}) {
+	ticker := time.NewTicker(certCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			a.refreshCerts()
+		}
+	}
+}
+
+// CertExpiries returns the expiry of each loaded route certificate.
+func (a *Agent) CertExpiries() map[string]time.Time {
+	if !a.tlsEnabled() {
+		return nil
+	}
+	return a.certs.expiries()
+}
+
+func (a *Agent) proxyTLSConfig() (*tls.Config, error) {
+	a.refreshCerts()
+	if len(a.certs.expiries()) == 0 && len(a.certHosts()) > 0 {
+		return nil, errors.New("no route certificates available (run `rpa ca init`)")
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: a.certs.getCertificate,
+	}, nil
+}
@@ -0,0 +1,157 @@
+// Package agent removes temporary remote forwards once their expires_at passes.
+// Expiry is logged, written back to the config file and passed to agent.event_hook.
+
+package agent
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"time"
+
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/logging"
+)
+
+const (
+	expiryCheckInterval = 5 * time.Second
+	eventHookTimeout    = 30 * time.Second
+)
+
+// SetConfigPath lets the agent write expired forwards out of the config
+// file. Without it expiry only changes the running agent.
+func (a *Agent) SetConfigPath(path string) {
+	a.forwardMu.Lock()
+	defer a.forwardMu.Unlock()
+	a.configPath = path
+}
+
+// ForwardExpiries returns the expiry of each temporary forward by label.
+func (a *Agent) ForwardExpiries() map[string]time.Time {
+	a.forwardMu.Lock()
+	defer a.forwardMu.Unlock()
+	out := make(map[string]time.Time)
+	for _, e := range a.cfg.SSH.RemoteForwards {
+		if e.ExpiresAt != nil && e.IsEnabled() {
+			out[e.Label()] = *e.ExpiresAt
+		}
+	}
+	return out
+}
+
+func (a *Agent) expireLoop(logger *logging.Logger, stop <-chan struct{}) {
+	ticker := time.NewTicker(expiryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			a.expireForwards(logger, now)
+			a.expireGrants(logger, now)
+		}
+	}
+}
+
+// expireForwards drops expired entries and restarts ssh without them, or
+// stops the agent when nothing is left to forward.
+func (a *Agent) expireForwards(logger *logging.Logger, now time.Time) {
+	a.forwardMu.Lock()
+	var expired []config.ForwardEntry
+	kept := make([]config.ForwardEntry, 0, len(a.cfg.SSH.RemoteForwards))
+	for _, e := range a.cfg.SSH.RemoteForwards {
+		if e.Expired(now) {
+			expired = append(expired, e)
+			continue
+		}
+		kept = append(kept, e)
+	}
+	if len(expired) == 0 {
+		a.forwardMu.Unlock()
+		return
+	}
+	a.cfg.SSH.RemoteForwards = kept
+	config.SetRemoteForwards(a.cfg, config.NormalizeRemoteForwards(a.cfg))
+	empty := len(config.NormalizeRemoteForwards(a.cfg)) == 0 && config.HTTPProxyForward(a.cfg) == ""
+	path := a.configPath
+	a.forwardMu.Unlock()
+
+	for _, e := range expired {
+		logger.Event("INFO", "forward_expired", map[string]any{
+			"forward":    e.Spec,
+			"name":       e.Name,
+			"owner":      e.Owner,
+			"expires_at": e.ExpiresAt.UTC().Format(time.RFC3339),
+		})
+		a.runEventHook(logger, "forward_expired", map[string]string{
+			"RPA_FORWARD":       e.Spec,
+			"RPA_FORWARD_NAME":  e.Name,
+			"RPA_FORWARD_OWNER": e.Owner,
+			"RPA_EXPIRES_AT":    e.ExpiresAt.UTC().Format(time.RFC3339),
+		})
+	}
+	if path != "" {
+		if err := removeExpiredFromFile(path, now); err != nil {
+			logger.Event("ERROR", "forward_expiry_save_failed", map[string]any{"error": err.Error()})
+		}
+	}
+	go a.syncRelays()
+	if empty {
+		a.RequestStop()
+		return
+	}
+	a.RequestRestart("remote forward expired")
+}
+
+// removeExpiredFromFile reloads the config so edits made since the agent
+// started are kept.
+func removeExpiredFromFile(path string, now time.Time) error {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+	kept := make([]config.ForwardEntry, 0, len(cfg.SSH.RemoteForwards))
+	for _, e := range cfg.SSH.RemoteForwards {
+		if !e.Expired(now) {
+			kept = append(kept, e)
+		}
+	}
+	if len(kept) == len(cfg.SSH.RemoteForwards) {
+		return nil
+	}
+	cfg.SSH.RemoteForwards = kept
+	config.SetRemoteForwards(cfg, config.NormalizeRemoteForwards(cfg))
+	return config.Save(path, cfg)
+}
+
+// runEventHook runs agent.event_hook in the background with RPA_EVENT and
+// the event's variables added to the environment.
+func (a *Agent) runEventHook(logger *logging.Logger, event string, env map[string]string) {
+	hook := a.cfg.Agent.EventHook
+	if hook == "" {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), eventHookTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, "/bin/sh", "-c", hook)
+		cmd.Env = append(os.Environ(), "RPA_EVENT="+event, "RPA_AGENT="+a.cfg.Agent.Name)
+		for k, v := range env {
+			cmd.Env = append(cmd.Env, k+"="+v)
+		}
+		if out, err := cmd.CombinedOutput(); err != nil {
+			logger.Event("WARN", "event_hook_failed", map[string]any{
+				"event":  event,
+				"error":  err.Error(),
+				"output": truncate(string(out), 512),
+			})
+		}
+	}()
+}
+
+func truncate(value string, max int) string {
+	if len(value) <= max {
+		return value
+	}
+	return value[:max]
+}
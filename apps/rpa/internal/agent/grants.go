@@ -0,0 +1,82 @@
+// Package agent removes expired access grants from the server's authorized_keys.
+// It only connects once the local grant cache says a grant of this server has expired.
+
+package agent
+
+import (
+	"time"
+
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/grant"
+	"reverse-proxy-agent/pkg/logging"
+)
+
+// grantRetryInterval spaces out cleanup attempts while the server is
+// unreachable or the edit keeps conflicting.
+const grantRetryInterval = time.Minute
+
+func (a *Agent) expireGrants(logger *logging.Logger, now time.Time) {
+	path, err := grant.CachePath()
+	if err != nil {
+		return
+	}
+	entries, err := grant.LoadCache(path)
+	if err != nil {
+		logger.Event("WARN", "grant_cleanup_failed", map[string]any{"error": err.Error()})
+		return
+	}
+	server := grant.Server(a.cfg.SSH.User, a.cfg.SSH.Host, a.cfg.SSH.Port)
+	if !grant.Due(entries, server, now) {
+		return
+	}
+
+	a.grantMu.Lock()
+	if a.grantBusy || now.Sub(a.grantLastTry) < grantRetryInterval {
+		a.grantMu.Unlock()
+		return
+	}
+	a.grantBusy = true
+	a.grantLastTry = now
+	a.grantMu.Unlock()
+
+	// Only the connection settings are used, but RemoteForwards changes
+	// under forwardMu, so copy the config while holding it.
+	a.forwardMu.Lock()
+	login := &config.Config{SSH: a.cfg.SSH}
+	a.forwardMu.Unlock()
+	store := grant.NewSSHStore(login)
+
+	go func() {
+		defer func() {
+			a.grantMu.Lock()
+			a.grantBusy = false
+			a.grantMu.Unlock()
+		}()
+		removed, err := grant.RemoveExpired(store, now)
+		if err != nil {
+			logger.Event("WARN", "grant_cleanup_failed", map[string]any{"server": server, "error": err.Error()})
+			return
+		}
+		for _, g := range removed {
+			expiresAt := g.ExpiresAt.UTC().Format(time.RFC3339)
+			logger.Event("INFO", "grant_expired", map[string]any{
+				"server":      server,
+				"name":        g.Name,
+				"fingerprint": g.Fingerprint(),
+				"expires_at":  expiresAt,
+			})
+			a.runEventHook(logger, "grant_expired", map[string]string{
+				"RPA_GRANT_NAME":        g.Name,
+				"RPA_GRANT_FINGERPRINT": g.Fingerprint(),
+				"RPA_EXPIRES_AT":        expiresAt,
+			})
+		}
+		grants, err := grant.List(store)
+		if err == nil {
+			err = grant.SaveCache(path, server, grants)
+		}
+		if err != nil {
+			logger.Event("WARN", "grant_cleanup_failed", map[string]any{"server": server, "error": err.Error()})
+		}
+	}()
+}
@@ -0,0 +1,222 @@
+// Package agent runs the embedded HTTP reverse proxy behind one remote forward.
+// Routes come from agent.http_proxy and can be changed at runtime over IPC.
+
+package agent
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/forward"
+	"reverse-proxy-agent/pkg/httpproxy"
+	"reverse-proxy-agent/pkg/logging"
+)
+
+func routeFromConfig(rc config.RouteConfig) httpproxy.Route {
+	return httpproxy.Route{
+		Host:       strings.TrimSpace(rc.Host),
+		PathPrefix: strings.TrimSpace(rc.PathPrefix),
+		Target:     strings.TrimSpace(rc.Target),
+		Access:     accessFromConfig(rc.Access),
+	}
+}
+
+func accessFromConfig(list []config.AccessConfig) []httpproxy.AccessRule {
+	out := make([]httpproxy.AccessRule, 0, len(list))
+	for _, ac := range list {
+		out = append(out, httpproxy.AccessRule{
+			ID:           ac.ID,
+			Type:         ac.Type,
+			Username:     ac.Username,
+			PasswordHash: ac.PasswordHash,
+			TokenHash:    ac.TokenHash,
+			ExpiresAt:    ac.ExpiresAt,
+		})
+	}
+	return out
+}
+
+func (a *Agent) startHTTPProxy(logger *logging.Logger) {
+	if config.HTTPProxyForward(a.cfg) == "" {
+		return
+	}
+	proxy := httpproxy.New(a.cfg.Agent.HTTPProxy.Listen, logger)
+	if f, err := forward.Parse(config.HTTPProxyForward(a.cfg)); err == nil && !f.Bind.IsSocket() && f.Bind.Port > 0 {
+		port := strconv.Itoa(f.Bind.Port)
+		proxy.Source = func(net.Conn) func() string {
+			return a.origins.reserve(port).resolve
+		}
+	}
+	routes := make([]httpproxy.Route, 0, len(a.cfg.Agent.HTTPProxy.Routes))
+	for _, rc := range a.cfg.Agent.HTTPProxy.Routes {
+		routes = append(routes, routeFromConfig(rc))
+	}
+	secret, _ := hex.DecodeString(a.cfg.Agent.HTTPProxy.LinkSecret)
+	proxy.SetAccessOptions(secret, a.cfg.Agent.HTTPProxy.MaxAuthFailuresPerMin)
+	if err := proxy.SetRoutes(routes); err != nil {
+		logger.Event("ERROR", "http_proxy_start_failed", map[string]any{"error": err.Error()})
+		return
+	}
+	if a.tlsEnabled() {
+		tlsConfig, err := a.proxyTLSConfig()
+		if err != nil {
+			logger.Event("ERROR", "http_proxy_start_failed", map[string]any{"error": err.Error()})
+			return
+		}
+		proxy.TLSConfig = tlsConfig
+	}
+	if err := proxy.Start(); err != nil {
+		logger.Event("ERROR", "http_proxy_start_failed", map[string]any{"error": err.Error()})
+		return
+	}
+	a.proxyMu.Lock()
+	a.proxy = proxy
+	if a.tlsEnabled() {
+		a.certStop = make(chan struct{})
+		go a.rotateCerts(a.certStop)
+	}
+	a.proxyMu.Unlock()
+	logger.Event("INFO", "http_proxy_started", map[string]any{
+		"listen":      proxy.Addr,
+		"remote_bind": a.cfg.Agent.HTTPProxy.RemoteBind,
+		"routes":      len(routes),
+		"tls":         proxy.TLSConfig != nil,
+	})
+}
+
+func (a *Agent) stopHTTPProxy() {
+	a.proxyMu.Lock()
+	proxy := a.proxy
+	a.proxy = nil
+	if a.certStop != nil {
+		close(a.certStop)
+		a.certStop = nil
+	}
+	a.proxyMu.Unlock()
+	if proxy != nil {
+		_ = proxy.Close()
+	}
+}
+
+func (a *Agent) httpProxy() (*httpproxy.Proxy, error) {
+	a.proxyMu.Lock()
+	defer a.proxyMu.Unlock()
+	if a.proxy == nil {
+		return nil, fmt.Errorf("http proxy is not running (set agent.http_proxy.remote_bind)")
+	}
+	return a.proxy, nil
+}
+
+// AddRoute adds or replaces a route and reports whether it was new.
+func (a *Agent) AddRoute(rc config.RouteConfig) (bool, error) {
+	if err := config.ValidateRoute(rc); err != nil {
+		return false, err
+	}
+	proxy, err := a.httpProxy()
+	if err != nil {
+		return false, err
+	}
+	// Deferred first so it runs after forwardMu is released: a new host needs
+	// its certificate before the first TLS handshake.
+	defer a.refreshCerts()
+	a.forwardMu.Lock()
+	defer a.forwardMu.Unlock()
+	route := routeFromConfig(rc)
+	routes := a.cfg.Agent.HTTPProxy.Routes
+	replaced := false
+	for i := range routes {
+		if routeFromConfig(routes[i]).Key() == route.Key() {
+			// Editing a route's target keeps its access rules.
+			if len(rc.Access) == 0 {
+				rc.Access = routes[i].Access
+				route.Access = accessFromConfig(rc.Access)
+			}
+			routes[i] = rc
+			replaced = true
+		}
+	}
+	added, err := proxy.Add(route)
+	if err != nil {
+		return false, err
+	}
+	if !replaced {
+		a.cfg.Agent.HTTPProxy.Routes = append(routes, rc)
+	}
+	return added, nil
+}
+
+func (a *Agent) RemoveRoute(host, pathPrefix string) (bool, error) {
+	if strings.TrimSpace(host) == "" {
+		return false, fmt.Errorf("host is required")
+	}
+	proxy, err := a.httpProxy()
+	if err != nil {
+		return false, err
+	}
+	if !proxy.Remove(strings.TrimSpace(host), strings.TrimSpace(pathPrefix)) {
+		return false, nil
+	}
+	key := httpproxy.Route{Host: host, PathPrefix: strings.TrimSpace(pathPrefix)}.Key()
+	a.forwardMu.Lock()
+	defer a.forwardMu.Unlock()
+	next := make([]config.RouteConfig, 0, len(a.cfg.Agent.HTTPProxy.Routes))
+	for _, rc := range a.cfg.Agent.HTTPProxy.Routes {
+		if routeFromConfig(rc).Key() == key {
+			continue
+		}
+		next = append(next, rc)
+	}
+	a.cfg.Agent.HTTPProxy.Routes = next
+	return true, nil
+}
+
+// SetRouteAccess replaces the access rules of a route. linkSecret, when set,
+// becomes the signing key for links.
+func (a *Agent) SetRouteAccess(host, pathPrefix string, access []config.AccessConfig, linkSecret string) error {
+	rc := config.RouteConfig{Host: host, PathPrefix: pathPrefix, Target: "-", Access: access}
+	if err := config.ValidateRoute(rc); err != nil {
+		return err
+	}
+	proxy, err := a.httpProxy()
+	if err != nil {
+		return err
+	}
+	a.forwardMu.Lock()
+	defer a.forwardMu.Unlock()
+	if linkSecret != "" && linkSecret != a.cfg.Agent.HTTPProxy.LinkSecret {
+		secret, err := hex.DecodeString(linkSecret)
+		if err != nil {
+			return fmt.Errorf("link secret must be hex: %w", err)
+		}
+		a.cfg.Agent.HTTPProxy.LinkSecret = linkSecret
+		proxy.SetAccessOptions(secret, a.cfg.Agent.HTTPProxy.MaxAuthFailuresPerMin)
+	}
+	key := routeFromConfig(rc).Key()
+	for i := range a.cfg.Agent.HTTPProxy.Routes {
+		if routeFromConfig(a.cfg.Agent.HTTPProxy.Routes[i]).Key() == key {
+			a.cfg.Agent.HTTPProxy.Routes[i].Access = access
+			proxy.SetAccess(strings.TrimSpace(host), strings.TrimSpace(pathPrefix), accessFromConfig(access))
+			return nil
+		}
+	}
+	return fmt.Errorf("route not found: %s%s", host, pathPrefix)
+}
+
+// Routes returns the live routing table, or nil when the proxy is not running.
+func (a *Agent) Routes() []httpproxy.Route {
+	a.proxyMu.Lock()
+	proxy := a.proxy
+	a.proxyMu.Unlock()
+	if proxy == nil {
+		return nil
+	}
+	return proxy.Routes()
+}
+
+func (a *Agent) HTTPProxyForward() string {
+	return config.HTTPProxyForward(a.cfg)
+}
@@ -17,6 +17,7 @@ import (
 	"reverse-proxy-agent/internal/agent"
 	"reverse-proxy-agent/pkg/config"
 	"reverse-proxy-agent/pkg/logging"
+	"reverse-proxy-agent/pkg/probe"
 )
 
 type Server struct {
@@ -129,8 +130,16 @@ func (s *Server) handleConn(conn net.Conn) {
 		s.handleAddForward(conn, req.Args)
 	case "remove_forward":
 		s.handleRemoveForward(conn, req.Args)
+	case "disable_forward":
+		s.handleDisableForward(conn, req.Args)
 	case "clear_forwards":
 		s.handleClearForwards(conn)
+	case "add_route":
+		s.handleAddRoute(conn, req.Args)
+	case "remove_route":
+		s.handleRemoveRoute(conn, req.Args)
+	case "set_access":
+		s.handleSetAccess(conn, req.Args)
 	default:
 		writeResponse(conn, response{OK: false, Message: "unknown command"})
 	}
@@ -148,7 +157,43 @@ func (s *Server) handleStatus(conn net.Conn) {
 		"last_class":   s.agent.LastClass(),
 		"last_trigger": s.agent.LastTriggerReason(),
 	}
-	data["remote_forwards"] = strings.Join(s.agent.RemoteForwards(), ",")
+	forwards := s.agent.RemoteForwards()
+	labels := make([]string, 0, len(forwards))
+	for _, forward := range forwards {
+		labels = append(labels, s.agent.ForwardLabel(forward))
+	}
+	data["remote_forwards"] = strings.Join(labels, ",")
+	if disabled := s.agent.DisabledForwards(); len(disabled) > 0 {
+		data["disabled_forwards"] = strings.Join(disabled, ",")
+	}
+	for label, port := range s.agent.AllocatedPorts() {
+		data["allocated."+label] = fmt.Sprintf("%d", port)
+	}
+	if pool := s.agent.PortPool(); pool != "" {
+		data["port_pool"] = pool
+	}
+	for label, expires := range s.agent.ForwardExpiries() {
+		data["expires."+label] = fmt.Sprintf("at=%s remaining=%s", expires.UTC().Format(time.RFC3339), formatRemaining(time.Until(expires)))
+	}
+	if relays := s.agent.Relays(); len(relays) > 0 {
+		data["relays"] = strings.Join(relays, ",")
+	}
+	for forward, mode := range s.agent.ReverseSOCKS() {
+		data["socks."+s.agent.ForwardLabel(forward)] = mode
+	}
+	if forward := s.agent.HTTPProxyForward(); forward != "" {
+		data["http_proxy"] = forward
+		for _, route := range s.agent.Routes() {
+			value := route.Target
+			if len(route.Access) > 0 {
+				value += fmt.Sprintf(" access=%d", len(route.Access))
+			}
+			data["route."+route.Host+route.PathPrefix] = value
+		}
+		for host, expires := range s.agent.CertExpiries() {
+			data["cert."+host] = fmt.Sprintf("expires=%s remaining=%s", expires.UTC().Format(time.RFC3339), formatRemaining(time.Until(expires)))
+		}
+	}
 	if !s.agent.LastSuccess().IsZero() {
 		data["last_success_unix"] = fmt.Sprintf("%d", s.agent.LastSuccess().Unix())
 	}
@@ -161,6 +206,22 @@ func (s *Server) handleStatus(conn net.Conn) {
 			data["tcp_check_unix"] = fmt.Sprintf("%d", at.Unix())
 		}
 	}
+	if periodic := s.agent.PeriodicStatus(); !periodic.Next.IsZero() {
+		data["periodic_next_unix"] = fmt.Sprintf("%d", periodic.Next.Unix())
+		if periodic.DeferReason != "" {
+			deferred := fmt.Sprintf("%s since_unix=%d", periodic.DeferReason, periodic.DeferredSince.Unix())
+			if periodic.ActiveConnections > 0 {
+				deferred += fmt.Sprintf(" active_connections=%d", periodic.ActiveConnections)
+			}
+			data["periodic_deferred"] = deferred
+		}
+	}
+	for _, st := range s.agent.ProbeStatuses() {
+		data[probe.StatusKey(s.agent.ForwardLabel(st.Forward), st)] = probe.FormatStatus(st)
+	}
+	for forward, st := range s.agent.ForwardStats() {
+		data["forward."+s.agent.ForwardLabel(forward)] = st.String()
+	}
 	if backoff := s.agent.CurrentBackoff(); backoff > 0 {
 		data["backoff_ms"] = fmt.Sprintf("%d", backoff.Milliseconds())
 	}
@@ -184,9 +245,36 @@ func (s *Server) handleMetrics(conn net.Conn) {
 	if backoff := s.agent.CurrentBackoff(); backoff > 0 {
 		data["rpa_agent_backoff_ms"] = fmt.Sprintf("%d", backoff.Milliseconds())
 	}
+	for forward, st := range s.agent.ForwardStats() {
+		label := fmt.Sprintf("{forward=%q}", forward)
+		data["rpa_agent_forward_accepted_total"+label] = fmt.Sprintf("%d", st.Accepted)
+		data["rpa_agent_forward_active"+label] = fmt.Sprintf("%d", st.Active)
+		data["rpa_agent_forward_bytes_in_total"+label] = fmt.Sprintf("%d", st.BytesIn)
+		data["rpa_agent_forward_bytes_out_total"+label] = fmt.Sprintf("%d", st.BytesOut)
+		data["rpa_agent_forward_connect_errors_total"+label] = fmt.Sprintf("%d", st.ConnectErrors)
+		data["rpa_agent_forward_rejected_total"+label] = fmt.Sprintf("%d", st.Rejected)
+		data["rpa_agent_forward_closed_total"+label] = fmt.Sprintf("%d", st.Closed)
+		data["rpa_agent_forward_duration_sec_total"+label] = fmt.Sprintf("%.3f", st.Duration.Seconds())
+		if !st.LastAccepted.IsZero() {
+			data["rpa_agent_forward_last_accepted_unix"+label] = fmt.Sprintf("%d", st.LastAccepted.Unix())
+		}
+	}
+	for host, expires := range s.agent.CertExpiries() {
+		data[fmt.Sprintf("rpa_agent_cert_expiry_unix{host=%q}", host)] = fmt.Sprintf("%d", expires.Unix())
+	}
 	writeResponse(conn, response{OK: true, Data: data})
 }
 
+func formatRemaining(d time.Duration) string {
+	if d <= 0 {
+		return "expired"
+	}
+	if d >= 24*time.Hour {
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+	return d.Truncate(time.Minute).String()
+}
+
 func (s *Server) handleLogs(conn net.Conn) {
 	writeResponse(conn, response{OK: true, Logs: s.logs.List()})
 }
@@ -197,11 +285,17 @@ func (s *Server) handleStop(conn net.Conn) {
 }
 
 func (s *Server) handleAddForward(conn net.Conn, args map[string]string) {
-	forward := ""
+	var entry config.ForwardEntry
+	if args != nil && args["entry"] != "" {
+		if err := json.Unmarshal([]byte(args["entry"]), &entry); err != nil {
+			writeResponse(conn, response{OK: false, Message: fmt.Sprintf("invalid entry: %v", err)})
+			return
+		}
+	}
 	if args != nil {
-		forward = args["remote_forward"]
+		entry.Spec = args["remote_forward"]
 	}
-	added, err := s.agent.AddRemoteForward(forward)
+	added, err := s.agent.AddRemoteForward(entry)
 	if err != nil {
 		writeResponse(conn, response{OK: false, Message: err.Error()})
 		return
@@ -238,6 +332,27 @@ func (s *Server) handleRemoveForward(conn net.Conn, args map[string]string) {
 	})
 }
 
+func (s *Server) handleDisableForward(conn net.Conn, args map[string]string) {
+	forward := ""
+	if args != nil {
+		forward = args["remote_forward"]
+	}
+	disabled, err := s.agent.DisableRemoteForward(forward)
+	if err != nil {
+		writeResponse(conn, response{OK: false, Message: err.Error()})
+		return
+	}
+	msg := "remote forward not enabled"
+	if disabled {
+		msg = "remote forward disabled"
+	}
+	writeResponse(conn, response{
+		OK:      true,
+		Message: msg,
+		Data:    map[string]string{"disabled": fmt.Sprintf("%t", disabled)},
+	})
+}
+
 func (s *Server) handleClearForwards(conn net.Conn) {
 	cleared := s.agent.ClearRemoteForwards()
 	msg := "no remote forwards to clear"
@@ -251,6 +366,60 @@ func (s *Server) handleClearForwards(conn net.Conn) {
 	})
 }
 
+func (s *Server) handleAddRoute(conn net.Conn, args map[string]string) {
+	rc := config.RouteConfig{
+		Host:       args["host"],
+		PathPrefix: args["path_prefix"],
+		Target:     args["target"],
+	}
+	added, err := s.agent.AddRoute(rc)
+	if err != nil {
+		writeResponse(conn, response{OK: false, Message: err.Error()})
+		return
+	}
+	msg := "route updated"
+	if added {
+		msg = "route added"
+	}
+	writeResponse(conn, response{
+		OK:      true,
+		Message: msg,
+		Data:    map[string]string{"added": fmt.Sprintf("%t", added)},
+	})
+}
+
+func (s *Server) handleRemoveRoute(conn net.Conn, args map[string]string) {
+	removed, err := s.agent.RemoveRoute(args["host"], args["path_prefix"])
+	if err != nil {
+		writeResponse(conn, response{OK: false, Message: err.Error()})
+		return
+	}
+	msg := "route not found"
+	if removed {
+		msg = "route removed"
+	}
+	writeResponse(conn, response{
+		OK:      true,
+		Message: msg,
+		Data:    map[string]string{"removed": fmt.Sprintf("%t", removed)},
+	})
+}
+
+func (s *Server) handleSetAccess(conn net.Conn, args map[string]string) {
+	var access []config.AccessConfig
+	if raw := args["access"]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &access); err != nil {
+			writeResponse(conn, response{OK: false, Message: fmt.Sprintf("invalid access: %v", err)})
+			return
+		}
+	}
+	if err := s.agent.SetRouteAccess(args["host"], args["path_prefix"], access, args["link_secret"]); err != nil {
+		writeResponse(conn, response{OK: false, Message: err.Error()})
+		return
+	}
+	writeResponse(conn, response{OK: true, Message: "access updated"})
+}
+
 func writeResponse(conn net.Conn, resp response) {
 	enc := json.NewEncoder(conn)
 	_ = enc.Encode(resp)
@@ -0,0 +1,388 @@
+// Package agent runs audit relays in front of remote forward targets.
+// ssh is pointed at a loopback relay that records each connection before dialing the real target.
+
+package agent
+
+import (
+	"context"
+	"io"
+	"net"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"reverse-proxy-agent/pkg/audit"
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/forward"
+	"reverse-proxy-agent/pkg/logging"
+	"reverse-proxy-agent/pkg/relay"
+)
+
+const (
+	originWait   = 500 * time.Millisecond
+	originMaxAge = 30 * time.Second
+)
+
+// originatorPattern matches the `ssh -v` line logged when the server opens a
+// forwarded-tcpip channel, which is the only place the real peer is visible.
+var originatorPattern = regexp.MustCompile(`client_request_forwarded_tcpip: listen (\S+) port (\d+), originator (\S+) port (\d+)`)
+
+type originator struct {
+	source string
+	at     time.Time
+}
+
+// originTracker pairs channel-open lines with relay accepts. ssh connects to the
+// relay right after logging the originator, so per listen port the order of
+// lines matches the order of accepts; accepts reserve a ticket in that order
+// and resolve it later on their own goroutine.
+type originTracker struct {
+	mu      sync.Mutex
+	pending map[string][]originator
+	waiting map[string][]*originTicket
+}
+
+// originTicket is one accepted connection waiting for its originator line.
+type originTicket struct {
+	tracker *originTracker
+	port    string
+	source  chan string
+}
+
+func newOriginTracker() *originTracker {
+	return &originTracker{
+		pending: make(map[string][]originator),
+		waiting: make(map[string][]*originTicket),
+	}
+}
+
+func (t *originTracker) observe(line string) {
+	m := originatorPattern.FindStringSubmatch(line)
+	if m == nil {
+		return
+	}
+	source := net.JoinHostPort(m[3], m[4])
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if waiting := t.waiting[m[2]]; len(waiting) > 0 {
+		waiting[0].source <- source
+		t.waiting[m[2]] = waiting[1:]
+		return
+	}
+	t.pending[m[2]] = append(t.fresh(m[2]), originator{source: source, at: time.Now()})
+}
+
+// reserve queues a ticket for a connection accepted on port; it must be
+// called in accept order.
+func (t *originTracker) reserve(port string) *originTicket {
+	ticket := &originTicket{tracker: t, port: port, source: make(chan string, 1)}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if queue := t.fresh(port); len(queue) > 0 {
+		ticket.source <- queue[0].source
+		t.pending[port] = queue[1:]
+	} else {
+		t.pending[port] = queue
+		t.waiting[port] = append(t.waiting[port], ticket)
+	}
+	return ticket
+}
+
+// fresh drops originators nobody claimed within originMaxAge; it is called
+// with mu held.
+func (t *originTracker) fresh(port string) []originator {
+	queue := t.pending[port]
+	for len(queue) > 0 && time.Since(queue[0].at) > originMaxAge {
+		queue = queue[1:]
+	}
+	return queue
+}
+
+// resolve waits up to originWait for the ticket's originator.
+func (ticket *originTicket) resolve() string {
+	timer := time.NewTimer(originWait)
+	defer timer.Stop()
+	select {
+	case source := <-ticket.source:
+		return source
+	case <-timer.C:
+	}
+	t := ticket.tracker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	waiting := t.waiting[ticket.port]
+	for i, w := range waiting {
+		if w == ticket {
+			t.waiting[ticket.port] = append(waiting[:i:i], waiting[i+1:]...)
+			return "unknown"
+		}
+	}
+	// observe handed over a source while the timer fired.
+	return <-ticket.source
+}
+
+func (a *Agent) buildCommand() (*exec.Cmd, error) {
+	forwards := a.currentRemoteForwards()
+	wanted := a.relayConfigs()
+	a.relayMu.Lock()
+	verbose := len(a.relays) > 0
+	kept := forwards[:0]
+	var pending []pendingPort
+	for _, value := range forwards {
+		spec := value
+		if ln, ok := a.relays[value]; ok {
+			spec = relayForwardSpec(value, ln.BoundAddr())
+		} else if _, ok := wanted[value]; ok {
+			// Never hand ssh a forward whose limits, audit or SOCKS
+			// allowlist would be skipped because its relay is not running.
+			if a.logger != nil {
+				a.logger.Event("ERROR", "forward_skipped", map[string]any{
+					"forward": value,
+					"reason":  "relay not running",
+				})
+			}
+			continue
+		}
+		if target, ok := allocatedTarget(spec); ok {
+			pending = append(pending, pendingPort{spec: value, target: target})
+		}
+		kept = append(kept, spec)
+	}
+	forwards = kept
+	a.relayMu.Unlock()
+	if proxyForward := config.HTTPProxyForward(a.cfg); proxyForward != "" {
+		forwards = append(forwards, proxyForward)
+		// The proxy counts failed logins per originator.
+		verbose = true
+	}
+	a.ports.begin(pending)
+	return buildSSHCommand(a.cfg, forwards, verbose)
+}
+
+// relayForwardSpec keeps the bind part of a remote forward and points its
+// target at the relay listener.
+func relayForwardSpec(value, relayAddr string) string {
+	_, port, err := net.SplitHostPort(relayAddr)
+	if err != nil {
+		return value
+	}
+	f, err := forward.Parse(value)
+	if err != nil {
+		return value
+	}
+	return f.BindSpec() + ":127.0.0.1:" + port
+}
+
+// isReverseDynamic reports whether a remote forward has no destination, so
+// the server side acts as a SOCKS proxy into this network.
+func isReverseDynamic(value string) bool {
+	f, err := forward.Parse(value)
+	return err == nil && f.Dynamic
+}
+
+func containsForward(forwards []string, value string) bool {
+	for _, f := range forwards {
+		if f == strings.TrimSpace(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Agent) relayConfigs() map[string]config.RelayConfig {
+	a.forwardMu.Lock()
+	defer a.forwardMu.Unlock()
+	enabled := config.NormalizeRemoteForwards(a.cfg)
+	out := make(map[string]config.RelayConfig, len(a.cfg.Agent.Relays))
+	for _, rc := range a.cfg.Agent.Relays {
+		if value := strings.TrimSpace(rc.Forward); containsForward(enabled, value) {
+			out[value] = rc
+		}
+	}
+	if len(a.cfg.Agent.SOCKSAllow) > 0 {
+		for _, value := range enabled {
+			if _, ok := out[value]; !ok && isReverseDynamic(value) {
+				out[value] = config.RelayConfig{Forward: value}
+			}
+		}
+	}
+	return out
+}
+
+// syncRelays starts relays for newly configured forwards and closes relays
+// whose forward has been removed.
+func (a *Agent) syncRelays() {
+	wanted := a.relayConfigs()
+
+	a.relayMu.Lock()
+	defer a.relayMu.Unlock()
+	logger := a.logger
+	if logger == nil {
+		return
+	}
+	for forward, ln := range a.relays {
+		if _, ok := wanted[forward]; ok {
+			continue
+		}
+		_ = ln.Close()
+		delete(a.relays, forward)
+		logger.Event("INFO", "relay_stopped", map[string]any{"forward": forward})
+	}
+	for forward, rc := range wanted {
+		if _, ok := a.relays[forward]; ok {
+			continue
+		}
+		ln, err := a.startRelay(rc, logger)
+		if err != nil {
+			logger.Event("ERROR", "relay_start_failed", map[string]any{
+				"forward": forward,
+				"error":   err.Error(),
+			})
+			continue
+		}
+		a.relays[forward] = ln
+		logger.Event("INFO", "relay_started", map[string]any{
+			"forward": forward,
+			"addr":    ln.BoundAddr(),
+		})
+	}
+}
+
+// startRelay is called with relayMu held.
+func (a *Agent) startRelay(rc config.RelayConfig, logger *logging.Logger) (*relay.Listener, error) {
+	f, err := forward.Parse(rc.Forward)
+	if err != nil {
+		return nil, err
+	}
+	if f.Dynamic {
+		return a.startSOCKSRelay(f, rc, logger)
+	}
+	network, target := f.Target.Network(), f.Target.Address()
+	ln := relay.NewListener(f.Spec, "127.0.0.1:0", func(ctx context.Context) (io.ReadWriteCloser, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, network, target)
+	}, logger)
+	a.configureRelay(ln, rc, f)
+	if err := ln.Start(); err != nil {
+		return nil, err
+	}
+	return ln, nil
+}
+
+// configureRelay applies the shared stats, limits, origin lookup and audit
+// hooks; it is called with relayMu held.
+func (a *Agent) configureRelay(ln *relay.Listener, rc config.RelayConfig, f forward.Forward) {
+	name := ln.Name
+	ln.DialTimeout = 10 * time.Second
+	stats, ok := a.relayStats[name]
+	if !ok {
+		stats = &relay.Stats{}
+		a.relayStats[name] = stats
+	}
+	ln.Stats = stats
+	ln.Limits = relay.Limits{
+		MaxConnections:    rc.MaxConnections,
+		ConnectionsPerMin: rc.ConnectionsPerMin,
+		BytesPerSec:       int64(rc.BandwidthKbps) * 1000 / 8,
+	}
+	spec := strings.TrimSpace(rc.Forward)
+	ln.Source = func(net.Conn) func() string {
+		if f.Bind.IsSocket() {
+			// ssh reports no originator for Unix socket binds.
+			return func() string { return "unix" }
+		}
+		port := f.Bind.Port
+		if port == 0 {
+			// ssh reports server-allocated forwards under the assigned port.
+			port = a.ports.ports()[spec]
+		}
+		return a.origins.reserve(strconv.Itoa(port)).resolve
+	}
+	ln.OnAccept = func(c relay.Conn) {
+		a.recordAudit(name, audit.EventOpen, c)
+	}
+	ln.OnDone = func(c relay.Conn) {
+		a.recordAudit(name, audit.EventClose, c)
+	}
+}
+
+func (a *Agent) recordAudit(forward, event string, c relay.Conn) {
+	rec := audit.Record{
+		Time:       c.Started.UTC(),
+		Event:      event,
+		Forward:    forward,
+		Source:     c.Source,
+		DurationMs: c.Duration.Milliseconds(),
+		BytesIn:    c.BytesIn,
+		BytesOut:   c.BytesOut,
+	}
+	if c.Err != nil {
+		rec.Error = c.Err.Error()
+	}
+	a.relayMu.Lock()
+	writer := a.audit
+	logger := a.logger
+	a.relayMu.Unlock()
+	if writer == nil {
+		return
+	}
+	if err := writer.Append(rec); err != nil && logger != nil {
+		logger.Event("ERROR", "audit_write_failed", map[string]any{
+			"forward": forward,
+			"error":   err.Error(),
+		})
+	}
+}
+
+// Relays returns the forwards that currently have a relay listening.
+func (a *Agent) Relays() []string {
+	a.relayMu.Lock()
+	defer a.relayMu.Unlock()
+	out := make([]string, 0, len(a.relays))
+	for forward := range a.relays {
+		out = append(out, forward)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ForwardStats returns traffic counters for every forward that has had a
+// relay since the agent started.
+func (a *Agent) ForwardStats() map[string]relay.StatsSnapshot {
+	a.relayMu.Lock()
+	defer a.relayMu.Unlock()
+	out := make(map[string]relay.StatsSnapshot, len(a.relayStats))
+	for forward, stats := range a.relayStats {
+		out[forward] = stats.Snapshot()
+	}
+	return out
+}
+
+func (a *Agent) stopRelays() {
+	a.relayMu.Lock()
+	defer a.relayMu.Unlock()
+	for forward, ln := range a.relays {
+		_ = ln.Close()
+		delete(a.relays, forward)
+	}
+}
+
+func (a *Agent) setRelayLogger(logger *logging.Logger) {
+	a.relayMu.Lock()
+	defer a.relayMu.Unlock()
+	a.logger = logger
+	if len(a.cfg.Agent.Relays) == 0 && len(a.cfg.Agent.SOCKSAllow) == 0 {
+		return
+	}
+	path, err := config.AuditPath(a.cfg)
+	if err == nil {
+		a.audit, err = audit.NewWriter(path)
+	}
+	if err != nil {
+		logger.Event("ERROR", "audit_open_failed", map[string]any{"error": err.Error()})
+	}
+}
@@ -0,0 +1,44 @@
+package agent
+
+import (
+	"fmt"
+	"testing"
+)
+
+func originLine(port, peer int) string {
+	return fmt.Sprintf("debug1: client_request_forwarded_tcpip: listen localhost port %d, originator 203.0.113.%d port 5000", port, peer)
+}
+
+func TestOriginTicketsFollowAcceptOrder(t *testing.T) {
+	tracker := newOriginTracker()
+	tracker.observe(originLine(8080, 1))
+	first := tracker.reserve("8080")
+	second := tracker.reserve("8080")
+	third := tracker.reserve("8080")
+	tracker.observe(originLine(8080, 2))
+	tracker.observe(originLine(9090, 9))
+	tracker.observe(originLine(8080, 3))
+
+	// Resolve out of accept order, as connection goroutines may.
+	if got := third.resolve(); got != "203.0.113.3:5000" {
+		t.Errorf("third = %s", got)
+	}
+	if got := first.resolve(); got != "203.0.113.1:5000" {
+		t.Errorf("first = %s", got)
+	}
+	if got := second.resolve(); got != "203.0.113.2:5000" {
+		t.Errorf("second = %s", got)
+	}
+}
+
+func TestOriginTicketTimesOut(t *testing.T) {
+	tracker := newOriginTracker()
+	if got := tracker.reserve("8080").resolve(); got != "unknown" {
+		t.Fatalf("resolve = %s, want unknown", got)
+	}
+	// A line arriving after the timeout goes to the next connection.
+	tracker.observe(originLine(8080, 4))
+	if got := tracker.reserve("8080").resolve(); got != "203.0.113.4:5000" {
+		t.Fatalf("resolve = %s", got)
+	}
+}
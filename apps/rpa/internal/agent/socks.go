@@ -0,0 +1,64 @@
+// Package agent serves reverse dynamic forwards through an rpa SOCKS server when agent.socks_allow is set.
+// ssh forwards the server-side bind to a loopback listener that only connects to allowed destinations.
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/forward"
+	"reverse-proxy-agent/pkg/logging"
+	"reverse-proxy-agent/pkg/relay"
+	"reverse-proxy-agent/pkg/socks"
+)
+
+// startSOCKSRelay is called with relayMu held.
+func (a *Agent) startSOCKSRelay(f forward.Forward, rc config.RelayConfig, logger *logging.Logger) (*relay.Listener, error) {
+	allow, err := socks.ParseAllowlist(a.cfg.Agent.SOCKSAllow)
+	if err != nil {
+		return nil, err
+	}
+	ln := relay.NewListener(f.Spec, "127.0.0.1:0", nil, logger)
+	ln.Handshake = func(conn net.Conn) (relay.DialFunc, error) {
+		req, err := socks.ServerConnect(context.Background(), conn, allow)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context) (io.ReadWriteCloser, error) {
+			return req.Dial(ctx)
+		}, nil
+	}
+	a.configureRelay(ln, rc, f)
+	if err := ln.Start(); err != nil {
+		return nil, err
+	}
+	return ln, nil
+}
+
+// ReverseSOCKS describes how each reverse dynamic forward is served: by rpa
+// with the allowlist, or by ssh without any destination limit.
+func (a *Agent) ReverseSOCKS() map[string]string {
+	forwards := a.currentRemoteForwards()
+	rules := len(a.cfg.Agent.SOCKSAllow)
+	a.relayMu.Lock()
+	defer a.relayMu.Unlock()
+	out := make(map[string]string)
+	for _, value := range forwards {
+		if !isReverseDynamic(value) {
+			continue
+		}
+		switch _, running := a.relays[value]; {
+		case rules == 0:
+			out[value] = "ssh (unrestricted)"
+		case running:
+			out[value] = fmt.Sprintf("rpa (allowlist=%d)", rules)
+		default:
+			out[value] = "disabled (socks server not running)"
+		}
+	}
+	return out
+}
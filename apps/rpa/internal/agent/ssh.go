@@ -4,20 +4,22 @@
 package agent
 
 import (
-	"fmt"
-	"os"
 	"os/exec"
-	"path/filepath"
-	"strconv"
 	"strings"
 
 	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/sshutil"
 )
 
-func buildSSHCommand(cfg *config.Config, remoteForwards []string) (*exec.Cmd, error) {
+// buildSSHCommand starts the agent session. verbose adds `-v` so that relays can
+// read the originator of each forwarded channel from stderr.
+func buildSSHCommand(cfg *config.Config, remoteForwards []string, verbose bool) (*exec.Cmd, error) {
 	if err := config.ValidateAgent(cfg); err != nil {
 		return nil, err
 	}
+	if err := sshutil.SyncKnownHosts(cfg); err != nil {
+		return nil, err
+	}
 
 	args := []string{
 		"-N",
@@ -32,41 +34,39 @@ func buildSSHCommand(cfg *config.Config, remoteForwards []string) (*exec.Cmd, er
 		}
 		args = append(args, "-R", forward)
 	}
+	args = append(args, streamLocalArgs(cfg, remoteForwards)...)
 
-	if cfg.SSH.IdentityFile != "" {
-		args = append(args, "-i", expandTilde(cfg.SSH.IdentityFile))
-	}
-
-	for _, opt := range cfg.SSH.Options {
-		if strings.TrimSpace(opt) == "" {
-			continue
-		}
-		args = append(args, "-o", opt)
-	}
-
-	if cfg.SSH.Port > 0 {
-		args = append(args, "-p", strconv.Itoa(cfg.SSH.Port))
+	if verbose {
+		args = append(args, "-v")
 	}
 
-	userHost := cfg.SSH.Host
-	if cfg.SSH.User != "" {
-		userHost = fmt.Sprintf("%s@%s", cfg.SSH.User, cfg.SSH.Host)
-	}
-
-	args = append(args, userHost)
+	args = append(args, sshutil.ConnectionArgs(cfg)...)
 	return exec.Command("ssh", args...), nil
 }
 
-func expandTilde(path string) string {
-	if path == "" || path[0] != '~' {
-		return path
+// buildStdioCommand opens a single `ssh -W` stream to target on the server side,
+// which lets probes reach loopback-bound remote forwards through the tunnel.
+func buildStdioCommand(cfg *config.Config, target string) *exec.Cmd {
+	args := []string{
+		"-T",
+		"-o", "BatchMode=yes",
+		"-o", "ClearAllForwardings=yes",
+		"-W", target,
 	}
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return path
+	args = append(args, sshutil.ConnectionArgs(cfg)...)
+	return exec.Command("ssh", args...)
+}
+
+// streamLocalArgs asks ssh to replace stale Unix sockets left by a previous
+// session. For remote socket binds sshd decides, so this only covers the
+// server when its own StreamLocalBindUnlink is enabled too.
+func streamLocalArgs(cfg *config.Config, forwards []string) []string {
+	if !config.HasSocketBind(forwards) {
+		return nil
 	}
-	if path == "~" {
-		return home
+	args := []string{"-o", "StreamLocalBindUnlink=yes"}
+	if mask := strings.TrimSpace(cfg.SSH.StreamLocalBindMask); mask != "" {
+		args = append(args, "-o", "StreamLocalBindMask="+mask)
 	}
-	return filepath.Join(home, path[2:])
+	return args
 }
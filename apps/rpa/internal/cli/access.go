@@ -0,0 +1,303 @@
+// Package cli implements `rpa agent access`, which manages credentials on HTTP proxy routes.
+// Secrets are shown once on creation; config only keeps hashes.
+
+package cli
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/httpproxy"
+	ipcclient "reverse-proxy-agent/pkg/ipc/agent"
+)
+
+func runAgentAccess(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "missing access subcommand (add|remove|list)")
+		return exitUsage
+	}
+	switch args[0] {
+	case "add":
+		return runAgentAccessAdd(args[1:])
+	case "remove":
+		return runAgentAccessRemove(args[1:])
+	case "list":
+		return runAgentAccessList(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown access subcommand: %s\n", args[0])
+		return exitUsage
+	}
+}
+
+func runAgentAccessAdd(args []string) int {
+	fs := flag.NewFlagSet("agent access add", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	host := fs.String("host", "", "route host (required)")
+	pathPrefix := fs.String("path-prefix", "", "route path prefix")
+	kind := fs.String("type", "", "basic|bearer|link (required)")
+	id := fs.String("id", "", "name for this credential (default: username or a generated id)")
+	user := fs.String("user", "", "username for basic auth")
+	passwordStdin := fs.Bool("password-stdin", false, "read the basic auth password from stdin")
+	ttl := fs.Duration("ttl", 0, "credential lifetime, e.g. 24h (links default to 24h)")
+	baseURL := fs.String("base-url", "", "public URL of the route for printed links (default http://<host>:<remote port>)")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if strings.TrimSpace(*host) == "" {
+		fmt.Fprintln(os.Stderr, "host is required")
+		return exitUsage
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		return exitError
+	}
+	route := findRoute(cfg, *host, *pathPrefix)
+	if route == nil {
+		fmt.Fprintf(os.Stderr, "route not found: %s%s (add it with `rpa agent route add`)\n", *host, *pathPrefix)
+		return exitError
+	}
+
+	ac := config.AccessConfig{ID: strings.TrimSpace(*id), Type: strings.TrimSpace(*kind)}
+	if *ttl > 0 {
+		ac.ExpiresAt = time.Now().Add(*ttl).UTC().Truncate(time.Second)
+	}
+	secret := ""
+	switch ac.Type {
+	case "basic":
+		ac.Username = strings.TrimSpace(*user)
+		if ac.Username == "" {
+			fmt.Fprintln(os.Stderr, "--user is required for basic access")
+			return exitUsage
+		}
+		password, err := readPassword(*passwordStdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "read password failed: %v\n", err)
+			return exitError
+		}
+		if ac.PasswordHash, err = httpproxy.HashPassword(password); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return exitError
+		}
+		if ac.ID == "" {
+			ac.ID = ac.Username
+		}
+	case "bearer":
+		secret = randomHex(32)
+		ac.TokenHash = httpproxy.HashToken(secret)
+		if ac.ID == "" {
+			ac.ID = "token-" + randomHex(3)
+		}
+	case "link":
+		if ac.ExpiresAt.IsZero() {
+			ac.ExpiresAt = time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+		}
+		if cfg.Agent.HTTPProxy.LinkSecret == "" {
+			cfg.Agent.HTTPProxy.LinkSecret = randomHex(32)
+		}
+		if ac.ID == "" {
+			ac.ID = "link-" + randomHex(3)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "--type must be basic, bearer, or link")
+		return exitUsage
+	}
+
+	next := make([]config.AccessConfig, 0, len(route.Access)+1)
+	for _, existing := range route.Access {
+		if existing.ID != ac.ID {
+			next = append(next, existing)
+		}
+	}
+	route.Access = append(next, ac)
+	if err := config.ValidateRoute(*route); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid access: %v\n", err)
+		return exitUsage
+	}
+	if err := config.Save(*configPath, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
+		return exitError
+	}
+
+	fmt.Printf("access %s (%s) added to %s%s\n", ac.ID, ac.Type, route.Host, route.PathPrefix)
+	if !ac.ExpiresAt.IsZero() {
+		fmt.Printf("expires: %s\n", ac.ExpiresAt.Format(time.RFC3339))
+	}
+	switch ac.Type {
+	case "bearer":
+		fmt.Printf("token (shown once): %s\n", secret)
+		fmt.Printf("use: Authorization: Bearer %s\n", secret)
+	case "link":
+		key, _ := hex.DecodeString(cfg.Agent.HTTPProxy.LinkSecret)
+		token := httpproxy.SignLink(key, httpproxy.Route{Host: route.Host, PathPrefix: route.PathPrefix},
+			httpproxy.AccessRule{ID: ac.ID, ExpiresAt: ac.ExpiresAt})
+		fmt.Printf("link: %s\n", routeLink(cfg, route, *baseURL, token))
+	}
+	return pushRouteAccess(cfg, route)
+}
+
+func runAgentAccessRemove(args []string) int {
+	fs := flag.NewFlagSet("agent access remove", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	host := fs.String("host", "", "route host (required)")
+	pathPrefix := fs.String("path-prefix", "", "route path prefix")
+	id := fs.String("id", "", "credential id (required)")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if strings.TrimSpace(*host) == "" || strings.TrimSpace(*id) == "" {
+		fmt.Fprintln(os.Stderr, "host and id are required")
+		return exitUsage
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		return exitError
+	}
+	route := findRoute(cfg, *host, *pathPrefix)
+	if route == nil {
+		fmt.Fprintf(os.Stderr, "route not found: %s%s\n", *host, *pathPrefix)
+		return exitError
+	}
+	next := make([]config.AccessConfig, 0, len(route.Access))
+	for _, existing := range route.Access {
+		if existing.ID != strings.TrimSpace(*id) {
+			next = append(next, existing)
+		}
+	}
+	if len(next) == len(route.Access) {
+		fmt.Println("access not found")
+		return exitOK
+	}
+	route.Access = next
+	if err := config.Save(*configPath, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
+		return exitError
+	}
+	fmt.Printf("access %s removed from %s%s\n", strings.TrimSpace(*id), route.Host, route.PathPrefix)
+	if len(next) == 0 {
+		fmt.Println("warning: route is now open to anyone who can reach it")
+	}
+	return pushRouteAccess(cfg, route)
+}
+
+func runAgentAccessList(args []string) int {
+	fs := flag.NewFlagSet("agent access list", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	host := fs.String("host", "", "only show this route host")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		return exitError
+	}
+	now := time.Now()
+	for _, rc := range cfg.Agent.HTTPProxy.Routes {
+		if *host != "" && !strings.EqualFold(rc.Host, *host) {
+			continue
+		}
+		fmt.Printf("%s%s -> %s\n", rc.Host, rc.PathPrefix, rc.Target)
+		if len(rc.Access) == 0 {
+			fmt.Println("  (open)")
+			continue
+		}
+		for _, ac := range rc.Access {
+			line := fmt.Sprintf("  %s type=%s", ac.ID, ac.Type)
+			if ac.Username != "" {
+				line += " user=" + ac.Username
+			}
+			if !ac.ExpiresAt.IsZero() {
+				line += " expires=" + ac.ExpiresAt.Format(time.RFC3339)
+				if now.After(ac.ExpiresAt) {
+					line += " (expired)"
+				}
+			}
+			fmt.Println(line)
+		}
+	}
+	return exitOK
+}
+
+func findRoute(cfg *config.Config, host, pathPrefix string) *config.RouteConfig {
+	host = strings.TrimSpace(host)
+	pathPrefix = strings.TrimSpace(pathPrefix)
+	for i := range cfg.Agent.HTTPProxy.Routes {
+		rc := &cfg.Agent.HTTPProxy.Routes[i]
+		if strings.EqualFold(rc.Host, host) && rc.PathPrefix == pathPrefix {
+			return rc
+		}
+	}
+	return nil
+}
+
+func pushRouteAccess(cfg *config.Config, route *config.RouteConfig) int {
+	data, err := json.Marshal(route.Access)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "encode access failed: %v\n", err)
+		return exitError
+	}
+	return applyRouteUpdate(func() (*ipcclient.Response, error) {
+		return ipcclient.SetRouteAccess(cfg, route.Host, route.PathPrefix, string(data), cfg.Agent.HTTPProxy.LinkSecret)
+	})
+}
+
+func routeLink(cfg *config.Config, route *config.RouteConfig, base, token string) string {
+	if base == "" {
+		host := route.Host
+		if strings.HasPrefix(host, "*.") {
+			host = "www" + host[1:]
+		}
+		bind := strings.Split(cfg.Agent.HTTPProxy.RemoteBind, ":")
+		if port := bind[len(bind)-1]; port != "80" {
+			host = net.JoinHostPort(host, port)
+		}
+		scheme := "http://"
+		if cfg.Agent.HTTPProxy.TLS.Enabled {
+			scheme = "https://"
+		}
+		base = scheme + host + route.PathPrefix
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return base + "?" + httpproxy.LinkParam + "=" + token
+	}
+	q := u.Query()
+	q.Set(httpproxy.LinkParam, token)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func readPassword(fromStdin bool) (string, error) {
+	if !fromStdin {
+		fmt.Fprint(os.Stderr, "Password (input is echoed; use --password-stdin to pipe): ")
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("password is empty")
+	}
+	return password, nil
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,98 @@
+// Package cli implements `rpa audit`, which queries the agent's inbound connection log.
+// Records are written by agent relays; this command only reads the file.
+
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"reverse-proxy-agent/pkg/audit"
+	"reverse-proxy-agent/pkg/config"
+)
+
+func runAudit(args []string) int {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	forward := fs.String("forward", "", "only show this remote forward spec")
+	since := fs.String("since", "", "start time (RFC3339, YYYY-MM-DD, or a duration like 24h)")
+	until := fs.String("until", "", "end time (RFC3339, YYYY-MM-DD, or a duration like 1h)")
+	asJSON := fs.Bool("json", false, "print raw JSON lines")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	filter := audit.Filter{Forward: strings.TrimSpace(*forward)}
+	var err error
+	if filter.Since, err = parseAuditTime(*since); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --since: %v\n", err)
+		return exitUsage
+	}
+	if filter.Until, err = parseAuditTime(*until); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --until: %v\n", err)
+		return exitUsage
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		return exitError
+	}
+	path, err := config.AuditPath(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "audit path resolve failed: %v\n", err)
+		return exitError
+	}
+	records, err := audit.Query(path, filter)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "no audit log at %s (configure agent.relays to record connections)\n", path)
+			return exitError
+		}
+		fmt.Fprintf(os.Stderr, "audit read failed: %v\n", err)
+		return exitError
+	}
+
+	for _, rec := range records {
+		if *asJSON {
+			data, _ := json.Marshal(rec)
+			fmt.Println(string(data))
+			continue
+		}
+		if rec.Event == audit.EventOpen {
+			fmt.Printf("%s forward=%s source=%s open\n", rec.Time.UTC().Format(time.RFC3339), rec.Forward, rec.Source)
+			continue
+		}
+		line := fmt.Sprintf("%s forward=%s source=%s duration=%s in=%d out=%d",
+			rec.Time.UTC().Format(time.RFC3339), rec.Forward, rec.Source,
+			(time.Duration(rec.DurationMs) * time.Millisecond).String(), rec.BytesIn, rec.BytesOut)
+		if rec.Error != "" {
+			line += " error=" + rec.Error
+		}
+		fmt.Println(line)
+	}
+	return exitOK
+}
+
+// parseAuditTime accepts an absolute time or a duration meaning "that long ago".
+func parseAuditTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q", value)
+}
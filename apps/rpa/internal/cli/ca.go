@@ -0,0 +1,178 @@
+// Package cli implements `rpa ca`, which manages the local CA used for HTTP proxy TLS.
+// Users trust the exported CA certificate once; route certificates are issued from it.
+
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"reverse-proxy-agent/pkg/ca"
+	"reverse-proxy-agent/pkg/config"
+)
+
+func runCA(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "missing ca subcommand (init|issue|export)")
+		printCAUsage()
+		return exitUsage
+	}
+	switch args[0] {
+	case "help", "-h", "--help":
+		printCAUsage()
+		return exitOK
+	case "init":
+		return runCAInit(args[1:])
+	case "issue":
+		return runCAIssue(args[1:])
+	case "export":
+		return runCAExport(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown ca subcommand: %s\n", args[0])
+		printCAUsage()
+		return exitUsage
+	}
+}
+
+func runCAInit(args []string) int {
+	fs := flag.NewFlagSet("ca init", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	force := fs.Bool("force", false, "replace an existing CA (issued certificates must be reissued and re-trusted)")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	dir, code := caDir(*configPath)
+	if code != exitOK {
+		return code
+	}
+	authority, err := ca.Init(dir, *force)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ca init failed: %v\n", err)
+		return exitError
+	}
+	fmt.Printf("ca created: %s\n", ca.CertPath(dir))
+	fmt.Printf("subject: %s\n", authority.Certificate().Subject.CommonName)
+	fmt.Printf("expires: %s\n", authority.Certificate().NotAfter.UTC().Format(time.RFC3339))
+	fmt.Println("share it with `rpa ca export` so users can trust it")
+	return exitOK
+}
+
+func runCAIssue(args []string) int {
+	host := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		host = args[0]
+		args = args[1:]
+	}
+	fs := flag.NewFlagSet("ca issue", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	days := fs.Int("days", 0, "certificate lifetime in days (default agent.http_proxy.tls.cert_days or 90)")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if host == "" && fs.NArg() > 0 {
+		host = fs.Arg(0)
+	}
+	host = strings.TrimSpace(host)
+	if host == "" {
+		fmt.Fprintln(os.Stderr, "usage: rpa ca issue <host> [--days N]")
+		return exitUsage
+	}
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		return exitError
+	}
+	dir, err := config.CADir(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ca dir: %v\n", err)
+		return exitError
+	}
+	if *days <= 0 {
+		*days = cfg.Agent.HTTPProxy.TLS.CertDays
+	}
+	if *days <= 0 {
+		*days = 90
+	}
+	authority, err := ca.Load(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitError
+	}
+	cert, err := authority.Issue(host, time.Duration(*days)*24*time.Hour)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ca issue failed: %v\n", err)
+		return exitError
+	}
+	certPath, keyPath, err := ca.LeafPaths(dir, host)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitError
+	}
+	fmt.Printf("issued: %s\n", host)
+	fmt.Printf("cert: %s\n", certPath)
+	fmt.Printf("key: %s\n", keyPath)
+	fmt.Printf("expires: %s\n", cert.NotAfter.UTC().Format(time.RFC3339))
+	return exitOK
+}
+
+func runCAExport(args []string) int {
+	fs := flag.NewFlagSet("ca export", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	out := fs.String("out", "", "write the CA certificate to this file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	dir, code := caDir(*configPath)
+	if code != exitOK {
+		return code
+	}
+	data, err := os.ReadFile(ca.CertPath(dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "%v\n", ca.ErrNotInitialized)
+		} else {
+			fmt.Fprintf(os.Stderr, "read ca cert failed: %v\n", err)
+		}
+		return exitError
+	}
+	if *out == "" {
+		fmt.Print(string(data))
+		return exitOK
+	}
+	if err := os.WriteFile(*out, data, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "write failed: %v\n", err)
+		return exitError
+	}
+	fmt.Printf("ca certificate written: %s\n", *out)
+	return exitOK
+}
+
+func caDir(configPath string) (string, int) {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		return "", exitError
+	}
+	dir, err := config.CADir(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ca dir: %v\n", err)
+		return "", exitError
+	}
+	return dir, exitOK
+}
+
+func printCAUsage() {
+	fmt.Println("rpa ca")
+	fmt.Println("")
+	fmt.Println("Usage:")
+	fmt.Println("  rpa ca init [--force] [--config rpa.yaml]")
+	fmt.Println("  rpa ca issue <host> [--days 90] [--config rpa.yaml]")
+	fmt.Println("  rpa ca export [--out rpa-ca.crt] [--config rpa.yaml]")
+	fmt.Println("")
+	fmt.Println("Notes:")
+	fmt.Println("  the CA lives in agent.http_proxy.tls.ca_dir (default ~/.rpa/ca)")
+	fmt.Println("  with agent.http_proxy.tls.enabled the agent issues and renews route certificates itself")
+}
@@ -15,6 +15,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -27,6 +28,7 @@ import (
 	"reverse-proxy-agent/internal/client"
 	clientipcserver "reverse-proxy-agent/internal/client/ipc"
 	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/forward"
 	ipcclient "reverse-proxy-agent/pkg/ipc/agent"
 	ipcclientlocal "reverse-proxy-agent/pkg/ipc/client"
 	"reverse-proxy-agent/pkg/launchd"
@@ -76,6 +78,24 @@ func Run(args []string) int {
 		return runDoctor(args[1:])
 	case "config":
 		return runConfig(args[1:])
+	case "audit":
+		return runAudit(args[1:])
+	case "ca":
+		return runCA(args[1:])
+	case "share":
+		return runShare(args[1:])
+	case "hostkey":
+		return runHostKey(args[1:])
+	case "keys":
+		return runKeys(args[1:])
+	case "server-snippet":
+		return runServerSnippet(args[1:])
+	case "invite":
+		return runInvite(args[1:])
+	case "import":
+		return runImport(args[1:])
+	case "grant":
+		return runGrant(args[1:])
 	default:
 		fmt.Fprintf(os.Stderr, "unknown command: %s\n", args[0])
 		printUsage()
@@ -85,7 +105,7 @@ func Run(args []string) int {
 
 func runAgent(args []string) int {
 	if len(args) == 0 {
-		fmt.Fprintln(os.Stderr, "missing agent subcommand (up|down|run|add|remove|clear)")
+		fmt.Fprintln(os.Stderr, "missing agent subcommand (up|down|run|add|remove|clear|route|access)")
 		printAgentUsage()
 		return exitUsage
 	}
@@ -105,6 +125,18 @@ func runAgent(args []string) int {
 		return runAgentRemove(args[1:])
 	case "clear":
 		return runAgentClear(args[1:])
+	case "enable":
+		return runAgentEnable(args[1:])
+	case "disable":
+		return runAgentDisable(args[1:])
+	case "export":
+		return runForwardsExport("agent", args[1:])
+	case "import":
+		return runForwardsImport("agent", args[1:])
+	case "route":
+		return runAgentRoute(args[1:])
+	case "access":
+		return runAgentAccess(args[1:])
 	default:
 		fmt.Fprintf(os.Stderr, "unknown agent subcommand: %s\n", args[0])
 		return exitUsage
@@ -113,7 +145,7 @@ func runAgent(args []string) int {
 
 func runClient(args []string) int {
 	if len(args) == 0 {
-		fmt.Fprintln(os.Stderr, "missing client subcommand (up|down|run|add|remove|clear)")
+		fmt.Fprintln(os.Stderr, "missing client subcommand (up|down|run|add|remove|clear|socks-user)")
 		printClientUsage()
 		return exitUsage
 	}
@@ -133,6 +165,12 @@ func runClient(args []string) int {
 		return runClientRemove(args[1:])
 	case "clear":
 		return runClientClear(args[1:])
+	case "export":
+		return runForwardsExport("client", args[1:])
+	case "import":
+		return runForwardsImport("client", args[1:])
+	case "socks-user":
+		return runClientSOCKSUser(args[1:])
 	default:
 		fmt.Fprintf(os.Stderr, "unknown client subcommand: %s\n", args[0])
 		return exitUsage
@@ -158,6 +196,7 @@ func runInit(args []string) int {
 	agentPreventSleep := fs.Bool("agent-prevent-sleep", false, "prevent system sleep while agent is running")
 	clientPreventSleep := fs.Bool("client-prevent-sleep", false, "prevent system sleep while client is running")
 	force := fs.Bool("force", false, "overwrite config if it exists")
+	fromSSHConfig := fs.String("from-ssh-config", "", "take user, host, port, identity file and ProxyJump from this ~/.ssh/config alias")
 	var sshOptions []string
 	fs.Func("ssh-option", "additional ssh option (repeatable)", func(value string) error {
 		if strings.TrimSpace(value) == "" {
@@ -184,9 +223,10 @@ func runInit(args []string) int {
 		fmt.Fprintln(fs.Output(), "Usage:")
 		fmt.Fprintln(fs.Output(), "  rpa init --ssh-user user --ssh-host host --remote-forward spec [flags]")
 		fmt.Fprintln(fs.Output(), "  rpa init --ssh-user user --ssh-host host --local-forward spec [flags]")
+		fmt.Fprintln(fs.Output(), "  rpa init --from-ssh-config alias --remote-forward spec [flags]")
 		fmt.Fprintln(fs.Output(), "")
 		fmt.Fprintln(fs.Output(), "Required:")
-		fmt.Fprintln(fs.Output(), "  --ssh-user, --ssh-host")
+		fmt.Fprintln(fs.Output(), "  --ssh-user, --ssh-host (or --from-ssh-config)")
 		fmt.Fprintln(fs.Output(), "  --remote-forward or --local-forward")
 		fmt.Fprintln(fs.Output(), "")
 		fmt.Fprintln(fs.Output(), "Spec examples:")
@@ -200,7 +240,18 @@ func runInit(args []string) int {
 		return exitUsage
 	}
 
-	if strings.TrimSpace(*sshUser) == "" || strings.TrimSpace(*sshHost) == "" {
+	alias := strings.TrimSpace(*fromSSHConfig)
+	if alias != "" {
+		// Only flags given explicitly override the alias.
+		set := make(map[string]bool)
+		fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		if !set["ssh-port"] {
+			*sshPort = 0
+		}
+		if !set["ssh-identity-file"] {
+			*sshIdentityFile = ""
+		}
+	} else if strings.TrimSpace(*sshUser) == "" || strings.TrimSpace(*sshHost) == "" {
 		fmt.Fprintln(os.Stderr, "missing required flags: --ssh-user, --ssh-host")
 		fs.Usage()
 		return exitUsage
@@ -235,6 +286,7 @@ func runInit(args []string) int {
 			Port:         *sshPort,
 			IdentityFile: *sshIdentityFile,
 			Options:      sshOptions,
+			ConfigAlias:  alias,
 		},
 		Logging: config.LoggingConfig{
 			Level: *logLevel,
@@ -243,10 +295,14 @@ func runInit(args []string) int {
 	}
 	cfg.Client.PreventSleep = *clientPreventSleep
 	if len(remoteForwards) > 0 {
-		cfg.SSH.RemoteForwards = append([]string(nil), remoteForwards...)
+		config.SetRemoteForwards(cfg, remoteForwards)
 	}
 	if len(localForwards) > 0 {
-		cfg.Client.LocalForwards = append([]string(nil), localForwards...)
+		config.SetLocalForwards(cfg, localForwards)
+	}
+	if err := config.ResolveSSHAlias(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitError
 	}
 	config.ApplyDefaults(cfg)
 	if len(remoteForwards) > 0 {
@@ -262,21 +318,22 @@ func runInit(args []string) int {
 		}
 	}
 
-	out, err := yaml.Marshal(cfg)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "config marshal failed: %v\n", err)
-		return exitError
-	}
-
-	if err := ensureDir(filepath.Dir(*configPath)); err != nil {
-		fmt.Fprintf(os.Stderr, "create config dir failed: %v\n", err)
-		return exitError
-	}
-	if err := os.WriteFile(*configPath, out, 0o600); err != nil {
+	// Save leaves out what came from ssh_config, so later edits there apply.
+	if err := config.Save(*configPath, cfg); err != nil {
 		fmt.Fprintf(os.Stderr, "write config failed: %v\n", err)
 		return exitError
 	}
 	fmt.Printf("config initialized: %s\n", *configPath)
+	if alias != "" {
+		fmt.Printf("ssh settings from alias %s: %s@%s:%d", alias, cfg.SSH.User, cfg.SSH.Host, cfg.SSH.Port)
+		if cfg.SSH.IdentityFile != "" {
+			fmt.Printf(" identity=%s", cfg.SSH.IdentityFile)
+		}
+		if len(cfg.SSH.JumpHosts) > 0 {
+			fmt.Printf(" via %s", config.JumpChain(cfg.SSH.JumpHosts))
+		}
+		fmt.Println()
+	}
 	return exitOK
 }
 
@@ -384,6 +441,9 @@ func runAgentAdd(args []string) int {
 	fs := flag.NewFlagSet("agent add", flag.ContinueOnError)
 	configPath := fs.String("config", defaultConfigPath(), "path to config file")
 	remoteForward := fs.String("remote-forward", "", "ssh remote forward spec (required)")
+	meta := addEntryFlags(fs)
+	ttl := fs.Duration("ttl", 0, "remove the forward after this long, e.g. 2h")
+	until := fs.String("until", "", "remove the forward at this time (RFC3339 or \"2006-01-02 15:04\" local)")
 	if err := fs.Parse(args); err != nil {
 		return exitUsage
 	}
@@ -391,6 +451,11 @@ func runAgentAdd(args []string) int {
 		fmt.Fprintln(os.Stderr, "remote-forward is required")
 		return exitUsage
 	}
+	expiresAt, err := parseExpiry(*ttl, *until, time.Now())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitUsage
+	}
 
 	cfg, err := config.Load(*configPath)
 	if err != nil {
@@ -398,16 +463,30 @@ func runAgentAdd(args []string) int {
 		return exitError
 	}
 
-	forwards := config.NormalizeRemoteForwards(cfg)
-	forwards = append(forwards, *remoteForward)
-	config.SetRemoteForwards(cfg, forwards)
+	entry := meta.entry(*remoteForward)
+	if expiresAt != nil {
+		entry.ExpiresAt = expiresAt
+		if entry.CreatedAt == nil {
+			now := time.Now().UTC().Truncate(time.Second)
+			entry.CreatedAt = &now
+		}
+	}
+	if err := checkForwardName(cfg.SSH.RemoteForwards, entry); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitUsage
+	}
+	cfg.SSH.RemoteForwards = config.UpsertForwardEntry(cfg.SSH.RemoteForwards, entry)
+	if err := config.ValidateAgent(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "config validation failed: %v\n", err)
+		return exitError
+	}
 	if err := config.Save(*configPath, cfg); err != nil {
 		fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
 		return exitError
 	}
 
 	if resp, ok, notRunning := tryRuntimeUpdate(func() (*ipcclient.Response, error) {
-		return ipcclient.AddRemoteForward(cfg, *remoteForward)
+		return ipcclient.AddRemoteForward(cfg, entry)
 	}); ok {
 		if resp.Message != "" {
 			fmt.Println(resp.Message)
@@ -441,9 +520,10 @@ func runAgentRemove(args []string) int {
 	}
 
 	forwards := config.NormalizeRemoteForwards(cfg)
+	removed := config.ForwardKeys(*remoteForward)
 	next := make([]string, 0, len(forwards))
 	for _, value := range forwards {
-		if strings.TrimSpace(value) == strings.TrimSpace(*remoteForward) {
+		if _, ok := removed[config.ForwardKey(value)]; ok {
 			continue
 		}
 		next = append(next, value)
@@ -647,12 +727,14 @@ func runClientRun(args []string) int {
 func runClientAdd(args []string) int {
 	fs := flag.NewFlagSet("client add", flag.ContinueOnError)
 	configPath := fs.String("config", defaultConfigPath(), "path to config file")
-	localForward := fs.String("local-forward", "", "ssh local forward spec (required)")
+	localForward := fs.String("local-forward", "", "ssh local forward spec")
+	dynamicForward := fs.String("dynamic-forward", "", "SOCKS5 forward as [bind:]port")
+	meta := addEntryFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return exitUsage
 	}
-	if strings.TrimSpace(*localForward) == "" {
-		fmt.Fprintln(os.Stderr, "local-forward is required")
+	if (strings.TrimSpace(*localForward) == "") == (strings.TrimSpace(*dynamicForward) == "") {
+		fmt.Fprintln(os.Stderr, "exactly one of local-forward or dynamic-forward is required")
 		return exitUsage
 	}
 
@@ -661,17 +743,23 @@ func runClientAdd(args []string) int {
 		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
 		return exitError
 	}
+	if strings.TrimSpace(*dynamicForward) != "" {
+		return addClientDynamicForward(cfg, *configPath, strings.TrimSpace(*dynamicForward))
+	}
 
-	forwards := config.NormalizeLocalForwards(cfg)
-	forwards = append(forwards, *localForward)
-	config.SetLocalForwards(cfg, forwards)
+	entry := meta.entry(*localForward)
+	if err := checkForwardName(cfg.Client.LocalForwards, entry); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitUsage
+	}
+	cfg.Client.LocalForwards = config.UpsertForwardEntry(cfg.Client.LocalForwards, entry)
 	if err := config.Save(*configPath, cfg); err != nil {
 		fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
 		return exitError
 	}
 
 	if resp, ok, notRunning := tryClientRuntimeUpdate(func() (*ipcclientlocal.Response, error) {
-		return ipcclientlocal.AddLocalForward(cfg, *localForward)
+		return ipcclientlocal.AddLocalForward(cfg, entry)
 	}); ok {
 		if resp.Message != "" {
 			fmt.Println(resp.Message)
@@ -689,12 +777,13 @@ func runClientAdd(args []string) int {
 func runClientRemove(args []string) int {
 	fs := flag.NewFlagSet("client remove", flag.ContinueOnError)
 	configPath := fs.String("config", defaultConfigPath(), "path to config file")
-	localForward := fs.String("local-forward", "", "ssh local forward spec (required)")
+	localForward := fs.String("local-forward", "", "ssh local forward spec")
+	dynamicForward := fs.String("dynamic-forward", "", "SOCKS5 forward as [bind:]port")
 	if err := fs.Parse(args); err != nil {
 		return exitUsage
 	}
-	if strings.TrimSpace(*localForward) == "" {
-		fmt.Fprintln(os.Stderr, "local-forward is required")
+	if (strings.TrimSpace(*localForward) == "") == (strings.TrimSpace(*dynamicForward) == "") {
+		fmt.Fprintln(os.Stderr, "exactly one of local-forward or dynamic-forward is required")
 		return exitUsage
 	}
 
@@ -703,16 +792,20 @@ func runClientRemove(args []string) int {
 		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
 		return exitError
 	}
+	if strings.TrimSpace(*dynamicForward) != "" {
+		return removeClientDynamicForward(cfg, *configPath, strings.TrimSpace(*dynamicForward))
+	}
 
 	forwards := config.NormalizeLocalForwards(cfg)
+	removed := config.ForwardKeys(*localForward)
 	next := make([]string, 0, len(forwards))
 	for _, value := range forwards {
-		if strings.TrimSpace(value) == strings.TrimSpace(*localForward) {
+		if _, ok := removed[config.ForwardKey(value)]; ok {
 			continue
 		}
 		next = append(next, value)
 	}
-	if len(next) == 0 {
+	if len(next) == 0 && len(config.NormalizeDynamicForwards(cfg)) == 0 {
 		fmt.Fprintln(os.Stderr, "at least one local forward is required")
 		return exitError
 	}
@@ -734,6 +827,68 @@ func runClientRemove(args []string) int {
 	return exitOK
 }
 
+func addClientDynamicForward(cfg *config.Config, configPath, value string) int {
+	expanded, err := forward.Expand(value)
+	if err == nil && !expanded[0].Dynamic {
+		err = fmt.Errorf("invalid dynamic forward %q: takes no destination", value)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitUsage
+	}
+	config.SetDynamicForwards(cfg, append(config.NormalizeDynamicForwards(cfg), value))
+	if err := config.Save(configPath, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
+		return exitError
+	}
+
+	if resp, ok, notRunning := tryClientRuntimeUpdate(func() (*ipcclientlocal.Response, error) {
+		return ipcclientlocal.AddDynamicForward(cfg, value)
+	}); ok {
+		if resp.Message != "" {
+			fmt.Println(resp.Message)
+		}
+	} else if notRunning {
+		if runClientUp([]string{"--config", configPath}) != exitOK {
+			return exitError
+		}
+	} else {
+		return exitError
+	}
+	return exitOK
+}
+
+func removeClientDynamicForward(cfg *config.Config, configPath, forward string) int {
+	current := config.NormalizeDynamicForwards(cfg)
+	removed := config.ForwardKeys(forward)
+	next := make([]string, 0, len(current))
+	for _, value := range current {
+		if _, ok := removed[config.ForwardKey(value)]; !ok {
+			next = append(next, value)
+		}
+	}
+	if len(next) == 0 && len(config.NormalizeLocalForwards(cfg)) == 0 {
+		fmt.Fprintln(os.Stderr, "at least one local or dynamic forward is required")
+		return exitError
+	}
+	config.SetDynamicForwards(cfg, next)
+	if err := config.Save(configPath, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
+		return exitError
+	}
+
+	if resp, ok, notRunning := tryClientRuntimeUpdate(func() (*ipcclientlocal.Response, error) {
+		return ipcclientlocal.RemoveDynamicForward(cfg, forward)
+	}); ok {
+		if resp.Message != "" {
+			fmt.Println(resp.Message)
+		}
+	} else if !notRunning {
+		return exitError
+	}
+	return exitOK
+}
+
 func runClientClear(args []string) int {
 	fs := flag.NewFlagSet("client clear", flag.ContinueOnError)
 	configPath := fs.String("config", defaultConfigPath(), "path to config file")
@@ -813,34 +968,43 @@ func runClientDoctor(args []string) int {
 			ok = false
 		} else {
 			fmt.Println("check identity file: OK")
+			checkIdentityUse(cfg)
 		}
 	}
 
-	if _, err := net.LookupHost(cfg.SSH.Host); err != nil {
-		fmt.Fprintf(os.Stderr, "check host resolve: FAIL (%v)\n", err)
+	if !printSSHAlias(cfg) {
+		ok = false
+	}
+	if !checkSSHRoute(cfg) {
+		ok = false
+	}
+	if !checkHostKeys(cfg) {
 		ok = false
-	} else {
-		fmt.Println("check host resolve: OK")
 	}
 
-	forward := firstLocalForward(cfg)
-	if forward != "" {
-		host, port, err := parseLocalForward(forward)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "check local forward: FAIL (%v)\n", err)
+	dynamics, parsed := parseDoctorForwards(config.NormalizeDynamicForwards(cfg), "dynamic")
+	ok = ok && parsed
+	for _, f := range dynamics {
+		if !checkPortAvailable("dynamic", f) {
 			ok = false
-		} else {
-			ln, err := net.Listen("tcp", net.JoinHostPort(host, port))
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "check local port availability: FAIL (%v)\n", err)
-				ok = false
-			} else {
-				_ = ln.Close()
-				fmt.Println("check local port availability: OK")
-			}
+		}
+		if !f.IsLoopback() && !config.DynamicForwardNeedsAuth(cfg, f.Spec) {
+			fmt.Fprintf(os.Stderr, "check dynamic forward auth (%s): WARN (open SOCKS proxy on a non-loopback bind; set client.socks_users)\n", f.Spec)
 		}
 	}
 
+	locals, parsed := parseDoctorForwards(config.NormalizeLocalForwards(cfg), "local")
+	ok = ok && parsed
+	if !checkForwardSockets(locals, false) {
+		ok = false
+	}
+	for _, f := range locals {
+		if !f.Bind.IsSocket() && !checkPortAvailable("local", f) {
+			ok = false
+		}
+	}
+	checkSnippetDrift(cfg, "client")
+
 	if !ok {
 		return exitError
 	}
@@ -884,9 +1048,7 @@ func runClientMetrics(args []string) int {
 		fmt.Fprintf(os.Stderr, "client metrics error: %s\n", resp.Message)
 		return exitError
 	}
-	for k, v := range resp.Data {
-		fmt.Printf("%s %s\n", k, v)
-	}
+	printMetrics(resp.Data)
 	return exitOK
 }
 
@@ -992,16 +1154,17 @@ func runAgentRun(args []string) int {
 		return exitError
 	}
 
-	return runForegroundAgent(cfg, "agent run")
+	return runForegroundAgent(cfg, *configPath, "agent run")
 }
 
-func runForegroundAgent(cfg *config.Config, label string) int {
+func runForegroundAgent(cfg *config.Config, configPath, label string) int {
 	if err := config.ValidateAgent(cfg); err != nil {
 		fmt.Fprintf(os.Stderr, "config validation failed: %v\n", err)
 		return exitError
 	}
 
 	agt := agent.New(cfg)
+	agt.SetConfigPath(configPath)
 	logs := logging.NewLogBuffer()
 	logger, err := logging.NewLogger(cfg, logs)
 	if err != nil {
@@ -1156,21 +1319,45 @@ func printStatusBlock(label string, cfg *config.Config, query func() statusPaylo
 	if label == "agent" {
 		remoteForwards := strings.TrimSpace(resp.data["remote_forwards"])
 		if remoteForwards == "" {
-			remoteForwards = strings.Join(config.NormalizeRemoteForwards(cfg), ",")
+			remoteForwards = forwardLabels(cfg.SSH.RemoteForwards)
 		}
 		if remoteForwards == "" {
 			remoteForwards = "(none)"
 		}
 		fmt.Printf("  remote_forwards: %s\n", remoteForwards)
+		if v, ok := resp.data["disabled_forwards"]; ok && v != "" {
+			fmt.Printf("  disabled_forwards: %s\n", v)
+		}
+		if v, ok := resp.data["port_pool"]; ok && v != "" {
+			fmt.Printf("  port_pool: %s\n", v)
+		}
+		if v, ok := resp.data["relays"]; ok && v != "" {
+			fmt.Printf("  relays: %s\n", v)
+		}
+		if v, ok := resp.data["http_proxy"]; ok && v != "" {
+			fmt.Printf("  http_proxy: %s\n", v)
+		}
 	} else {
 		localForwards := strings.TrimSpace(resp.data["local_forwards"])
 		if localForwards == "" {
-			localForwards = strings.Join(config.NormalizeLocalForwards(cfg), ",")
+			localForwards = forwardLabels(cfg.Client.LocalForwards)
 		}
 		if localForwards == "" {
 			localForwards = "(none)"
 		}
 		fmt.Printf("  local_forwards: %s\n", localForwards)
+		if v, ok := resp.data["disabled_forwards"]; ok && v != "" {
+			fmt.Printf("  disabled_forwards: %s\n", v)
+		}
+		if v, ok := resp.data["managed_listeners"]; ok && v != "" {
+			fmt.Printf("  managed_listeners: %s\n", v)
+		}
+		if v, ok := resp.data["dynamic_forwards"]; ok && v != "" {
+			fmt.Printf("  dynamic_forwards: %s\n", v)
+		}
+		if v, ok := resp.data["socks_auth"]; ok && v != "" {
+			fmt.Printf("  socks_auth: %s\n", v)
+		}
 	}
 	fmt.Printf("  uptime: %s\n", resp.data["uptime"])
 	fmt.Printf("  restarts: %s\n", resp.data["restarts"])
@@ -1198,9 +1385,50 @@ func printStatusBlock(label string, cfg *config.Config, query func() statusPaylo
 	if v, ok := resp.data["backoff_ms"]; ok && v != "" {
 		fmt.Printf("  backoff_ms: %s\n", v)
 	}
+	if v, ok := resp.data["periodic_next_unix"]; ok && v != "" {
+		fmt.Printf("  periodic_next_utc: %s\n", formatUnixUTC(v))
+	}
+	if v, ok := resp.data["periodic_deferred"]; ok && v != "" {
+		fmt.Printf("  periodic_deferred: %s\n", v)
+	}
+	printPrefixedBlock(resp.data, "allocated.", "allocated_ports")
+	printPrefixedBlock(resp.data, "expires.", "expiring_forwards")
+	printPrefixedBlock(resp.data, "probe.", "probes")
+	printPrefixedBlock(resp.data, "forward.", "forward_traffic")
+	printPrefixedBlock(resp.data, "socks.", "reverse_socks")
+	printPrefixedBlock(resp.data, "route.", "routes")
+	printPrefixedBlock(resp.data, "cert.", "certificates")
 	return true
 }
 
+func printMetrics(data map[string]string) {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Printf("%s %s\n", k, data[k])
+	}
+}
+
+func printPrefixedBlock(data map[string]string, prefix, title string) {
+	keys := make([]string, 0)
+	for k := range data {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	sort.Strings(keys)
+	fmt.Printf("  %s:\n", title)
+	for _, k := range keys {
+		fmt.Printf("    %s: %s\n", strings.TrimPrefix(k, prefix), data[k])
+	}
+}
+
 func printStatusFallback(label string, cfg *config.Config) bool {
 	var path string
 	var err error
@@ -1233,6 +1461,17 @@ func printStatusFallback(label string, cfg *config.Config) bool {
 		fmt.Printf("  last_success_utc: %s\n", formatUnixUTC(strconv.FormatInt(snap.LastSuccessUnix, 10)))
 		fmt.Printf("  last_success_unix: %d\n", snap.LastSuccessUnix)
 	}
+	if len(snap.AllocatedPorts) > 0 {
+		fmt.Println("  allocated_ports (last session):")
+		specs := make([]string, 0, len(snap.AllocatedPorts))
+		for spec := range snap.AllocatedPorts {
+			specs = append(specs, spec)
+		}
+		sort.Strings(specs)
+		for _, spec := range specs {
+			fmt.Printf("    %s: %d\n", config.ForwardLabel(cfg.SSH.RemoteForwards, spec), snap.AllocatedPorts[spec])
+		}
+	}
 	if snap.UpdatedUnix > 0 {
 		fmt.Printf("  updated_unix: %d\n", snap.UpdatedUnix)
 	}
@@ -1317,9 +1556,7 @@ func runMetrics(args []string) int {
 			fmt.Fprintf(os.Stderr, "metrics error: %s\n", resp.Message)
 			return exitError
 		}
-		for k, v := range resp.Data {
-			fmt.Printf("%s %s\n", k, v)
-		}
+		printMetrics(resp.Data)
 		return exitOK
 	case "client":
 		resp, err := ipcclientlocal.Query(cfg, "metrics")
@@ -1331,9 +1568,7 @@ func runMetrics(args []string) int {
 			fmt.Fprintf(os.Stderr, "client metrics error: %s\n", resp.Message)
 			return exitError
 		}
-		for k, v := range resp.Data {
-			fmt.Printf("%s %s\n", k, v)
-		}
+		printMetrics(resp.Data)
 		return exitOK
 	default:
 		fmt.Fprintf(os.Stderr, "unknown metrics target: %s\n", target)
@@ -1456,10 +1691,17 @@ func runConfigSet(args []string) int {
 }
 
 func getConfigValue(cfg *config.Config, key string) (string, error) {
-	field, err := lookupConfigField(cfg, key)
+	field, err := lookupConfigField(cfg, key, false)
 	if err != nil {
 		return "", err
 	}
+	if field.Kind() == reflect.Pointer {
+		// Optional settings are unset until given a value.
+		if field.IsNil() {
+			return "", nil
+		}
+		field = field.Elem()
+	}
 	switch field.Kind() {
 	case reflect.String:
 		return field.String(), nil
@@ -1485,13 +1727,17 @@ func getConfigValue(cfg *config.Config, key string) (string, error) {
 }
 
 func setConfigValue(cfg *config.Config, key, value string) error {
-	field, err := lookupConfigField(cfg, key)
+	field, err := lookupConfigField(cfg, key, true)
 	if err != nil {
 		return err
 	}
 	if !field.CanSet() {
 		return fmt.Errorf("field %s is not settable", key)
 	}
+	if field.Kind() == reflect.Pointer {
+		field.Set(reflect.New(field.Type().Elem()))
+		field = field.Elem()
+	}
 	switch field.Kind() {
 	case reflect.String:
 		field.SetString(value)
@@ -1533,12 +1779,22 @@ func setConfigValue(cfg *config.Config, key, value string) error {
 	}
 }
 
-func lookupConfigField(cfg *config.Config, key string) (reflect.Value, error) {
+// lookupConfigField resolves a dotted key. An unset optional section reads as
+// its zero value, or is allocated when create is set.
+func lookupConfigField(cfg *config.Config, key string, create bool) (reflect.Value, error) {
 	parts := strings.Split(key, ".")
 	current := reflect.ValueOf(cfg)
 	for _, part := range parts {
 		if current.Kind() == reflect.Pointer {
-			current = current.Elem()
+			switch {
+			case !current.IsNil():
+				current = current.Elem()
+			case create:
+				current.Set(reflect.New(current.Type().Elem()))
+				current = current.Elem()
+			default:
+				current = reflect.Zero(current.Type().Elem())
+			}
 		}
 		if current.Kind() != reflect.Struct {
 			return reflect.Value{}, fmt.Errorf("invalid key %s", key)
@@ -1622,36 +1878,37 @@ func runAgentDoctor(args []string) int {
 			ok = false
 		} else {
 			fmt.Println("check identity file: OK")
+			checkIdentityUse(cfg)
 		}
 	}
 
-	if _, err := net.LookupHost(cfg.SSH.Host); err != nil {
-		fmt.Fprintf(os.Stderr, "check host resolve: FAIL (%v)\n", err)
+	if !printSSHAlias(cfg) {
+		ok = false
+	}
+	if !checkSSHRoute(cfg) {
+		ok = false
+	}
+	if !checkHostKeys(cfg) {
 		ok = false
-	} else {
-		fmt.Println("check host resolve: OK")
 	}
 
-	forward := firstRemoteForward(cfg)
-	if forward != "" {
-		bindHost, bindPort, err := parseRemoteForward(forward)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "check remote forward: FAIL (%v)\n", err)
-			ok = false
-		} else {
-			if !isLoopbackHost(bindHost) && !isWildcardHost(bindHost) {
-				fmt.Fprintf(os.Stderr, "check remote bind host: WARN (non-local bind %s)\n", bindHost)
-			} else {
-				fmt.Println("check remote bind host: OK")
-			}
-			if _, err := strconv.Atoi(bindPort); err != nil {
-				fmt.Fprintf(os.Stderr, "check remote bind port: FAIL (%v)\n", err)
-				ok = false
-			} else {
-				fmt.Println("check remote bind port: OK")
-			}
+	remotes, parsed := parseDoctorForwards(config.NormalizeRemoteForwards(cfg), "remote")
+	ok = ok && parsed
+	for _, f := range remotes {
+		switch {
+		case f.Dynamic:
+			checkReverseSOCKS(cfg, f)
+		case f.Bind.IsSocket():
+		case f.IsLoopback() || f.IsWildcard():
+			fmt.Printf("check remote bind host (%s): OK\n", f.Spec)
+		default:
+			fmt.Fprintf(os.Stderr, "check remote bind host (%s): WARN (non-local bind %s)\n", f.Spec, f.Bind.Host)
 		}
 	}
+	if !checkForwardSockets(remotes, true) {
+		ok = false
+	}
+	checkSnippetDrift(cfg, "agent")
 
 	if !ok {
 		return exitError
@@ -1919,59 +2176,95 @@ func expandTilde(path string) string {
 	return filepath.Join(home, path[2:])
 }
 
-func firstLocalForward(cfg *config.Config) string {
-	forwards := config.NormalizeLocalForwards(cfg)
-	if len(forwards) == 0 {
-		return ""
+// parseDoctorForwards reports each spec that does not parse as a FAIL line.
+func parseDoctorForwards(values []string, kind string) ([]forward.Forward, bool) {
+	out := make([]forward.Forward, 0, len(values))
+	ok := true
+	for _, value := range values {
+		f, err := forward.Parse(value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "check %s forward: FAIL (%v)\n", kind, err)
+			ok = false
+			continue
+		}
+		out = append(out, f)
 	}
-	return forwards[0]
+	return out, ok
 }
 
-func firstRemoteForward(cfg *config.Config) string {
-	forwards := config.NormalizeRemoteForwards(cfg)
-	if len(forwards) == 0 {
-		return ""
+func checkPortAvailable(kind string, f forward.Forward) bool {
+	host := f.Bind.Host
+	if host == "*" {
+		host = ""
 	}
-	return forwards[0]
-}
-
-func parseLocalForward(spec string) (string, string, error) {
-	parts := strings.Split(spec, ":")
-	switch len(parts) {
-	case 3:
-		return "127.0.0.1", parts[0], nil
-	case 4:
-		return parts[0], parts[1], nil
-	default:
-		return "", "", fmt.Errorf("invalid local forward: %s", spec)
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(f.Bind.Port)))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "check %s port availability (%s): FAIL (%v)\n", kind, f.Spec, err)
+		return false
 	}
+	_ = ln.Close()
+	fmt.Printf("check %s port availability (%s): OK\n", kind, f.Spec)
+	return true
 }
 
-func parseRemoteForward(spec string) (string, string, error) {
-	parts := strings.Split(spec, ":")
-	switch len(parts) {
-	case 3:
-		return "127.0.0.1", parts[0], nil
-	case 4:
-		return parts[0], parts[1], nil
-	default:
-		return "", "", fmt.Errorf("invalid remote forward: %s", spec)
+// checkForwardSockets verifies the Unix socket sides of forwards that live on
+// this machine: targets must already be listening sockets, binds need an
+// existing directory. Remote sides are only described.
+func checkForwardSockets(forwards []forward.Forward, remote bool) bool {
+	ok := true
+	for _, f := range forwards {
+		localTarget, localBind := f.Target.Socket, f.Bind.Socket
+		if remote {
+			localBind = ""
+		} else {
+			localTarget = ""
+		}
+		if localTarget != "" {
+			info, err := os.Stat(localTarget)
+			switch {
+			case err != nil:
+				fmt.Fprintf(os.Stderr, "check local socket (%s): FAIL (%v)\n", localTarget, err)
+				ok = false
+			case info.Mode()&os.ModeSocket == 0:
+				fmt.Fprintf(os.Stderr, "check local socket (%s): FAIL (not a socket)\n", localTarget)
+				ok = false
+			default:
+				fmt.Printf("check local socket (%s): OK\n", localTarget)
+			}
+		}
+		if localBind != "" {
+			if _, err := os.Stat(filepath.Dir(localBind)); err != nil {
+				fmt.Fprintf(os.Stderr, "check local socket dir (%s): FAIL (%v)\n", localBind, err)
+				ok = false
+			} else {
+				fmt.Printf("check local socket dir (%s): OK\n", localBind)
+			}
+		}
+		if remote && f.Bind.IsSocket() {
+			fmt.Printf("check remote socket bind (%s): OK (stale sockets are replaced only when sshd sets StreamLocalBindUnlink yes; its StreamLocalBindMask sets permissions)\n", f.Bind.Socket)
+		}
 	}
+	return ok
 }
 
-func isLoopbackHost(host string) bool {
-	switch strings.ToLower(host) {
-	case "127.0.0.1", "localhost", "::1":
-		return true
+// checkReverseSOCKS warns about what a reverse dynamic forward exposes: any
+// server-side user who can reach the bind can open connections from here.
+func checkReverseSOCKS(cfg *config.Config, f forward.Forward) {
+	label := fmt.Sprintf("check reverse socks (%s)", f.Spec)
+	public := !f.IsLoopback()
+	rules := len(cfg.Agent.SOCKSAllow)
+	switch {
+	case rules == 0 && public:
+		fmt.Fprintf(os.Stderr, "%s: WARN (anyone reaching %s on the server can connect to any host this machine reaches; set agent.socks_allow and bind to loopback)\n", label, f.Bind.Host)
+	case rules == 0:
+		fmt.Fprintf(os.Stderr, "%s: WARN (every server user can connect to any host this machine reaches; set agent.socks_allow)\n", label)
+	case public:
+		fmt.Fprintf(os.Stderr, "%s: WARN (non-local bind %s exposes %d allowed destination(s) beyond the server)\n", label, f.Bind.Host, rules)
 	default:
-		return false
+		fmt.Printf("%s: OK (limited to %d allowed destination(s))\n", label, rules)
 	}
 }
 
-func isWildcardHost(host string) bool {
-	return host == "0.0.0.0" || host == "::"
-}
-
 func printClientAdvice(class string) {
 	class = strings.TrimSpace(strings.ToLower(class))
 	if class == "" || class == "clean" {
@@ -1982,7 +2275,7 @@ func printClientAdvice(class string) {
 	case "auth":
 		msg = "auth failure: check ssh key, permissions, and user"
 	case "hostkey":
-		msg = "host key failure: run ssh manually to accept the host key"
+		msg = "host key failure: the server key is not pinned or has changed; verify its fingerprint with the server admin, then run `rpa hostkey pin`"
 	case "dns":
 		msg = "dns failure: check host name and DNS settings"
 	case "network":
@@ -2048,6 +2341,15 @@ func printUsage() {
 	fmt.Println("  rpa metrics [agent|client]   (metrics, default: agent)")
 	fmt.Println("  rpa doctor [agent|client]    (pre-flight checks)")
 	fmt.Println("  rpa config <cmd>             (get/set/show config)")
+	fmt.Println("  rpa audit [flags]            (inbound connection log)")
+	fmt.Println("  rpa ca <cmd>                 (local CA for HTTP proxy TLS)")
+	fmt.Println("  rpa share <port> [flags]     (expose a port from agent.port_pool)")
+	fmt.Println("  rpa hostkey <cmd>            (pin the server host key)")
+	fmt.Println("  rpa keys <cmd>               (dedicated tunnel ssh keys)")
+	fmt.Println("  rpa server-snippet [mode]    (least-privilege server settings)")
+	fmt.Println("  rpa invite --name n [flags]  (onboarding bundle for a teammate)")
+	fmt.Println("  rpa import <bundle>          (install an invitation bundle)")
+	fmt.Println("  rpa grant <cmd>              (access grants in the server's authorized_keys)")
 	fmt.Println("")
 	fmt.Println("Quick help:")
 	fmt.Println("  rpa init --help")
@@ -2083,15 +2385,29 @@ func printAgentUsage() {
 	fmt.Println("  rpa agent up --config rpa.yaml")
 	fmt.Println("  rpa agent down --config rpa.yaml")
 	fmt.Println("  rpa agent run --config rpa.yaml")
-	fmt.Println("  rpa agent add --remote-forward spec --config rpa.yaml")
+	fmt.Println("  rpa agent add --remote-forward spec [--name web] [--description text] [--owner who] [--tags a,b] [--ttl 2h|--until time]")
 	fmt.Println("  rpa agent remove --remote-forward spec --config rpa.yaml")
+	fmt.Println("  rpa agent disable|enable <name|spec> --config rpa.yaml")
+	fmt.Println("  rpa agent export [--output forwards.json] --config rpa.yaml")
+	fmt.Println("  rpa agent import <forwards.json> [--replace] --config rpa.yaml")
 	fmt.Println("  rpa agent clear --config rpa.yaml")
+	fmt.Println("  rpa agent route add --host app.example.com [--path-prefix /api] --target 127.0.0.1:3000")
+	fmt.Println("  rpa agent route remove --host app.example.com [--path-prefix /api]")
+	fmt.Println("  rpa agent route list")
+	fmt.Println("  rpa agent access add --host app.example.com --type basic|bearer|link [--user u] [--ttl 24h]")
+	fmt.Println("  rpa agent access remove --host app.example.com --id <id>")
+	fmt.Println("  rpa agent access list")
 	fmt.Println("")
 	fmt.Println("Notes:")
 	fmt.Println("  up: install & start launchd service (persisted)")
 	fmt.Println("  run: run in foreground for debugging (non-persistent)")
 	fmt.Println("  add/remove: updates config and restarts running agent if active")
+	fmt.Println("  --ttl/--until: the agent removes the forward when it expires (agent.event_hook is notified)")
+	fmt.Println("  disable/enable: keeps the forward in config but stops or resumes forwarding it")
+	fmt.Println("  export/import: remote forwards with their metadata as JSON")
 	fmt.Println("  clear: removes all forwards and stops the service")
+	fmt.Println("  route: edits agent.http_proxy routes; applied without restarting")
+	fmt.Println("  access: per-route basic auth, bearer tokens, and signed links")
 	fmt.Println("  sleep prevention is a config flag: agent.prevent_sleep=true")
 	fmt.Println("")
 	fmt.Println("Remote forward spec example:")
@@ -2107,8 +2423,14 @@ func printClientUsage() {
 	fmt.Println("  rpa client up --config rpa.yaml [--local-forward spec]")
 	fmt.Println("  rpa client down --config rpa.yaml")
 	fmt.Println("  rpa client run --config rpa.yaml [--local-forward spec]")
-	fmt.Println("  rpa client add --local-forward spec --config rpa.yaml")
+	fmt.Println("  rpa client add --local-forward spec [--name db] [--description text] [--owner who] [--tags a,b]")
 	fmt.Println("  rpa client remove --local-forward spec --config rpa.yaml")
+	fmt.Println("  rpa client add --dynamic-forward [bind:]port --config rpa.yaml")
+	fmt.Println("  rpa client remove --dynamic-forward [bind:]port --config rpa.yaml")
+	fmt.Println("  rpa client socks-user add|remove --user name [--password-stdin]")
+	fmt.Println("  rpa client socks-user list")
+	fmt.Println("  rpa client export [--output forwards.json] --config rpa.yaml")
+	fmt.Println("  rpa client import <forwards.json> [--replace] --config rpa.yaml")
 	fmt.Println("  rpa client clear --config rpa.yaml")
 	fmt.Println("")
 	fmt.Println("Notes:")
@@ -2116,6 +2438,7 @@ func printClientUsage() {
 	fmt.Println("  run: run in foreground for debugging (non-persistent)")
 	fmt.Println("  add/remove: updates config and restarts running client if active")
 	fmt.Println("  clear: removes all forwards and stops the service")
+	fmt.Println("  dynamic forwards are SOCKS5 proxies (ssh -D); non-loopback binds require client.socks_users when set")
 	fmt.Println("  sleep prevention is a config flag: client.prevent_sleep=true")
 	fmt.Println("  logs/metrics/doctor: use top-level commands (rpa logs|metrics|doctor)")
 	fmt.Println("")
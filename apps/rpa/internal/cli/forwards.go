@@ -0,0 +1,294 @@
+// Package cli implements forward metadata commands: `rpa agent enable|disable` and JSON export/import.
+// Entries keep their name, description, owner and tags; a disabled entry stays in config but is not forwarded.
+
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"reverse-proxy-agent/pkg/config"
+	ipcclient "reverse-proxy-agent/pkg/ipc/agent"
+)
+
+type entryFlags struct {
+	name        *string
+	description *string
+	owner       *string
+	tags        *string
+}
+
+func addEntryFlags(fs *flag.FlagSet) *entryFlags {
+	return &entryFlags{
+		name:        fs.String("name", "", "forward name shown in status"),
+		description: fs.String("description", "", "what the forward is for"),
+		owner:       fs.String("owner", "", "who the forward was opened for"),
+		tags:        fs.String("tags", "", "comma-separated tags"),
+	}
+}
+
+// entry builds the config entry for spec; created_at is only recorded for
+// entries that carry metadata, so plain adds stay plain strings.
+func (f *entryFlags) entry(spec string) config.ForwardEntry {
+	e := config.ForwardEntry{
+		Name:        strings.TrimSpace(*f.name),
+		Spec:        strings.TrimSpace(spec),
+		Description: strings.TrimSpace(*f.description),
+		Owner:       strings.TrimSpace(*f.owner),
+		Tags:        splitCSV(*f.tags),
+	}
+	if e.Name != "" || e.Description != "" || e.Owner != "" || len(e.Tags) > 0 {
+		now := time.Now().UTC().Truncate(time.Second)
+		e.CreatedAt = &now
+	}
+	return e
+}
+
+// checkForwardName rejects a name already used by a different forward.
+func checkForwardName(entries []config.ForwardEntry, entry config.ForwardEntry) error {
+	if entry.Name == "" {
+		return nil
+	}
+	i := config.FindForwardEntry(entries, entry.Name)
+	if i >= 0 && config.ForwardKey(entries[i].Spec) != config.ForwardKey(entry.Spec) {
+		return fmt.Errorf("forward name %q is already used by %s", entry.Name, entries[i].Spec)
+	}
+	return nil
+}
+
+// parseExpiry turns --ttl or --until into an absolute expiry; nil means the
+// forward is permanent.
+func parseExpiry(ttl time.Duration, until string, now time.Time) (*time.Time, error) {
+	until = strings.TrimSpace(until)
+	if ttl != 0 && until != "" {
+		return nil, fmt.Errorf("use either --ttl or --until")
+	}
+	var at time.Time
+	switch {
+	case ttl < 0:
+		return nil, fmt.Errorf("ttl must be positive")
+	case ttl > 0:
+		at = now.Add(ttl)
+	case until != "":
+		parsed, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			parsed, err = time.ParseInLocation("2006-01-02 15:04", until, time.Local)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid --until %q: use RFC3339 or \"2006-01-02 15:04\"", until)
+		}
+		if !parsed.After(now) {
+			return nil, fmt.Errorf("--until %s is in the past", until)
+		}
+		at = parsed
+	default:
+		return nil, nil
+	}
+	at = at.UTC().Truncate(time.Second)
+	return &at, nil
+}
+
+func forwardLabels(entries []config.ForwardEntry) string {
+	labels := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.IsEnabled() {
+			labels = append(labels, e.Label())
+		}
+	}
+	return strings.Join(labels, ",")
+}
+
+func runAgentEnable(args []string) int {
+	return setAgentForwardEnabled("agent enable", args, true)
+}
+
+func runAgentDisable(args []string) int {
+	return setAgentForwardEnabled("agent disable", args, false)
+}
+
+func setAgentForwardEnabled(name string, args []string, enabled bool) int {
+	ref := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		ref = args[0]
+		args = args[1:]
+	}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if ref == "" && fs.NArg() > 0 {
+		ref = fs.Arg(0)
+	}
+	if strings.TrimSpace(ref) == "" {
+		fmt.Fprintln(os.Stderr, "forward name or spec is required")
+		return exitUsage
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		return exitError
+	}
+	i := config.FindForwardEntry(cfg.SSH.RemoteForwards, ref)
+	if i < 0 {
+		fmt.Fprintf(os.Stderr, "remote forward not found: %s\n", ref)
+		return exitError
+	}
+	entry := &cfg.SSH.RemoteForwards[i]
+	if entry.IsEnabled() == enabled {
+		fmt.Printf("remote forward %s is already %s\n", entry.Label(), enabledWord(enabled))
+		return exitOK
+	}
+	if enabled {
+		entry.Enabled = nil
+	} else {
+		if len(config.NormalizeRemoteForwards(cfg)) == 1 && config.HTTPProxyForward(cfg) == "" {
+			fmt.Fprintln(os.Stderr, "at least one remote forward is required; use `rpa agent clear` to stop the agent")
+			return exitError
+		}
+		disabled := false
+		entry.Enabled = &disabled
+	}
+	if err := config.Save(*configPath, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
+		return exitError
+	}
+
+	if resp, ok, notRunning := tryRuntimeUpdate(func() (*ipcclient.Response, error) {
+		if enabled {
+			return ipcclient.AddRemoteForward(cfg, *entry)
+		}
+		return ipcclient.DisableRemoteForward(cfg, entry.Spec)
+	}); ok {
+		if resp.Message != "" {
+			fmt.Println(resp.Message)
+		}
+	} else if notRunning {
+		fmt.Printf("remote forward %s %s\n", entry.Label(), enabledWord(enabled))
+	} else {
+		return exitError
+	}
+	return exitOK
+}
+
+func enabledWord(enabled bool) string {
+	if enabled {
+		return "enabled"
+	}
+	return "disabled"
+}
+
+// runForwardsExport writes the remote (agent) or local (client) forward
+// entries as a JSON array.
+func runForwardsExport(side string, args []string) int {
+	fs := flag.NewFlagSet(side+" export", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	output := fs.String("output", "", "write to this file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		return exitError
+	}
+	entries := cfg.SSH.RemoteForwards
+	if side == "client" {
+		entries = cfg.Client.LocalForwards
+	}
+	if entries == nil {
+		entries = []config.ForwardEntry{}
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "encode forwards failed: %v\n", err)
+		return exitError
+	}
+	data = append(data, '\n')
+	if *output == "" {
+		_, _ = os.Stdout.Write(data)
+		return exitOK
+	}
+	if err := os.WriteFile(*output, data, 0o600); err != nil {
+		fmt.Fprintf(os.Stderr, "write forwards failed: %v\n", err)
+		return exitError
+	}
+	fmt.Printf("exported %d forwards to %s\n", len(entries), *output)
+	return exitOK
+}
+
+// runForwardsImport merges a JSON array of entries (objects or spec strings)
+// into the config, or replaces the list with --replace. The result is
+// validated before it is saved.
+func runForwardsImport(side string, args []string) int {
+	path := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		path = args[0]
+		args = args[1:]
+	}
+	fs := flag.NewFlagSet(side+" import", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	replace := fs.Bool("replace", false, "replace the existing forwards instead of merging")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if path == "" && fs.NArg() > 0 {
+		path = fs.Arg(0)
+	}
+	if path == "" {
+		fmt.Fprintln(os.Stderr, "import file is required")
+		return exitUsage
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read forwards failed: %v\n", err)
+		return exitError
+	}
+	var imported []config.ForwardEntry
+	if err := json.Unmarshal(data, &imported); err != nil {
+		fmt.Fprintf(os.Stderr, "parse forwards failed: %v\n", err)
+		return exitError
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		return exitError
+	}
+	entries := &cfg.SSH.RemoteForwards
+	validate := config.ValidateAgent
+	if side == "client" {
+		entries = &cfg.Client.LocalForwards
+		validate = config.ValidateClient
+	}
+	if *replace {
+		*entries = nil
+	}
+	for _, entry := range imported {
+		if err := checkForwardName(*entries, entry); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return exitError
+		}
+		*entries = config.UpsertForwardEntry(*entries, entry)
+	}
+	if side == "client" {
+		config.SetLocalForwards(cfg, config.NormalizeLocalForwards(cfg))
+	} else {
+		config.SetRemoteForwards(cfg, config.NormalizeRemoteForwards(cfg))
+	}
+	if err := validate(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "config validation failed: %v\n", err)
+		return exitError
+	}
+	if err := config.Save(*configPath, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
+		return exitError
+	}
+	fmt.Printf("imported %d forwards; restart the %s to apply\n", len(imported), side)
+	return exitOK
+}
@@ -0,0 +1,323 @@
+// Package cli implements `rpa grant`, which edits rpa's section of the server's authorized_keys over ssh.
+// Grants are restricted to port forwarding; expired ones are removed by the running agent.
+
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/grant"
+	"reverse-proxy-agent/pkg/sshutil"
+)
+
+func runGrant(args []string) int {
+	if len(args) == 0 {
+		printGrantUsage()
+		return exitUsage
+	}
+	switch args[0] {
+	case "help", "-h", "--help":
+		printGrantUsage()
+		return exitOK
+	case "add":
+		return runGrantAdd(args[1:])
+	case "list":
+		return runGrantList(args[1:])
+	case "revoke":
+		return runGrantRevoke(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown grant command: %s\n", args[0])
+		printGrantUsage()
+		return exitUsage
+	}
+}
+
+func runGrantAdd(args []string) int {
+	keyArg := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		keyArg = args[0]
+		args = args[1:]
+	}
+	fs := flag.NewFlagSet("grant add", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	name := fs.String("name", "", "grant name (default: the key comment)")
+	expires := fs.String("expires", "", "duration (e.g. 24h) or time (RFC3339 or \"2006-01-02 15:04\") after which the key stops working")
+	var permits []string
+	fs.Func("permit-open", "host:port the key may forward to (repeatable)", func(value string) error {
+		if err := checkPermit(value, true); err != nil {
+			return err
+		}
+		permits = append(permits, fmt.Sprintf("permitopen=%q", value))
+		return nil
+	})
+	fs.Func("permit-listen", "[host:]port the key may listen on with -R (repeatable)", func(value string) error {
+		if err := checkPermit(value, false); err != nil {
+			return err
+		}
+		permits = append(permits, fmt.Sprintf("permitlisten=%q", value))
+		return nil
+	})
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if keyArg == "" && fs.NArg() > 0 {
+		keyArg = fs.Arg(0)
+	}
+	if keyArg == "" {
+		fmt.Fprintln(os.Stderr, "grant add requires a public key or .pub file")
+		printGrantUsage()
+		return exitUsage
+	}
+	if len(permits) == 0 {
+		fmt.Fprintln(os.Stderr, "at least one --permit-open or --permit-listen is required")
+		return exitUsage
+	}
+	pub, comment, err := parseGrantKey(keyArg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitUsage
+	}
+	if *name == "" {
+		*name = grantNameFromComment(comment)
+	}
+	if !keyNamePattern.MatchString(*name) {
+		fmt.Fprintf(os.Stderr, "invalid grant name %q: pass --name with letters, digits, '.', '_' or '-'\n", *name)
+		return exitUsage
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	expiresAt, err := parseGrantExpiry(*expires, now)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitUsage
+	}
+
+	cfg, err := loadGrantConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitError
+	}
+	g := grant.Grant{
+		Name:      *name,
+		PublicKey: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))),
+		Permits:   permits,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+	store := grant.NewSSHStore(cfg)
+	if err := grant.Add(store, g); err != nil {
+		fmt.Fprintf(os.Stderr, "grant add failed: %v\n", err)
+		return exitError
+	}
+	fmt.Printf("granted %s (%s) on %s\n", g.Name, g.Fingerprint(), grantServer(cfg))
+	fmt.Printf("  %s\n", g.Line())
+	refreshGrantCache(cfg, store)
+	return exitOK
+}
+
+func runGrantList(args []string) int {
+	fs := flag.NewFlagSet("grant list", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	cfg, err := loadGrantConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitError
+	}
+	grants, err := grant.List(grant.NewSSHStore(cfg))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grant list failed: %v\n", err)
+		return exitError
+	}
+	if err := saveGrantCache(cfg, grants); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: grant cache not updated: %v\n", err)
+	}
+	if len(grants) == 0 {
+		fmt.Printf("no grants on %s\n", grantServer(cfg))
+		return exitOK
+	}
+	now := time.Now()
+	for _, g := range grants {
+		expiry := "never"
+		if g.ExpiresAt != nil {
+			expiry = g.ExpiresAt.Local().Format(time.RFC3339)
+			if g.Expired(now) {
+				expiry += " (expired)"
+			}
+		}
+		fmt.Printf("%s\t%s\texpires=%s\t%s\n", g.Name, g.Fingerprint(), expiry, strings.Join(g.Permits, ","))
+	}
+	return exitOK
+}
+
+func runGrantRevoke(args []string) int {
+	ref := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		ref = args[0]
+		args = args[1:]
+	}
+	fs := flag.NewFlagSet("grant revoke", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if ref == "" && fs.NArg() > 0 {
+		ref = fs.Arg(0)
+	}
+	if ref == "" {
+		fmt.Fprintln(os.Stderr, "grant revoke requires a grant name or fingerprint")
+		printGrantUsage()
+		return exitUsage
+	}
+	cfg, err := loadGrantConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitError
+	}
+	store := grant.NewSSHStore(cfg)
+	removed, err := grant.Revoke(store, ref)
+	if errors.Is(err, grant.ErrNotFound) {
+		fmt.Fprintf(os.Stderr, "no grant named %s on %s; see `rpa grant list`\n", ref, grantServer(cfg))
+		return exitError
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grant revoke failed: %v\n", err)
+		return exitError
+	}
+	fmt.Printf("revoked %s (%s) on %s\n", removed.Name, removed.Fingerprint(), grantServer(cfg))
+	refreshGrantCache(cfg, store)
+	return exitOK
+}
+
+func loadGrantConfig(path string) (*config.Config, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("config load failed: %w", err)
+	}
+	if strings.TrimSpace(cfg.SSH.Host) == "" {
+		return nil, errors.New("ssh.host is not set")
+	}
+	if err := config.ValidateGrantAdmin(cfg); err != nil {
+		return nil, err
+	}
+	if err := sshutil.SyncKnownHosts(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func grantServer(cfg *config.Config) string {
+	return grant.Server(cfg.SSH.User, cfg.SSH.Host, cfg.SSH.Port)
+}
+
+// refreshGrantCache re-reads the section after an edit so the agent's
+// cache matches what is on the server.
+func refreshGrantCache(cfg *config.Config, store grant.Store) {
+	grants, err := grant.List(store)
+	if err == nil {
+		err = saveGrantCache(cfg, grants)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: grant cache not updated: %v\n", err)
+	}
+}
+
+func saveGrantCache(cfg *config.Config, grants []grant.Grant) error {
+	path, err := grant.CachePath()
+	if err != nil {
+		return err
+	}
+	return grant.SaveCache(path, grantServer(cfg), grants)
+}
+
+func parseGrantKey(value string) (ssh.PublicKey, string, error) {
+	data := []byte(value)
+	if contents, err := os.ReadFile(expandTilde(value)); err == nil {
+		data = contents
+	}
+	pub, comment, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid public key: %w", err)
+	}
+	return pub, comment, nil
+}
+
+// grantNameFromComment turns a key comment such as alice@laptop into a
+// usable grant name.
+func grantNameFromComment(comment string) string {
+	var b strings.Builder
+	for _, r := range strings.TrimSpace(comment) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			b.WriteRune(r)
+		case r == '@' || r == ' ':
+			b.WriteRune('-')
+		}
+	}
+	return strings.Trim(b.String(), "-.")
+}
+
+func parseGrantExpiry(value string, now time.Time) (*time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	if ttl, err := time.ParseDuration(value); err == nil {
+		if ttl <= 0 {
+			return nil, errors.New("--expires must be in the future")
+		}
+		return parseExpiry(ttl, "", now)
+	}
+	at, err := parseExpiry(0, value, now)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --expires %q: use a duration (24h), RFC3339 or \"2006-01-02 15:04\"", value)
+	}
+	return at, nil
+}
+
+// checkPermit keeps values sshd accepts and that cannot break out of the
+// quoted option.
+func checkPermit(value string, needHost bool) error {
+	if value == "" || strings.ContainsAny(value, "\"\\, \t") {
+		return fmt.Errorf("invalid permit %q", value)
+	}
+	host, port := "", value
+	if i := strings.LastIndex(value, ":"); i >= 0 {
+		host, port = value[:i], value[i+1:]
+	}
+	if needHost && host == "" {
+		return fmt.Errorf("invalid permit %q: use host:port", value)
+	}
+	if port == "*" {
+		return nil
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid permit %q: bad port", value)
+	}
+	return nil
+}
+
+func printGrantUsage() {
+	fmt.Println("rpa grant")
+	fmt.Println("")
+	fmt.Println("Usage:")
+	fmt.Println("  rpa grant add <public-key|file.pub> --permit-open host:port [--permit-listen [host:]port] [--expires 24h] [--name name] [--config rpa.yaml]")
+	fmt.Println("  rpa grant list [--config rpa.yaml]")
+	fmt.Println("  rpa grant revoke <name|fingerprint> [--config rpa.yaml]")
+	fmt.Println("")
+	fmt.Println("Notes:")
+	fmt.Println("  grants live in a marked section of ~/.ssh/authorized_keys for ssh.user on ssh.host")
+	fmt.Println("  an ssh.user locked down with ForceCommand has no shell: set ssh.grant_admin to edit it from another account")
+	fmt.Println("  each write keeps the previous file as authorized_keys.rpa-bak")
+	fmt.Println("  sshd rejects a key after its expiry-time; the running agent also removes it")
+}
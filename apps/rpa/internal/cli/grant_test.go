@@ -0,0 +1,32 @@
+package cli
+
+import "testing"
+
+func TestCheckPermit(t *testing.T) {
+	tests := []struct {
+		value    string
+		needHost bool
+		ok       bool
+	}{
+		{"db.internal:5432", true, true},
+		{"[2001:db8::1]:443", true, true},
+		{"localhost:*", true, true},
+		{"8080", false, true},
+		{"0.0.0.0:8080", false, true},
+		{"", true, false},
+		{"5432", true, false},
+		{"db:0", true, false},
+		{"db:65536", true, false},
+		{"db:http", true, false},
+		{`db:5432",command="sh`, true, false},
+		{"db:5432,other:22", true, false},
+		{"db:54 32", true, false},
+		{`db\:5432`, true, false},
+	}
+	for _, tt := range tests {
+		err := checkPermit(tt.value, tt.needHost)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkPermit(%q, %v) = %v, want ok=%v", tt.value, tt.needHost, err, tt.ok)
+		}
+	}
+}
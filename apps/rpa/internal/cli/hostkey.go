@@ -0,0 +1,295 @@
+// Package cli implements `rpa hostkey`, which pins the server's host key instead of trusting it on first use.
+// The key is fetched with a throwaway known_hosts file and only kept once its fingerprint is confirmed.
+
+package cli
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/hostkey"
+	"reverse-proxy-agent/pkg/sshutil"
+)
+
+const hostKeyFetchTimeout = 20 * time.Second
+
+func runHostKey(args []string) int {
+	if len(args) == 0 {
+		printHostKeyUsage()
+		return exitUsage
+	}
+	switch args[0] {
+	case "help", "-h", "--help":
+		printHostKeyUsage()
+		return exitOK
+	case "pin":
+		return runHostKeyPin(args[1:])
+	case "show":
+		return runHostKeyShow(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown hostkey command: %s\n", args[0])
+		printHostKeyUsage()
+		return exitUsage
+	}
+}
+
+func runHostKeyPin(args []string) int {
+	fs := flag.NewFlagSet("hostkey pin", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	expect := fs.String("fingerprint", "", "expected SHA256 fingerprint, obtained out of band")
+	yes := fs.Bool("yes", false, "pin without asking for confirmation")
+	add := fs.Bool("add", false, "keep already pinned fingerprints (e.g. during key rotation)")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *expect != "" && !hostkey.ValidFingerprint(*expect) {
+		fmt.Fprintf(os.Stderr, "invalid fingerprint %q: expected SHA256:...\n", *expect)
+		return exitUsage
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		return exitError
+	}
+	keys, err := fetchHostKeys(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fetch host key failed: %v\n", err)
+		return exitError
+	}
+	addr := hostkey.Pattern(cfg.SSH.Host, cfg.SSH.Port)
+	for _, key := range keys {
+		fmt.Printf("%s presents %s %s\n", addr, key.Type(), hostkey.Fingerprint(key))
+	}
+	if len(cfg.SSH.HostKeyFingerprints) > 0 && !anyPinned(keys, cfg.SSH.HostKeyFingerprints) {
+		fmt.Fprintf(os.Stderr, "WARNING: the server key does not match the pinned fingerprint(s) %s\n", strings.Join(cfg.SSH.HostKeyFingerprints, ", "))
+		fmt.Fprintln(os.Stderr, "the server key may have been rotated, or the connection is being intercepted")
+	}
+
+	switch {
+	case *expect != "":
+		if !anyPinned(keys, []string{*expect}) {
+			fmt.Fprintf(os.Stderr, "host key mismatch: expected %s; not pinning\n", *expect)
+			return exitError
+		}
+		keys = matchingKeys(keys, *expect)
+	case !*yes:
+		fmt.Print("Pin this key? Compare the fingerprint with one obtained from the server admin first [y/N]: ")
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answer := strings.ToLower(strings.TrimSpace(line))
+		if answer != "y" && answer != "yes" {
+			fmt.Println("not pinned")
+			return exitError
+		}
+	}
+
+	var pins []string
+	if *add {
+		pins = append(pins, cfg.SSH.HostKeyFingerprints...)
+	}
+	for _, key := range keys {
+		if fp := hostkey.Fingerprint(key); !containsFingerprint(pins, fp) {
+			pins = append(pins, fp)
+		}
+	}
+	cfg.SSH.HostKeyFingerprints = pins
+	path, err := config.KnownHostsPath(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitError
+	}
+	if err := hostkey.Add(path, cfg.SSH.Host, cfg.SSH.Port, keys); err != nil {
+		fmt.Fprintf(os.Stderr, "known_hosts update failed: %v\n", err)
+		return exitError
+	}
+	if err := sshutil.SyncKnownHosts(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "known_hosts update failed: %v\n", err)
+		return exitError
+	}
+	if err := config.Save(*configPath, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
+		return exitError
+	}
+	fmt.Printf("pinned %s in %s (known_hosts: %s)\n", strings.Join(pins, ", "), *configPath, path)
+	fmt.Println("restart running tunnels to apply: rpa agent down && rpa agent up")
+	return exitOK
+}
+
+func runHostKeyShow(args []string) int {
+	fs := flag.NewFlagSet("hostkey show", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		return exitError
+	}
+	if !config.HostKeysPinned(cfg) {
+		fmt.Println("host keys: not pinned (trust on first use via StrictHostKeyChecking=accept-new)")
+		fmt.Println("pin with: rpa hostkey pin")
+		return exitOK
+	}
+	path, err := config.KnownHostsPath(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitError
+	}
+	fmt.Printf("known_hosts: %s\n", path)
+	keys, err := hostkey.Keys(path, cfg.SSH.Host, cfg.SSH.Port)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "known_hosts read failed: %v\n", err)
+		return exitError
+	}
+	for _, fp := range cfg.SSH.HostKeyFingerprints {
+		state := "missing from known_hosts"
+		for _, key := range keys {
+			if hostkey.SameFingerprint(hostkey.Fingerprint(key), fp) {
+				state = key.Type()
+			}
+		}
+		fmt.Printf("pinned: %s (%s)\n", fp, state)
+	}
+	if ca := strings.TrimSpace(cfg.SSH.HostKeyCA); ca != "" {
+		if key, err := hostkey.ParseCA(ca); err == nil {
+			fmt.Printf("host ca: %s %s\n", key.Type(), hostkey.Fingerprint(key))
+		}
+	}
+	return exitOK
+}
+
+// checkHostKeys reports on host key verification: a warning for trust on
+// first use, and a failure when pins exist but none can be checked.
+func checkHostKeys(cfg *config.Config) bool {
+	if !config.HostKeysPinned(cfg) {
+		fmt.Fprintln(os.Stderr, "check host key: WARN (trust on first use; verify the fingerprint and run `rpa hostkey pin`)")
+		return true
+	}
+	path, err := config.KnownHostsPath(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "check host key: FAIL (%v)\n", err)
+		return false
+	}
+	keys, err := hostkey.Keys(path, cfg.SSH.Host, cfg.SSH.Port)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "check host key: FAIL (%v)\n", err)
+		return false
+	}
+	found := 0
+	for _, fp := range cfg.SSH.HostKeyFingerprints {
+		if anyPinned(keys, []string{fp}) {
+			found++
+		} else {
+			fmt.Fprintf(os.Stderr, "check host key: WARN (%s has no key in %s; run `rpa hostkey pin --add --fingerprint %s`)\n", fp, path, fp)
+		}
+	}
+	hasCA := strings.TrimSpace(cfg.SSH.HostKeyCA) != ""
+	if found == 0 && !hasCA {
+		fmt.Fprintf(os.Stderr, "check host key: FAIL (no pinned key for %s in %s; run `rpa hostkey pin`)\n", cfg.SSH.Host, path)
+		return false
+	}
+	detail := fmt.Sprintf("%d pinned key(s)", found)
+	if hasCA {
+		detail += " + host ca"
+	}
+	fmt.Printf("check host key: OK (%s, strict)\n", detail)
+	return true
+}
+
+// fetchHostKeys connects the way the tunnel would, with accept-new against an
+// empty temporary known_hosts, and returns the key ssh recorded. The session
+// itself is not needed, so authentication may fail.
+func fetchHostKeys(cfg *config.Config) ([]ssh.PublicKey, error) {
+	dir, err := os.MkdirTemp("", "rpa-hostkey-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	knownHosts := filepath.Join(dir, "known_hosts")
+
+	args := []string{
+		"-T",
+		"-o", "BatchMode=yes",
+		"-o", "StrictHostKeyChecking=accept-new",
+		"-o", "UserKnownHostsFile=" + knownHosts,
+		"-o", "GlobalKnownHostsFile=/dev/null",
+		"-o", "HashKnownHosts=no",
+		"-o", "CheckHostIP=no",
+		"-o", "ConnectTimeout=10",
+		"-o", "ClearAllForwardings=yes",
+	}
+	// ssh keeps the first value of each option, so the pinned known_hosts
+	// settings in the connection arguments do not override the ones above.
+	args = append(args, sshutil.ConnectionArgs(cfg)...)
+	args = append(args, "exit")
+
+	ctx, cancel := context.WithTimeout(context.Background(), hostKeyFetchTimeout)
+	defer cancel()
+	out, runErr := exec.CommandContext(ctx, "ssh", args...).CombinedOutput()
+	keys, err := hostkey.Keys(knownHosts, cfg.SSH.Host, cfg.SSH.Port)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return nil, fmt.Errorf("%s", lastLine(msg))
+		}
+		if runErr != nil {
+			return nil, runErr
+		}
+		return nil, fmt.Errorf("ssh did not record a host key for %s", cfg.SSH.Host)
+	}
+	return keys, nil
+}
+
+func anyPinned(keys []ssh.PublicKey, pins []string) bool {
+	for _, key := range keys {
+		if containsFingerprint(pins, hostkey.Fingerprint(key)) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchingKeys(keys []ssh.PublicKey, fp string) []ssh.PublicKey {
+	var out []ssh.PublicKey
+	for _, key := range keys {
+		if hostkey.SameFingerprint(hostkey.Fingerprint(key), fp) {
+			out = append(out, key)
+		}
+	}
+	return out
+}
+
+func containsFingerprint(pins []string, fp string) bool {
+	for _, pin := range pins {
+		if hostkey.SameFingerprint(pin, fp) {
+			return true
+		}
+	}
+	return false
+}
+
+func printHostKeyUsage() {
+	fmt.Println("rpa hostkey")
+	fmt.Println("")
+	fmt.Println("Usage:")
+	fmt.Println("  rpa hostkey pin [--fingerprint SHA256:...] [--yes] [--add] [--config rpa.yaml]")
+	fmt.Println("  rpa hostkey show [--config rpa.yaml]")
+	fmt.Println("")
+	fmt.Println("Notes:")
+	fmt.Println("  pin fetches the server's current key and saves its fingerprint to ssh.host_key_fingerprints")
+	fmt.Println("  pinned keys live in ~/.rpa/known_hosts and ssh then runs with StrictHostKeyChecking=yes")
+	fmt.Println("  --fingerprint pins only if the server presents that key; --add keeps existing pins")
+}
@@ -0,0 +1,482 @@
+// Package cli implements `rpa invite` and `rpa import` for onboarding teammates as clients.
+// The inviter keeps a record without the private key so that revoking can print the server-side line to remove.
+
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/forward"
+	"reverse-proxy-agent/pkg/hostkey"
+	"reverse-proxy-agent/pkg/invite"
+)
+
+func runInvite(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "help", "-h", "--help":
+			printInviteUsage()
+			return exitOK
+		case "list":
+			return runInviteList(args[1:])
+		case "revoke":
+			return runInviteRevoke(args[1:])
+		}
+	}
+	return runInviteCreate(args)
+}
+
+func runInviteCreate(args []string) int {
+	fs := flag.NewFlagSet("invite", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	name := fs.String("name", "", "who the invitation is for (required)")
+	out := fs.String("out", "", "bundle path (default <name>.rpa-invite)")
+	sshUser := fs.String("ssh-user", "", "server account for the recipient (default: ssh.user)")
+	encrypt := fs.Bool("encrypt", false, "seal the bundle with a passphrase (prompted)")
+	passphraseStdin := fs.Bool("passphrase-stdin", false, "seal the bundle with a passphrase read from stdin")
+	force := fs.Bool("force", false, "replace an existing invitation with the same name")
+	var localForwards, dynamicForwards []string
+	fs.Func("local-forward", "local forward spec for the recipient (repeatable)", func(value string) error {
+		if strings.TrimSpace(value) == "" {
+			return errors.New("local-forward cannot be empty")
+		}
+		localForwards = append(localForwards, value)
+		return nil
+	})
+	fs.Func("dynamic-forward", "SOCKS forward spec for the recipient (repeatable)", func(value string) error {
+		if strings.TrimSpace(value) == "" {
+			return errors.New("dynamic-forward cannot be empty")
+		}
+		dynamicForwards = append(dynamicForwards, value)
+		return nil
+	})
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if !keyNamePattern.MatchString(*name) {
+		fmt.Fprintln(os.Stderr, "--name is required (letters, digits, '.', '_' or '-')")
+		printInviteUsage()
+		return exitUsage
+	}
+	if len(localForwards) == 0 && len(dynamicForwards) == 0 {
+		fmt.Fprintln(os.Stderr, "at least one --local-forward or --dynamic-forward is required")
+		return exitUsage
+	}
+	for _, spec := range append(append([]string(nil), localForwards...), dynamicForwards...) {
+		if _, err := forward.Parse(spec); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return exitUsage
+		}
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		return exitError
+	}
+	dir, err := invite.Dir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitError
+	}
+	if r, err := invite.LoadRecord(dir, *name); err == nil && r.RevokedAt == nil && !*force {
+		fmt.Fprintf(os.Stderr, "invitation %s already exists; revoke it first or use --force\n", *name)
+		return exitError
+	}
+	passphrase := ""
+	if *encrypt || *passphraseStdin {
+		if passphrase, err = readPassphrase(*passphraseStdin); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return exitUsage
+		}
+	}
+
+	b, err := newBundle(cfg, *name, localForwards, dynamicForwards)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invite failed: %v\n", err)
+		return exitError
+	}
+	if *sshUser != "" {
+		b.User = *sshUser
+	}
+	data, err := invite.Encode(b, passphrase)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invite failed: %v\n", err)
+		return exitError
+	}
+	path := *out
+	if path == "" {
+		path = *name + ".rpa-invite"
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		fmt.Fprintf(os.Stderr, "write bundle failed: %v\n", err)
+		return exitError
+	}
+	pub, _, _, _, _ := ssh.ParseAuthorizedKey([]byte(b.PublicKey))
+	record := invite.Record{
+		Name:           b.Name,
+		CreatedAt:      b.CreatedAt,
+		User:           b.User,
+		Host:           b.Host,
+		PublicKey:      b.PublicKey,
+		AuthorizedKeys: b.AuthorizedKeys,
+		LocalForwards:  append(append([]string(nil), b.LocalForwards...), b.DynamicForwards...),
+	}
+	if pub != nil {
+		record.Fingerprint = ssh.FingerprintSHA256(pub)
+	}
+	if err := invite.SaveRecord(dir, record); err != nil {
+		fmt.Fprintf(os.Stderr, "save invitation record failed: %v\n", err)
+		return exitError
+	}
+
+	fmt.Printf("invitation for %s written to %s", b.Name, path)
+	if passphrase != "" {
+		fmt.Print(" (sealed; send the passphrase through a different channel)")
+	}
+	fmt.Println()
+	if len(b.HostKeyFingerprints) == 0 && b.HostKeyCA == "" {
+		fmt.Fprintln(os.Stderr, "warning: the server host key is not pinned, so the recipient will trust it on first use; run `rpa hostkey pin` and invite again")
+	}
+	if len(b.JumpHosts) > 0 {
+		fmt.Printf("note: the recipient also needs access to %s\n", config.JumpChain(b.JumpHosts))
+	}
+	fmt.Printf("server admin: add this line to ~%s/.ssh/authorized_keys on %s:\n", b.User, b.Host)
+	fmt.Printf("  %s\n", b.AuthorizedKeys)
+	fmt.Printf("recipient: rpa import %s\n", filepath.Base(path))
+	return exitOK
+}
+
+// newBundle generates the recipient's key and fills in the server settings
+// from cfg. Identity files of jump hosts are dropped since they are paths on
+// this machine, and so are hop options a bundle may not carry.
+func newBundle(cfg *config.Config, name string, localForwards, dynamicForwards []string) (*invite.Bundle, error) {
+	comment := "rpa-invite-" + name
+	private, pub, err := newKeyPair(comment)
+	if err != nil {
+		return nil, err
+	}
+	b := &invite.Bundle{
+		Name:                name,
+		CreatedAt:           time.Now().UTC().Truncate(time.Second),
+		User:                cfg.SSH.User,
+		Host:                cfg.SSH.Host,
+		Port:                cfg.SSH.Port,
+		HostKeyFingerprints: cfg.SSH.HostKeyFingerprints,
+		LocalForwards:       localForwards,
+		DynamicForwards:     dynamicForwards,
+		PrivateKey:          string(private),
+		PublicKey:           strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))) + " " + comment,
+	}
+	for _, hop := range cfg.SSH.JumpHosts {
+		hop.IdentityFile = ""
+		var options []string
+		for _, opt := range hop.Options {
+			if invite.HopOptionAllowed(opt) {
+				options = append(options, opt)
+			} else {
+				fmt.Fprintf(os.Stderr, "warning: jump host %s option %q is left out of the bundle\n", hop.Spec(), opt)
+			}
+		}
+		hop.Options = options
+		b.JumpHosts = append(b.JumpHosts, hop)
+	}
+	if ca := strings.TrimSpace(cfg.SSH.HostKeyCA); ca != "" {
+		key, err := hostkey.ParseCA(ca)
+		if err != nil {
+			return nil, err
+		}
+		b.HostKeyCA = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	}
+	if len(b.HostKeyFingerprints) > 0 {
+		path, err := config.KnownHostsPath(cfg)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := hostkey.Keys(path, cfg.SSH.Host, cfg.SSH.Port)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if containsFingerprint(b.HostKeyFingerprints, hostkey.Fingerprint(key)) {
+				b.HostKeys = append(b.HostKeys, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))))
+			}
+		}
+	}
+
+	scope := config.Config{SSH: cfg.SSH}
+	config.SetLocalForwards(&scope, localForwards)
+	config.SetDynamicForwards(&scope, dynamicForwards)
+	options := authorizedKeysOptions(buildServerSnippet(&scope, "client"))
+	b.AuthorizedKeys = strings.Join(options, ",") + " " + b.PublicKey
+	return b, nil
+}
+
+func runInviteList(args []string) int {
+	fs := flag.NewFlagSet("invite list", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	dir, err := invite.Dir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitError
+	}
+	records, err := invite.Records(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitError
+	}
+	if len(records) == 0 {
+		fmt.Println("no invitations")
+		return exitOK
+	}
+	for _, r := range records {
+		line := fmt.Sprintf("%s %s@%s %s created=%s", r.Name, r.User, r.Host, r.Fingerprint, r.CreatedAt.Format(time.RFC3339))
+		if len(r.LocalForwards) > 0 {
+			line += " forwards=" + strings.Join(r.LocalForwards, ",")
+		}
+		if r.RevokedAt != nil {
+			line += " revoked=" + r.RevokedAt.Format(time.RFC3339)
+		}
+		fmt.Println(line)
+	}
+	return exitOK
+}
+
+// runInviteRevoke marks the invitation revoked and prints what the server
+// admin has to remove; rpa has no access to the server's authorized_keys.
+func runInviteRevoke(args []string) int {
+	name := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name = args[0]
+		args = args[1:]
+	}
+	fs := flag.NewFlagSet("invite revoke", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if name == "" && fs.NArg() > 0 {
+		name = fs.Arg(0)
+	}
+	if !keyNamePattern.MatchString(name) {
+		fmt.Fprintln(os.Stderr, "invitation name is required")
+		return exitUsage
+	}
+	dir, err := invite.Dir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitError
+	}
+	r, err := invite.LoadRecord(dir, name)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "invitation not found: %s\n", name)
+		return exitError
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitError
+	}
+	if r.RevokedAt == nil {
+		now := time.Now().UTC().Truncate(time.Second)
+		r.RevokedAt = &now
+		if err := invite.SaveRecord(dir, *r); err != nil {
+			fmt.Fprintf(os.Stderr, "save invitation record failed: %v\n", err)
+			return exitError
+		}
+	}
+	fields := strings.Fields(r.PublicKey)
+	fmt.Printf("invitation %s revoked (%s)\n", r.Name, r.Fingerprint)
+	fmt.Printf("server admin: remove this line from ~%s/.ssh/authorized_keys on %s:\n", r.User, r.Host)
+	fmt.Printf("  %s\n", r.AuthorizedKeys)
+	if len(fields) >= 2 {
+		fmt.Println("or run as that user on the server:")
+		fmt.Printf("  grep -vF '%s' ~/.ssh/authorized_keys > ~/.ssh/authorized_keys.new && mv ~/.ssh/authorized_keys.new ~/.ssh/authorized_keys\n", fields[1])
+	}
+	fmt.Println("sessions already open with that key stay up until they disconnect")
+	return exitOK
+}
+
+func runImport(args []string) int {
+	path := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		path = args[0]
+		args = args[1:]
+	}
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	passphraseStdin := fs.Bool("passphrase-stdin", false, "read the bundle passphrase from stdin")
+	force := fs.Bool("force", false, "point an existing config at the invited server")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if path == "" && fs.NArg() > 0 {
+		path = fs.Arg(0)
+	}
+	if path == "" {
+		fmt.Fprintln(os.Stderr, "bundle path is required")
+		printInviteUsage()
+		return exitUsage
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read bundle failed: %v\n", err)
+		return exitError
+	}
+	passphrase := ""
+	if invite.Sealed(data) {
+		if passphrase, err = readPassphrase(*passphraseStdin); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return exitUsage
+		}
+	}
+	b, err := invite.Decode(data, passphrase)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitError
+	}
+	if !keyNamePattern.MatchString(b.Name) {
+		fmt.Fprintf(os.Stderr, "bundle name %q is not a valid name\n", b.Name)
+		return exitError
+	}
+
+	cfg, err := config.Load(*configPath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		cfg = &config.Config{}
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		return exitError
+	case !*force && (cfg.SSH.Host != b.Host || cfg.SSH.User != b.User || cfg.SSH.Port != b.Port):
+		fmt.Fprintf(os.Stderr, "%s already connects to %s@%s:%d; use --force to switch it to %s@%s:%d or --config for a separate file\n",
+			*configPath, cfg.SSH.User, cfg.SSH.Host, cfg.SSH.Port, b.User, b.Host, b.Port)
+		return exitError
+	}
+
+	dir, err := config.KeysDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitError
+	}
+	keyName := "invite-" + b.Name
+	pub, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(b.PublicKey))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bundle public key: %v\n", err)
+		return exitError
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitError
+	}
+	if err := writeKeyPair(filepath.Join(dir, keyName), []byte(b.PrivateKey), pub, comment); err != nil {
+		fmt.Fprintf(os.Stderr, "write key failed: %v\n", err)
+		return exitError
+	}
+
+	cfg.SSH.User = b.User
+	cfg.SSH.Host = b.Host
+	cfg.SSH.Port = b.Port
+	cfg.SSH.ConfigAlias = ""
+	cfg.SSH.JumpHosts = b.JumpHosts
+	cfg.SSH.IdentityFile = "~/.rpa/keys/" + keyName
+	cfg.SSH.HostKeyFingerprints = b.HostKeyFingerprints
+	cfg.SSH.HostKeyCA = b.HostKeyCA
+	for _, spec := range b.LocalForwards {
+		cfg.Client.LocalForwards = config.UpsertForwardEntry(cfg.Client.LocalForwards, config.ForwardEntry{Spec: spec})
+	}
+	if len(b.DynamicForwards) > 0 {
+		config.SetDynamicForwards(cfg, append(cfg.Client.DynamicForwards, b.DynamicForwards...))
+	}
+	config.ApplyDefaults(cfg)
+	if err := config.ValidateClient(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "config validation failed: %v\n", err)
+		return exitError
+	}
+	for _, hop := range b.JumpHosts {
+		fmt.Printf("jump host: %s", hop.Spec())
+		if len(hop.Options) > 0 {
+			fmt.Printf(" (%s)", strings.Join(hop.Options, ", "))
+		}
+		fmt.Println()
+	}
+
+	if len(b.HostKeys) > 0 {
+		var keys []ssh.PublicKey
+		for _, line := range b.HostKeys {
+			key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "bundle host key: %v\n", err)
+				return exitError
+			}
+			keys = append(keys, key)
+		}
+		knownHosts, err := config.KnownHostsPath(cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return exitError
+		}
+		if err := hostkey.Add(knownHosts, b.Host, b.Port, keys); err != nil {
+			fmt.Fprintf(os.Stderr, "known_hosts update failed: %v\n", err)
+			return exitError
+		}
+	}
+	if err := config.Save(*configPath, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
+		return exitError
+	}
+
+	fmt.Printf("imported invitation %s: %s@%s:%d\n", b.Name, b.User, b.Host, b.Port)
+	fmt.Printf("  identity: %s (%s)\n", cfg.SSH.IdentityFile, ssh.FingerprintSHA256(pub))
+	for _, spec := range b.LocalForwards {
+		fmt.Printf("  local forward: %s\n", spec)
+	}
+	for _, spec := range b.DynamicForwards {
+		fmt.Printf("  dynamic forward: %s\n", spec)
+	}
+	if len(b.HostKeyFingerprints) > 0 {
+		fmt.Printf("  host key pinned: %s\n", strings.Join(b.HostKeyFingerprints, ", "))
+	} else if b.HostKeyCA == "" {
+		fmt.Fprintln(os.Stderr, "warning: the bundle has no pinned host key; verify the server fingerprint and run `rpa hostkey pin`")
+	}
+	fmt.Println("next: rpa doctor client && rpa client up")
+	return exitOK
+}
+
+func readPassphrase(fromStdin bool) (string, error) {
+	if !fromStdin {
+		fmt.Fprint(os.Stderr, "Passphrase (input is echoed; use --passphrase-stdin to pipe): ")
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	passphrase := strings.TrimRight(line, "\r\n")
+	if passphrase == "" {
+		return "", fmt.Errorf("passphrase is empty")
+	}
+	return passphrase, nil
+}
+
+func printInviteUsage() {
+	fmt.Println("rpa invite")
+	fmt.Println("")
+	fmt.Println("Usage:")
+	fmt.Println("  rpa invite --name alice --local-forward spec [--dynamic-forward spec] [--encrypt|--passphrase-stdin] [--out file] [--ssh-user user] [--config rpa.yaml]")
+	fmt.Println("  rpa invite list")
+	fmt.Println("  rpa invite revoke <name>")
+	fmt.Println("  rpa import <bundle> [--passphrase-stdin] [--force] [--config rpa.yaml]")
+	fmt.Println("")
+	fmt.Println("Notes:")
+	fmt.Println("  the bundle holds a new key pair, the server settings, the pinned host key and the forwards")
+	fmt.Println("  the server admin adds the printed authorized_keys line; revoke prints the line to remove")
+}
@@ -0,0 +1,116 @@
+// Package cli implements the doctor checks for ssh.jump_hosts.
+// Each hop is checked from the one before it, since later hops are usually only reachable through the chain.
+
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/sshutil"
+)
+
+const (
+	hopDialTimeout   = 5 * time.Second
+	hopBannerTimeout = 15 * time.Second
+)
+
+// checkSSHRoute checks that ssh.host can be reached: directly by DNS, or hop
+// by hop through ssh.jump_hosts, stopping at the first failure.
+func checkSSHRoute(cfg *config.Config) bool {
+	hops := cfg.SSH.JumpHosts
+	if len(hops) == 0 {
+		if _, err := net.LookupHost(cfg.SSH.Host); err != nil {
+			fmt.Fprintf(os.Stderr, "check host resolve: FAIL (%v)\n", err)
+			return false
+		}
+		fmt.Println("check host resolve: OK")
+		return true
+	}
+	fmt.Printf("jump chain: %s -> %s\n", config.JumpChain(hops), cfg.SSH.Host)
+	for i, hop := range hops {
+		label := fmt.Sprintf("jump host %d (%s)", i+1, hop.Spec())
+		if hop.IdentityFile != "" {
+			if _, err := os.Stat(expandTilde(hop.IdentityFile)); err != nil {
+				fmt.Fprintf(os.Stderr, "check %s identity file: FAIL (%v)\n", label, err)
+				return false
+			}
+		}
+		if i == 0 {
+			if _, err := net.LookupHost(hop.Host); err != nil {
+				fmt.Fprintf(os.Stderr, "check %s resolve: FAIL (%v)\n", label, err)
+				return false
+			}
+			fmt.Printf("check %s resolve: OK\n", label)
+			conn, err := net.DialTimeout("tcp", hop.Addr(), hopDialTimeout)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "check %s tcp: FAIL (%v)\n", label, err)
+				return false
+			}
+			_ = conn.Close()
+			fmt.Printf("check %s tcp: OK\n", label)
+			continue
+		}
+		if err := checkHopBanner(hops[:i], hop.Addr()); err != nil {
+			fmt.Fprintf(os.Stderr, "check %s via jump host %d: FAIL (%v)\n", label, i, err)
+			return false
+		}
+		fmt.Printf("check %s via jump host %d: OK\n", label, i)
+	}
+	target := config.JumpHost{Host: cfg.SSH.Host, Port: cfg.SSH.Port}
+	if err := checkHopBanner(hops, target.Addr()); err != nil {
+		fmt.Fprintf(os.Stderr, "check host %s via jump host %d: FAIL (%v)\n", target.Addr(), len(hops), err)
+		return false
+	}
+	fmt.Printf("check host %s via jump host %d: OK\n", target.Addr(), len(hops))
+	return true
+}
+
+// checkHopBanner opens `ssh -W addr` on the last of hops, routed through the
+// others, and expects an SSH banner, which proves DNS and TCP from that hop.
+func checkHopBanner(hops []config.JumpHost, addr string) error {
+	last := len(hops) - 1
+	args := []string{"-T", "-o", "BatchMode=yes", "-o", "ConnectTimeout=5", "-o", "ClearAllForwardings=yes"}
+	args = append(args, sshutil.JumpArgs(hops[:last])...)
+	hop := sshutil.HopArgs(hops[last])
+	args = append(args, hop[:len(hop)-1]...)
+	args = append(args, "-W", addr, hop[len(hop)-1])
+
+	ctx, cancel := context.WithTimeout(context.Background(), hopBannerTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "ssh", args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	_ = cmd.Process.Kill()
+	_ = cmd.Wait()
+	if strings.HasPrefix(line, "SSH-") {
+		return nil
+	}
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("%s", lastLine(msg))
+	}
+	if err != nil {
+		return fmt.Errorf("no ssh banner: %w", err)
+	}
+	return fmt.Errorf("unexpected banner %q", strings.TrimSpace(line))
+}
+
+func lastLine(value string) string {
+	lines := strings.Split(value, "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
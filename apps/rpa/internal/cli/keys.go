@@ -0,0 +1,281 @@
+// Package cli implements `rpa keys`, which manages dedicated ssh identities for tunnels.
+// A dedicated key can be restricted to port forwarding on the server, unlike the user's personal key.
+
+package cli
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"reverse-proxy-agent/pkg/config"
+)
+
+var keyNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// defaultIdentities are the files ssh offers on its own, so they normally
+// serve interactive logins as well.
+var defaultIdentities = []string{"id_rsa", "id_ecdsa", "id_ecdsa_sk", "id_ed25519", "id_ed25519_sk", "id_dsa"}
+
+func runKeys(args []string) int {
+	if len(args) == 0 {
+		printKeysUsage()
+		return exitUsage
+	}
+	switch args[0] {
+	case "help", "-h", "--help":
+		printKeysUsage()
+		return exitOK
+	case "generate":
+		return runKeysGenerate(args[1:])
+	case "show":
+		return runKeysShow(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown keys command: %s\n", args[0])
+		printKeysUsage()
+		return exitUsage
+	}
+}
+
+func runKeysGenerate(args []string) int {
+	fs := flag.NewFlagSet("keys generate", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	name := fs.String("name", "rpa", "key file name under ~/.rpa/keys")
+	comment := fs.String("comment", "", "public key comment (default rpa@<hostname>)")
+	force := fs.Bool("force", false, "replace an existing key with the same name")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if !keyNamePattern.MatchString(*name) {
+		fmt.Fprintf(os.Stderr, "invalid key name %q: use letters, digits, '.', '_' or '-'\n", *name)
+		return exitUsage
+	}
+	if *comment == "" {
+		host, _ := os.Hostname()
+		*comment = "rpa@" + host
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		return exitError
+	}
+
+	dir, err := config.KeysDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitError
+	}
+	path := filepath.Join(dir, *name)
+	if _, err := os.Stat(path); err == nil && !*force {
+		fmt.Fprintf(os.Stderr, "%s already exists; use --force to replace it, or `rpa config set ssh.identity_file ~/.rpa/keys/%s` to use it\n", path, *name)
+		return exitError
+	}
+	pub, err := generateKey(path, *comment)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "key generation failed: %v\n", err)
+		return exitError
+	}
+	identity := "~/.rpa/keys/" + *name
+	fmt.Printf("generated %s (%s %s)\n", path, pub.Type(), ssh.FingerprintSHA256(pub))
+
+	if cfg != nil {
+		cfg.SSH.IdentityFile = identity
+		if err := config.Save(*configPath, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
+			return exitError
+		}
+		fmt.Printf("ssh.identity_file set to %s in %s\n", identity, *configPath)
+	} else {
+		fmt.Printf("no config at %s; pass --ssh-identity-file %s to rpa init\n", *configPath, identity)
+	}
+	printAuthorizeHint(pub, *comment)
+	return exitOK
+}
+
+// generateKey writes an unencrypted ed25519 key pair in OpenSSH format,
+// private 0600 and public 0644, in a 0700 directory.
+func generateKey(path, comment string) (ssh.PublicKey, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	private, pub, err := newKeyPair(comment)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeKeyPair(path, private, pub, comment); err != nil {
+		return nil, err
+	}
+	return pub, nil
+}
+
+// newKeyPair returns a PEM-encoded OpenSSH ed25519 private key and its
+// public half.
+func newKeyPair(comment string) ([]byte, ssh.PublicKey, error) {
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(privKey, comment)
+	if err != nil {
+		return nil, nil, err
+	}
+	pub, err := ssh.NewPublicKey(pubKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(block), pub, nil
+}
+
+func writeKeyPair(path string, private []byte, pub ssh.PublicKey, comment string) error {
+	_ = os.Remove(path)
+	if err := os.WriteFile(path, private, 0o600); err != nil {
+		return err
+	}
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))) + " " + comment + "\n"
+	return os.WriteFile(path+".pub", []byte(line), 0o644)
+}
+
+func printAuthorizeHint(pub ssh.PublicKey, comment string) {
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+	if comment != "" {
+		line += " " + comment
+	}
+	fmt.Println("authorize it on the server for tunnels only (no shell), in ~/.ssh/authorized_keys:")
+	fmt.Printf("  restrict,port-forwarding %s\n", line)
+}
+
+func runKeysShow(args []string) int {
+	fs := flag.NewFlagSet("keys show", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	name := fs.String("name", "", "show ~/.rpa/keys/<name> instead of ssh.identity_file")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	var path string
+	if *name != "" {
+		if !keyNamePattern.MatchString(*name) {
+			fmt.Fprintf(os.Stderr, "invalid key name %q\n", *name)
+			return exitUsage
+		}
+		dir, err := config.KeysDir()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return exitError
+		}
+		path = filepath.Join(dir, *name)
+	} else {
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+			return exitError
+		}
+		if strings.TrimSpace(cfg.SSH.IdentityFile) == "" {
+			fmt.Fprintln(os.Stderr, "ssh.identity_file is not set; ssh picks its default identities")
+			return exitError
+		}
+		path = expandTilde(cfg.SSH.IdentityFile)
+	}
+	pub, comment, err := readPublicKey(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitError
+	}
+	fmt.Printf("identity: %s\n", path)
+	fmt.Printf("type: %s\n", pub.Type())
+	fmt.Printf("fingerprint: %s\n", ssh.FingerprintSHA256(pub))
+	if info, err := os.Stat(path); err == nil {
+		fmt.Printf("mode: %04o\n", info.Mode().Perm())
+	}
+	fmt.Printf("public key: %s", ssh.MarshalAuthorizedKey(pub))
+	printAuthorizeHint(pub, comment)
+	return exitOK
+}
+
+// readPublicKey reads path.pub, falling back to the public half stored in
+// the private key file (available even when it is passphrase protected).
+func readPublicKey(path string) (ssh.PublicKey, string, error) {
+	if data, err := os.ReadFile(path + ".pub"); err == nil {
+		pub, comment, _, _, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, "", fmt.Errorf("parse %s.pub: %w", path, err)
+		}
+		return pub, comment, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("read identity: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err == nil {
+		return signer.PublicKey(), "", nil
+	}
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) && missing.PublicKey != nil {
+		return missing.PublicKey, "", nil
+	}
+	return nil, "", fmt.Errorf("parse %s: %w", path, err)
+}
+
+// checkIdentityUse warns when the tunnel identity is readable by others or
+// is one of ssh's default keys, which also grant interactive logins.
+func checkIdentityUse(cfg *config.Config) {
+	if strings.TrimSpace(cfg.SSH.IdentityFile) == "" {
+		return
+	}
+	path := expandTilde(cfg.SSH.IdentityFile)
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	ok := true
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		fmt.Fprintf(os.Stderr, "check identity permissions: WARN (mode %04o; ssh ignores private keys readable by others: chmod 600 %s)\n", perm, path)
+		ok = false
+	}
+	if isDefaultIdentity(path) {
+		fmt.Fprintf(os.Stderr, "check identity use: WARN (%s is a default ssh identity shared with interactive logins; run `rpa keys generate` for a dedicated key)\n", path)
+		ok = false
+	}
+	if ok {
+		fmt.Println("check identity use: OK")
+	}
+}
+
+func isDefaultIdentity(path string) bool {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return false
+	}
+	clean := filepath.Clean(path)
+	for _, name := range defaultIdentities {
+		if clean == filepath.Join(home, ".ssh", name) {
+			return true
+		}
+	}
+	return false
+}
+
+func printKeysUsage() {
+	fmt.Println("rpa keys")
+	fmt.Println("")
+	fmt.Println("Usage:")
+	fmt.Println("  rpa keys generate [--name rpa] [--comment text] [--force] [--config rpa.yaml]")
+	fmt.Println("  rpa keys show [--name rpa] [--config rpa.yaml]")
+	fmt.Println("")
+	fmt.Println("Notes:")
+	fmt.Println("  generate writes an ed25519 key to ~/.rpa/keys/<name> and sets ssh.identity_file")
+	fmt.Println("  show prints the configured identity's public key and fingerprint")
+}
@@ -0,0 +1,165 @@
+// Package cli implements `rpa agent route`, which edits HTTP proxy routes.
+// Changes are saved to config and applied to a running agent without a restart.
+
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/httpproxy"
+	ipcclient "reverse-proxy-agent/pkg/ipc/agent"
+)
+
+func runAgentRoute(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "missing route subcommand (add|remove|list)")
+		return exitUsage
+	}
+	switch args[0] {
+	case "add":
+		return runAgentRouteAdd(args[1:])
+	case "remove":
+		return runAgentRouteRemove(args[1:])
+	case "list":
+		return runAgentRouteList(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown route subcommand: %s\n", args[0])
+		return exitUsage
+	}
+}
+
+func runAgentRouteAdd(args []string) int {
+	fs := flag.NewFlagSet("agent route add", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	host := fs.String("host", "", "Host header to match, e.g. app.example.com or *.example.com (required)")
+	pathPrefix := fs.String("path-prefix", "", "optional path prefix, e.g. /api")
+	target := fs.String("target", "", "local target, e.g. 127.0.0.1:3000 or http://127.0.0.1:3000 (required)")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	rc := config.RouteConfig{
+		Host:       strings.TrimSpace(*host),
+		PathPrefix: strings.TrimSpace(*pathPrefix),
+		Target:     strings.TrimSpace(*target),
+	}
+	if err := config.ValidateRoute(rc); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid route: %v\n", err)
+		return exitUsage
+	}
+	if _, err := httpproxy.ParseTarget(rc.Target); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid route: %v\n", err)
+		return exitUsage
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		return exitError
+	}
+	if cfg.Agent.HTTPProxy.RemoteBind == "" {
+		fmt.Fprintln(os.Stderr, "agent.http_proxy.remote_bind is not set; configure it before adding routes")
+		return exitError
+	}
+	key := strings.ToLower(rc.Host) + rc.PathPrefix
+	routes := make([]config.RouteConfig, 0, len(cfg.Agent.HTTPProxy.Routes)+1)
+	for _, existing := range cfg.Agent.HTTPProxy.Routes {
+		if strings.ToLower(existing.Host)+existing.PathPrefix == key {
+			continue
+		}
+		routes = append(routes, existing)
+	}
+	cfg.Agent.HTTPProxy.Routes = append(routes, rc)
+	if err := config.Save(*configPath, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
+		return exitError
+	}
+
+	return applyRouteUpdate(func() (*ipcclient.Response, error) {
+		return ipcclient.AddRoute(cfg, rc.Host, rc.PathPrefix, rc.Target)
+	})
+}
+
+func runAgentRouteRemove(args []string) int {
+	fs := flag.NewFlagSet("agent route remove", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	host := fs.String("host", "", "Host of the route (required)")
+	pathPrefix := fs.String("path-prefix", "", "path prefix of the route")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if strings.TrimSpace(*host) == "" {
+		fmt.Fprintln(os.Stderr, "host is required")
+		return exitUsage
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		return exitError
+	}
+	key := strings.ToLower(strings.TrimSpace(*host)) + strings.TrimSpace(*pathPrefix)
+	routes := make([]config.RouteConfig, 0, len(cfg.Agent.HTTPProxy.Routes))
+	for _, existing := range cfg.Agent.HTTPProxy.Routes {
+		if strings.ToLower(existing.Host)+existing.PathPrefix == key {
+			continue
+		}
+		routes = append(routes, existing)
+	}
+	cfg.Agent.HTTPProxy.Routes = routes
+	if err := config.Save(*configPath, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
+		return exitError
+	}
+
+	return applyRouteUpdate(func() (*ipcclient.Response, error) {
+		return ipcclient.RemoveRoute(cfg, strings.TrimSpace(*host), strings.TrimSpace(*pathPrefix))
+	})
+}
+
+func runAgentRouteList(args []string) int {
+	fs := flag.NewFlagSet("agent route list", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		return exitError
+	}
+	if forward := config.HTTPProxyForward(cfg); forward != "" {
+		fmt.Printf("http_proxy: %s\n", forward)
+	} else {
+		fmt.Println("http_proxy: (disabled)")
+	}
+	for _, rc := range cfg.Agent.HTTPProxy.Routes {
+		fmt.Printf("  %s%s -> %s\n", rc.Host, rc.PathPrefix, rc.Target)
+	}
+	return exitOK
+}
+
+// applyRouteUpdate pushes a saved route change to a running agent. Routes do
+// not need a restart, so an agent that is not running is left alone.
+func applyRouteUpdate(fn func() (*ipcclient.Response, error)) int {
+	resp, err := fn()
+	if err != nil {
+		if isNotRunning(err) {
+			fmt.Println("route saved; agent not running")
+			return exitOK
+		}
+		fmt.Fprintf(os.Stderr, "agent update failed: %v\n", err)
+		return exitError
+	}
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "agent update error: %s\n", resp.Message)
+		return exitError
+	}
+	if resp.Message != "" {
+		fmt.Println(resp.Message)
+	}
+	return exitOK
+}
@@ -0,0 +1,278 @@
+// Package cli implements `rpa share`, which exposes a local port on a server port taken from agent.port_pool.
+// Shares are pooled remote forward entries; stopping or expiring one releases its port.
+
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/forward"
+	ipcclient "reverse-proxy-agent/pkg/ipc/agent"
+)
+
+func runShare(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "help", "-h", "--help":
+			printShareUsage()
+			return exitOK
+		case "list":
+			return runShareList(args[1:])
+		case "stop":
+			return runShareStop(args[1:])
+		}
+	}
+	return runShareAdd(args)
+}
+
+func runShareAdd(args []string) int {
+	target := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		target = args[0]
+		args = args[1:]
+	}
+	fs := flag.NewFlagSet("share", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	bind := fs.String("bind", "", "server bind address, e.g. 0.0.0.0 (default: sshd's GatewayPorts)")
+	meta := addEntryFlags(fs)
+	ttl := fs.Duration("ttl", 0, "stop sharing after this long, e.g. 2h")
+	until := fs.String("until", "", "stop sharing at this time (RFC3339 or \"2006-01-02 15:04\" local)")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if target == "" && fs.NArg() > 0 {
+		target = fs.Arg(0)
+	}
+	if strings.TrimSpace(target) == "" {
+		fmt.Fprintln(os.Stderr, "local port is required")
+		printShareUsage()
+		return exitUsage
+	}
+	targetSpec, localPort, err := shareTarget(target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitUsage
+	}
+	expiresAt, err := parseExpiry(*ttl, *until, time.Now())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitUsage
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		return exitError
+	}
+	port, err := config.AllocatePoolPort(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitError
+	}
+
+	spec := fmt.Sprintf("%d:%s", port, targetSpec)
+	if host := strings.Trim(strings.TrimSpace(*bind), "[]"); host != "" {
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		spec = host + ":" + spec
+	}
+	if _, err := forward.Parse(spec); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitUsage
+	}
+	entry := meta.entry(spec)
+	if entry.Name == "" {
+		entry.Name = fmt.Sprintf("share-%d", port)
+	}
+	entry.Pooled = true
+	entry.ExpiresAt = expiresAt
+	if entry.CreatedAt == nil {
+		now := time.Now().UTC().Truncate(time.Second)
+		entry.CreatedAt = &now
+	}
+	if err := checkForwardName(cfg.SSH.RemoteForwards, entry); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitUsage
+	}
+	cfg.SSH.RemoteForwards = config.UpsertForwardEntry(cfg.SSH.RemoteForwards, entry)
+	if err := config.ValidateAgent(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "config validation failed: %v\n", err)
+		return exitError
+	}
+	if err := config.Save(*configPath, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
+		return exitError
+	}
+
+	if resp, ok, notRunning := tryRuntimeUpdate(func() (*ipcclient.Response, error) {
+		return ipcclient.AddRemoteForward(cfg, entry)
+	}); ok {
+		if resp.Message != "" {
+			fmt.Println(resp.Message)
+		}
+	} else if notRunning {
+		if runAgentUp([]string{"--config", *configPath}) != exitOK {
+			return exitError
+		}
+	} else {
+		return exitError
+	}
+	printShareInstructions(cfg, entry, port, localPort)
+	return exitOK
+}
+
+// shareTarget accepts a local port or host:port and returns the target part
+// of the spec and the port a recipient would use locally.
+func shareTarget(value string) (string, int, error) {
+	value = strings.TrimSpace(value)
+	if port, err := strconv.Atoi(value); err == nil {
+		if port < 1 || port > 65535 {
+			return "", 0, fmt.Errorf("invalid local port %q", value)
+		}
+		return "localhost:" + value, port, nil
+	}
+	host, portText, err := net.SplitHostPort(value)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid share target %q: use <port> or <host:port>", value)
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid share target %q: bad port", value)
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return host + ":" + portText, port, nil
+}
+
+func printShareInstructions(cfg *config.Config, entry config.ForwardEntry, port, localPort int) {
+	login := cfg.SSH.Host
+	if cfg.SSH.User != "" {
+		login = cfg.SSH.User + "@" + cfg.SSH.Host
+	}
+	sshPort := ""
+	if cfg.SSH.Port != 0 && cfg.SSH.Port != 22 {
+		sshPort = fmt.Sprintf(" -p %d", cfg.SSH.Port)
+	}
+	fmt.Printf("shared %s as %s\n", entry.Spec, entry.Label())
+	fmt.Printf("  server: %s port %d\n", cfg.SSH.Host, port)
+	if entry.ExpiresAt != nil {
+		fmt.Printf("  expires: %s\n", entry.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	fmt.Println("recipient:")
+	fmt.Printf("  ssh -N -L %d:localhost:%d%s %s\n", localPort, port, sshPort, login)
+	fmt.Printf("  then open localhost:%d (e.g. http://localhost:%d/)\n", localPort, localPort)
+	if f, err := forward.Parse(entry.Spec); err == nil && f.Bind.Host != "" && !f.IsLoopback() {
+		fmt.Printf("  or directly http://%s/ if sshd allows GatewayPorts\n", net.JoinHostPort(cfg.SSH.Host, strconv.Itoa(port)))
+	}
+	fmt.Printf("stop with: rpa share stop %s\n", entry.Label())
+}
+
+func runShareList(args []string) int {
+	fs := flag.NewFlagSet("share list", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		return exitError
+	}
+	if used, size, err := config.PoolUsage(cfg); err == nil {
+		fmt.Printf("port_pool: %s used=%d free=%d\n", strings.TrimSpace(cfg.Agent.PortPool), used, size-used)
+	}
+	shares := config.PooledForwards(cfg)
+	if len(shares) == 0 {
+		fmt.Println("no shares")
+		return exitOK
+	}
+	for _, e := range shares {
+		line := fmt.Sprintf("%s %s", e.Label(), e.Spec)
+		if e.Owner != "" {
+			line += " owner=" + e.Owner
+		}
+		if e.ExpiresAt != nil {
+			line += " expires=" + e.ExpiresAt.UTC().Format(time.RFC3339)
+		}
+		if !e.IsEnabled() {
+			line += " (disabled)"
+		}
+		fmt.Println(line)
+	}
+	return exitOK
+}
+
+// runShareStop removes a share by name, spec or server port, which releases
+// the port back to the pool.
+func runShareStop(args []string) int {
+	ref := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		ref = args[0]
+		args = args[1:]
+	}
+	fs := flag.NewFlagSet("share stop", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if ref == "" && fs.NArg() > 0 {
+		ref = fs.Arg(0)
+	}
+	if strings.TrimSpace(ref) == "" {
+		fmt.Fprintln(os.Stderr, "share name, spec or port is required")
+		return exitUsage
+	}
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		return exitError
+	}
+	shares := config.PooledForwards(cfg)
+	i := config.FindForwardEntry(shares, ref)
+	if port, err := strconv.Atoi(strings.TrimSpace(ref)); i < 0 && err == nil {
+		for k, e := range shares {
+			if f, err := forward.Parse(e.Spec); err == nil && f.Bind.Port == port {
+				i = k
+				break
+			}
+		}
+	}
+	if i < 0 {
+		fmt.Fprintf(os.Stderr, "share not found: %s\n", ref)
+		return exitError
+	}
+	if !shares[i].IsEnabled() {
+		// Disabled shares are not running, so only the config entry goes.
+		k := config.FindForwardEntry(cfg.SSH.RemoteForwards, shares[i].Spec)
+		cfg.SSH.RemoteForwards = append(cfg.SSH.RemoteForwards[:k], cfg.SSH.RemoteForwards[k+1:]...)
+		if err := config.Save(*configPath, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
+			return exitError
+		}
+		fmt.Printf("share %s removed\n", shares[i].Label())
+		return exitOK
+	}
+	return runAgentRemove([]string{"--config", *configPath, "--remote-forward", shares[i].Spec})
+}
+
+func printShareUsage() {
+	fmt.Println("rpa share")
+	fmt.Println("")
+	fmt.Println("Usage:")
+	fmt.Println("  rpa share <port|host:port> [--bind 0.0.0.0] [--ttl 2h|--until time] [--name n] [--owner who] [--description text] [--config rpa.yaml]")
+	fmt.Println("  rpa share list [--config rpa.yaml]")
+	fmt.Println("  rpa share stop <name|port> [--config rpa.yaml]")
+	fmt.Println("")
+	fmt.Println("Notes:")
+	fmt.Println("  the server port is the lowest free port in agent.port_pool (e.g. 20000-20999)")
+	fmt.Println("  shares are remote forwards marked pooled: true; removing or expiring one frees its port")
+}
@@ -0,0 +1,380 @@
+// Package cli implements `rpa server-snippet`, which prints least-privilege server settings for the tunnel key.
+// The authorized_keys line and sshd Match block allow exactly the configured forwards; doctor reports drift from a saved copy.
+
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/forward"
+)
+
+// maxListedPoolPorts bounds how many agent.port_pool ports are listed one by
+// one; larger pools fall back to a port wildcard.
+const maxListedPoolPorts = 256
+
+type serverSnippet struct {
+	listens []string
+	opens   []string
+	anyOpen bool
+	sockets bool
+	gateway bool
+	notes   []string
+}
+
+func runServerSnippet(args []string) int {
+	mode := "agent"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		mode = args[0]
+		args = args[1:]
+	}
+	if mode == "help" {
+		printServerSnippetUsage()
+		return exitOK
+	}
+	if mode != "agent" && mode != "client" {
+		fmt.Fprintf(os.Stderr, "unknown mode: %s (use agent or client)\n", mode)
+		return exitUsage
+	}
+	fs := flag.NewFlagSet("server-snippet", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	save := fs.Bool("save", false, "also save the snippet so doctor can report drift")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		return exitError
+	}
+	text := renderServerSnippet(cfg, mode)
+	fmt.Print(text)
+	if *save {
+		path, err := config.ServerSnippetPath(mode)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return exitError
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			fmt.Fprintf(os.Stderr, "save failed: %v\n", err)
+			return exitError
+		}
+		if err := os.WriteFile(path, []byte(text), 0o600); err != nil {
+			fmt.Fprintf(os.Stderr, "save failed: %v\n", err)
+			return exitError
+		}
+		fmt.Fprintf(os.Stderr, "saved to %s\n", path)
+	}
+	return exitOK
+}
+
+func buildServerSnippet(cfg *config.Config, mode string) serverSnippet {
+	var s serverSnippet
+	if mode == "client" {
+		for _, spec := range config.NormalizeLocalForwards(cfg) {
+			f, err := forward.Parse(spec)
+			if err != nil {
+				continue
+			}
+			if f.Target.IsSocket() {
+				s.sockets = true
+				s.notes = append(s.notes, fmt.Sprintf("%s opens a server socket; permitopen cannot limit sockets", spec))
+				continue
+			}
+			s.opens = append(s.opens, net.JoinHostPort(f.Target.Host, strconv.Itoa(f.Target.Port)))
+		}
+		if len(config.NormalizeDynamicForwards(cfg)) > 0 {
+			s.anyOpen = true
+			s.notes = append(s.notes, "dynamic forwards (SOCKS) reach arbitrary destinations, so opens are not limited")
+		}
+		return s
+	}
+
+	// Listed pool ports already cover the pooled forwards using them.
+	poolLo, poolHi := 0, -1
+	if pool := strings.TrimSpace(cfg.Agent.PortPool); pool != "" {
+		if lo, hi, err := forward.ParsePortRange(pool); err == nil {
+			if hi-lo+1 <= maxListedPoolPorts {
+				poolLo, poolHi = lo, hi
+				for port := lo; port <= hi; port++ {
+					s.listens = append(s.listens, strconv.Itoa(port))
+				}
+			} else {
+				s.listens = append(s.listens, "*")
+				s.notes = append(s.notes, fmt.Sprintf("agent.port_pool %s has more than %d ports, so any port is allowed", pool, maxListedPoolPorts))
+			}
+		}
+	}
+	specs := config.NormalizeRemoteForwards(cfg)
+	if proxy := config.HTTPProxyForward(cfg); proxy != "" {
+		specs = append(specs, proxy)
+	}
+	for _, spec := range specs {
+		f, err := forward.Parse(spec)
+		if err != nil {
+			continue
+		}
+		if f.Bind.IsSocket() {
+			s.sockets = true
+			s.notes = append(s.notes, fmt.Sprintf("%s binds a server socket; permitlisten cannot limit sockets", spec))
+			continue
+		}
+		if f.Bind.Host != "" && !f.IsLoopback() {
+			s.gateway = true
+		}
+		switch {
+		case f.Bind.Port >= poolLo && f.Bind.Port <= poolHi:
+		case f.Bind.Port == 0:
+			s.listens = append(s.listens, listenPattern(f.Bind.Host, "*"))
+			s.notes = append(s.notes, fmt.Sprintf("%s lets the server pick a port, so any port is allowed on that bind", spec))
+		default:
+			s.listens = append(s.listens, listenPattern(f.Bind.Host, strconv.Itoa(f.Bind.Port)))
+		}
+		// Probes reach loopback binds with `ssh -W`, which is a local open.
+		if f.IsLoopback() && hasProbe(cfg.Agent.Probes, spec) {
+			s.opens = append(s.opens, net.JoinHostPort("127.0.0.1", strconv.Itoa(f.Bind.Port)))
+		}
+	}
+	return s
+}
+
+func listenPattern(host, port string) string {
+	if host == "" {
+		return port
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+func hasProbe(probes []config.ProbeConfig, spec string) bool {
+	for _, pc := range probes {
+		if config.ForwardKey(pc.Forward) == config.ForwardKey(spec) && strings.TrimSpace(pc.Address) == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// authorizedKeysOptions restricts a key to port forwarding within s.
+func authorizedKeysOptions(s serverSnippet) []string {
+	options := []string{"restrict", "port-forwarding"}
+	for _, l := range sortedUnique(s.listens) {
+		options = append(options, fmt.Sprintf("permitlisten=%q", l))
+	}
+	if !s.anyOpen {
+		for _, o := range sortedUnique(s.opens) {
+			options = append(options, fmt.Sprintf("permitopen=%q", o))
+		}
+	}
+	return options
+}
+
+// renderServerSnippet prints the authorized_keys line and the sshd_config
+// Match block. The output is stable for a given config so saved copies can be
+// compared.
+func renderServerSnippet(cfg *config.Config, mode string) string {
+	s := buildServerSnippet(cfg, mode)
+	listens := sortedUnique(s.listens)
+	opens := sortedUnique(s.opens)
+	if !s.anyOpen && len(opens) == 0 {
+		// authorized_keys has no option that denies every open, and
+		// port-forwarding without permitopen allows all of them.
+		s.notes = append(s.notes, "the key line alone allows -L/-W to any host; only the Match block's PermitOpen none blocks them, so install both")
+	}
+
+	options := authorizedKeysOptions(s)
+	key := "<public key of ssh.identity_file>"
+	if cfg.SSH.IdentityFile != "" {
+		if pub, comment, err := readPublicKey(expandTilde(cfg.SSH.IdentityFile)); err == nil {
+			key = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+			if comment != "" {
+				key += " " + comment
+			}
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# rpa %s server settings for %s@%s\n", mode, cfg.SSH.User, cfg.SSH.Host)
+	for _, note := range s.notes {
+		fmt.Fprintf(&b, "# note: %s\n", note)
+	}
+	fmt.Fprintf(&b, "\n# ~%s/.ssh/authorized_keys\n", cfg.SSH.User)
+	fmt.Fprintf(&b, "%s %s\n", strings.Join(options, ","), key)
+
+	fmt.Fprintln(&b, "\n# /etc/ssh/sshd_config (use a dedicated account: ForceCommand blocks its shell)")
+	if admin := cfg.SSH.GrantAdmin; admin != nil {
+		fmt.Fprintf(&b, "# rpa grant logs in as %s (ssh.grant_admin) to edit this account's authorized_keys\n", admin.User)
+	} else {
+		fmt.Fprintln(&b, "# rpa grant needs a shell, which ForceCommand blocks: set ssh.grant_admin to a separate")
+		fmt.Fprintln(&b, "# unrestricted account (root or passwordless sudo) to manage grants for this account")
+	}
+	fmt.Fprintf(&b, "Match User %s\n", cfg.SSH.User)
+	if mode == "client" {
+		fmt.Fprintln(&b, "    AllowTcpForwarding local")
+		fmt.Fprintln(&b, "    PermitListen none")
+		switch {
+		case s.anyOpen:
+			fmt.Fprintln(&b, "    PermitOpen any")
+		case len(opens) == 0:
+			fmt.Fprintln(&b, "    PermitOpen none")
+		default:
+			fmt.Fprintf(&b, "    PermitOpen %s\n", strings.Join(opens, " "))
+		}
+		fmt.Fprintln(&b, "    GatewayPorts no")
+		if s.sockets {
+			fmt.Fprintln(&b, "    AllowStreamLocalForwarding local")
+		} else {
+			fmt.Fprintln(&b, "    AllowStreamLocalForwarding no")
+		}
+	} else {
+		if len(opens) > 0 {
+			fmt.Fprintln(&b, "    AllowTcpForwarding yes")
+		} else {
+			fmt.Fprintln(&b, "    AllowTcpForwarding remote")
+		}
+		if len(listens) == 0 {
+			fmt.Fprintln(&b, "    PermitListen none")
+		} else {
+			fmt.Fprintf(&b, "    PermitListen %s\n", strings.Join(listens, " "))
+		}
+		if len(opens) == 0 {
+			fmt.Fprintln(&b, "    PermitOpen none")
+		} else {
+			fmt.Fprintf(&b, "    PermitOpen %s\n", strings.Join(opens, " "))
+		}
+		if s.gateway {
+			fmt.Fprintln(&b, "    GatewayPorts clientspecified")
+		} else {
+			fmt.Fprintln(&b, "    GatewayPorts no")
+		}
+		if s.sockets {
+			fmt.Fprintln(&b, "    AllowStreamLocalForwarding remote")
+			fmt.Fprintln(&b, "    StreamLocalBindUnlink yes")
+		} else {
+			fmt.Fprintln(&b, "    AllowStreamLocalForwarding no")
+		}
+	}
+	fmt.Fprintln(&b, "    PermitTTY no")
+	fmt.Fprintln(&b, "    X11Forwarding no")
+	fmt.Fprintln(&b, "    AllowAgentForwarding no")
+	fmt.Fprintln(&b, "    PermitTunnel no")
+	fmt.Fprintln(&b, "    ForceCommand /bin/false")
+	return b.String()
+}
+
+// checkSnippetDrift compares the snippet saved by `rpa server-snippet --save`
+// with one rendered from the current config. It only warns: the server may
+// have been updated by hand.
+func checkSnippetDrift(cfg *config.Config, mode string) {
+	path, err := config.ServerSnippetPath(mode)
+	if err != nil {
+		return
+	}
+	saved, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "check server snippet: WARN (%v)\n", err)
+		return
+	}
+	current := renderServerSnippet(cfg, mode)
+	removed, added := diffLines(string(saved), current)
+	if len(removed) == 0 && len(added) == 0 {
+		// Only comments differ, e.g. a snippet saved by an older rpa.
+		fmt.Println("check server snippet: OK")
+		return
+	}
+	fmt.Fprintf(os.Stderr, "check server snippet: WARN (forwards changed since it was saved; update the server and run `rpa server-snippet %s --save`)\n", mode)
+	for _, line := range removed {
+		fmt.Fprintf(os.Stderr, "  - %s\n", line)
+	}
+	for _, line := range added {
+		fmt.Fprintf(os.Stderr, "  + %s\n", line)
+	}
+}
+
+// diffLines returns the settings only in a and only in b. The
+// authorized_keys line is split into its options so a single changed
+// permitlisten shows as such.
+func diffLines(a, b string) ([]string, []string) {
+	setA, setB := snippetItems(a), snippetItems(b)
+	var removed, added []string
+	for _, item := range setA {
+		if !containsString(setB, item) {
+			removed = append(removed, item)
+		}
+	}
+	for _, item := range setB {
+		if !containsString(setA, item) {
+			added = append(added, item)
+		}
+	}
+	return removed, added
+}
+
+func snippetItems(text string) []string {
+	var items []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "restrict,") {
+			opts, key, _ := strings.Cut(line, " ")
+			items = append(items, strings.Split(opts, ",")...)
+			items = append(items, "key "+key)
+			continue
+		}
+		// Multi-valued sshd settings compare value by value.
+		if key, values, ok := strings.Cut(line, " "); ok && (key == "PermitListen" || key == "PermitOpen") {
+			for _, v := range strings.Fields(values) {
+				items = append(items, key+" "+v)
+			}
+			continue
+		}
+		items = append(items, line)
+	}
+	return items
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedUnique(values []string) []string {
+	var out []string
+	for _, v := range values {
+		if !containsString(out, v) {
+			out = append(out, v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func printServerSnippetUsage() {
+	fmt.Println("rpa server-snippet")
+	fmt.Println("")
+	fmt.Println("Usage:")
+	fmt.Println("  rpa server-snippet [agent|client] [--save] [--config rpa.yaml]")
+	fmt.Println("")
+	fmt.Println("Notes:")
+	fmt.Println("  prints an authorized_keys line and an sshd_config Match block limited to the configured forwards")
+	fmt.Println("  --save keeps a copy in ~/.rpa so doctor can warn when the forwards drift from it")
+}
@@ -0,0 +1,132 @@
+// Package cli implements `rpa client socks-user`, which manages SOCKS credentials for dynamic forwards.
+// Only bcrypt hashes are stored in config.
+
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"reverse-proxy-agent/pkg/config"
+)
+
+func runClientSOCKSUser(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "missing socks-user subcommand (add|remove|list)")
+		return exitUsage
+	}
+	switch args[0] {
+	case "add":
+		return runClientSOCKSUserAdd(args[1:])
+	case "remove":
+		return runClientSOCKSUserRemove(args[1:])
+	case "list":
+		return runClientSOCKSUserList(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown socks-user subcommand: %s\n", args[0])
+		return exitUsage
+	}
+}
+
+func runClientSOCKSUserAdd(args []string) int {
+	fs := flag.NewFlagSet("client socks-user add", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	user := fs.String("user", "", "username (required)")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from stdin")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	name := strings.TrimSpace(*user)
+	if name == "" {
+		fmt.Fprintln(os.Stderr, "user is required")
+		return exitUsage
+	}
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		return exitError
+	}
+	password, err := readPassword(*passwordStdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read password failed: %v\n", err)
+		return exitError
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hash password failed: %v\n", err)
+		return exitError
+	}
+	next := make([]config.SOCKSUser, 0, len(cfg.Client.SOCKSUsers)+1)
+	for _, existing := range cfg.Client.SOCKSUsers {
+		if existing.Username != name {
+			next = append(next, existing)
+		}
+	}
+	cfg.Client.SOCKSUsers = append(next, config.SOCKSUser{Username: name, PasswordHash: string(hash)})
+	if err := config.Save(*configPath, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
+		return exitError
+	}
+	fmt.Printf("socks user %s saved; restart the client to apply\n", name)
+	return exitOK
+}
+
+func runClientSOCKSUserRemove(args []string) int {
+	fs := flag.NewFlagSet("client socks-user remove", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	user := fs.String("user", "", "username (required)")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	name := strings.TrimSpace(*user)
+	if name == "" {
+		fmt.Fprintln(os.Stderr, "user is required")
+		return exitUsage
+	}
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		return exitError
+	}
+	next := make([]config.SOCKSUser, 0, len(cfg.Client.SOCKSUsers))
+	for _, existing := range cfg.Client.SOCKSUsers {
+		if existing.Username != name {
+			next = append(next, existing)
+		}
+	}
+	if len(next) == len(cfg.Client.SOCKSUsers) {
+		fmt.Println("socks user not found")
+		return exitOK
+	}
+	cfg.Client.SOCKSUsers = next
+	if err := config.Save(*configPath, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
+		return exitError
+	}
+	fmt.Printf("socks user %s removed; restart the client to apply\n", name)
+	if len(next) == 0 {
+		fmt.Println("warning: non-loopback dynamic forwards will no longer require authentication")
+	}
+	return exitOK
+}
+
+func runClientSOCKSUserList(args []string) int {
+	fs := flag.NewFlagSet("client socks-user list", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		return exitError
+	}
+	for _, u := range cfg.Client.SOCKSUsers {
+		fmt.Println(u.Username)
+	}
+	return exitOK
+}
@@ -0,0 +1,95 @@
+// Package cli reports where the effective ssh settings come from when ssh.config_alias is set.
+// Values left empty in rpa config are read from ~/.ssh/config; the rest of the Host block is applied by ssh itself.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/sshconfig"
+)
+
+// printSSHAlias lists the effective settings for ssh.config_alias with their
+// sources and returns false when ssh_config cannot be read.
+func printSSHAlias(cfg *config.Config) bool {
+	alias := strings.TrimSpace(cfg.SSH.ConfigAlias)
+	if alias == "" {
+		return true
+	}
+	settings, err := sshconfig.Resolve(alias, sshconfig.DefaultFiles()...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "check ssh config alias %s: FAIL (%v)\n", alias, err)
+		return false
+	}
+	fmt.Printf("ssh config alias %s:\n", alias)
+	port, portValue, _ := settings.Port()
+	identity := sshconfig.Value{Source: "default"}
+	if files := settings.IdentityFiles(); len(files) > 0 {
+		identity = files[0]
+	}
+	jump, _ := settings.Get("proxyjump")
+	fields := []struct {
+		key, field, value string
+		fromSSH           sshconfig.Value
+	}{
+		{"hostname", "host", cfg.SSH.Host, settings.HostName()},
+		{"user", "user", cfg.SSH.User, settings.User()},
+		{"port", "port", strconv.Itoa(cfg.SSH.Port), sshconfig.Value{Value: strconv.Itoa(port), Source: portValue.Source}},
+		{"identityfile", "identity_file", cfg.SSH.IdentityFile, identity},
+		{"proxyjump", "jump_hosts", config.JumpChain(cfg.SSH.JumpHosts), jump},
+	}
+	for _, f := range fields {
+		switch {
+		case f.value == "":
+			continue
+		case config.FromSSHConfig(cfg, f.field):
+			fmt.Printf("  %s: %s (%s)\n", f.key, f.value, f.fromSSH.Source)
+		case f.fromSSH.Value != "" && f.fromSSH.Value != f.value && f.fromSSH.Source != "default" && f.fromSSH.Source != "alias":
+			fmt.Printf("  %s: %s (rpa ssh.%s, overrides %s at %s)\n", f.key, f.value, f.field, f.fromSSH.Value, f.fromSSH.Source)
+		default:
+			fmt.Printf("  %s: %s (rpa ssh.%s)\n", f.key, f.value, f.field)
+		}
+	}
+	// Command-line -o options win over ssh_config.
+	options := make(map[string]string)
+	var optionKeys []string
+	for _, opt := range cfg.SSH.Options {
+		key, value, ok := strings.Cut(strings.TrimSpace(opt), "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if _, seen := options[key]; !seen {
+			optionKeys = append(optionKeys, key)
+			options[key] = strings.TrimSpace(value)
+		}
+	}
+	for _, key := range settings.Keys() {
+		switch key {
+		case "hostname", "user", "port", "identityfile", "proxyjump":
+			continue
+		}
+		if value, ok := options[key]; ok {
+			v, _ := settings.Get(key)
+			fmt.Printf("  %s: %s (rpa ssh.options, overrides %s at %s)\n", key, value, v.Value, v.Source)
+			delete(options, key)
+			continue
+		}
+		for _, v := range settings.All(key) {
+			fmt.Printf("  %s: %s (%s)\n", key, v.Value, v.Source)
+		}
+	}
+	for _, key := range optionKeys {
+		if value, ok := options[key]; ok {
+			fmt.Printf("  %s: %s (rpa ssh.options)\n", key, value)
+		}
+	}
+	for _, warning := range settings.Warnings {
+		fmt.Fprintf(os.Stderr, "check ssh config alias %s: WARN (%s)\n", alias, warning)
+	}
+	return true
+}
@@ -4,9 +4,10 @@
 package client
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"net"
-	"os/exec"
 	"strconv"
 	"strings"
 	"sync"
@@ -15,8 +16,12 @@ import (
 	"reverse-proxy-agent/internal/supervisor"
 	"reverse-proxy-agent/pkg/buildinfo"
 	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/conntrack"
+	"reverse-proxy-agent/pkg/forward"
 	"reverse-proxy-agent/pkg/logging"
 	"reverse-proxy-agent/pkg/monitor"
+	"reverse-proxy-agent/pkg/probe"
+	"reverse-proxy-agent/pkg/relay"
 	"reverse-proxy-agent/pkg/restart"
 	"reverse-proxy-agent/pkg/state"
 	"reverse-proxy-agent/pkg/statefile"
@@ -27,6 +32,13 @@ type Client struct {
 	runner *supervisor.Runner
 
 	localMu sync.Mutex
+
+	listenerMu sync.Mutex
+	listeners  map[string]*relay.Listener
+	fronts     map[string]*relay.Listener
+	socksInner map[string]string
+	stats      map[string]*relay.Stats
+	logger     *logging.Logger
 }
 
 func New(cfg *config.Config) *Client {
@@ -40,16 +52,20 @@ func New(cfg *config.Config) *Client {
 			_ = statefile.Write(path, snap)
 		})
 	}
-	return &Client{
-		cfg:    cfg,
-		runner: runner,
+	c := &Client{
+		cfg:        cfg,
+		runner:     runner,
+		listeners:  make(map[string]*relay.Listener),
+		fronts:     make(map[string]*relay.Listener),
+		socksInner: make(map[string]string),
+		stats:      make(map[string]*relay.Stats),
 	}
+	runner.SetStderrHook(c.dropInnerSOCKSPort)
+	return c
 }
 
 func (c *Client) Start() error {
-	return c.runner.Start(func() (*exec.Cmd, error) {
-		return buildSSHCommand(c.cfg, c.currentLocalForwards())
-	})
+	return c.runner.Start(c.buildCommand)
 }
 
 func (c *Client) Stop() error {
@@ -70,10 +86,17 @@ func (c *Client) ConfigSummary() string {
 	if c.cfg.SSH.User != "" {
 		host = fmt.Sprintf("%s@%s", c.cfg.SSH.User, c.cfg.SSH.Host)
 	}
+	via := ""
+	if len(c.cfg.SSH.JumpHosts) > 0 {
+		via = " via " + config.JumpChain(c.cfg.SSH.JumpHosts)
+	}
 	if forward == "" {
-		return fmt.Sprintf("%s:%d", host, c.cfg.SSH.Port)
+		if dynamic := config.NormalizeDynamicForwards(c.cfg); len(dynamic) > 0 {
+			return fmt.Sprintf("%s:%d%s (dynamic=%s)", host, c.cfg.SSH.Port, via, dynamic[0])
+		}
+		return fmt.Sprintf("%s:%d%s", host, c.cfg.SSH.Port, via)
 	}
-	return fmt.Sprintf("%s:%d (local=%s)", host, c.cfg.SSH.Port, forward)
+	return fmt.Sprintf("%s:%d%s (local=%s)", host, c.cfg.SSH.Port, via, forward)
 }
 
 func (c *Client) RunWithLogger(logger *logging.Logger) error {
@@ -86,14 +109,92 @@ func (c *Client) RunWithLogger(logger *logging.Logger) error {
 			SleepGapSec:    c.cfg.Client.SleepGapSec,
 			NetworkPollSec: c.cfg.Client.NetworkPollSec,
 		},
-		PeriodicRestartSec: c.cfg.Client.PeriodicRestartSec,
-		DebounceMs:         c.cfg.Client.Restart.DebounceMs,
-		TCPCheckSec:        c.cfg.SSH.CheckSec,
-		TCPCheckAddr:       net.JoinHostPort(c.cfg.SSH.Host, strconv.Itoa(c.cfg.SSH.Port)),
+		PeriodicRestartSec:  c.cfg.Client.PeriodicRestartSec,
+		DebounceMs:          c.cfg.Client.Restart.DebounceMs,
+		TCPCheckSec:         c.cfg.SSH.CheckSec,
+		TCPCheckAddr:        config.SSHDialAddr(c.cfg),
+		Probes:              c.buildProbes(),
+		PeriodicJitterSec:   c.cfg.Client.PeriodicRestartJitterSec,
+		PeriodicMaxDeferSec: c.cfg.Client.PeriodicRestartMaxDeferSec,
+		QuietHours:          config.QuietHoursFunc(c.cfg.Client.PeriodicRestartQuietHours),
+		ActiveConnections:   c.activeConnections,
+	}
+	c.setLogger(logger)
+	c.syncListeners()
+	c.syncFronts()
+	defer c.stopListeners()
+	return c.runner.RunWithLogger(logger, c.buildCommand, opts)
+}
+
+func (c *Client) ProbeStatuses() []supervisor.ProbeStatus {
+	return c.runner.ProbeStatuses()
+}
+
+func (c *Client) buildProbes() []*probe.Probe {
+	enabled := c.currentLocalForwards()
+	out := make([]*probe.Probe, 0, len(c.cfg.Client.Probes))
+	for _, pc := range c.cfg.Client.Probes {
+		if !containsForward(enabled, pc.Forward) {
+			continue
+		}
+		out = append(out, probe.New(pc, probeDialer(pc)))
+	}
+	return out
+}
+
+func containsForward(forwards []string, value string) bool {
+	for _, f := range forwards {
+		if f == strings.TrimSpace(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// probeDialer connects to the local bind of a forward so the probe traverses
+// the whole tunnel to the remote target.
+func probeDialer(pc config.ProbeConfig) probe.DialFunc {
+	if strings.TrimSpace(pc.Address) != "" {
+		return probe.TCPDialer(pc.Address)
+	}
+	f, err := forward.Parse(pc.Forward)
+	if err != nil {
+		return func(context.Context) (io.ReadWriteCloser, error) {
+			return nil, err
+		}
+	}
+	if f.Bind.IsSocket() {
+		path := f.Bind.Socket
+		return func(ctx context.Context) (io.ReadWriteCloser, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+	}
+	host := f.Bind.Host
+	switch strings.ToLower(host) {
+	case "", "*", "0.0.0.0", "localhost":
+		host = "127.0.0.1"
+	case "::":
+		host = "::1"
+	}
+	return probe.TCPDialer(net.JoinHostPort(host, strconv.Itoa(f.Bind.Port)))
+}
+
+func (c *Client) PeriodicStatus() supervisor.PeriodicStatus {
+	return c.runner.PeriodicStatus()
+}
+
+// activeConnections counts established connections accepted on local bind ports.
+func (c *Client) activeConnections() (int, error) {
+	var filter conntrack.Filter
+	for _, value := range c.currentLocalForwards() {
+		f, err := forward.Parse(value)
+		if err != nil || f.Bind.IsSocket() {
+			continue
+		}
+		filter.LocalPorts = append(filter.LocalPorts, f.Bind.Port)
 	}
-	return c.runner.RunWithLogger(logger, func() (*exec.Cmd, error) {
-		return buildSSHCommand(c.cfg, c.currentLocalForwards())
-	}, opts)
+	return conntrack.Established(filter)
 }
 
 func (c *Client) RequestStop() {
@@ -158,28 +259,43 @@ func (c *Client) LocalForwards() []string {
 	return c.currentLocalForwards()
 }
 
-func (c *Client) SetLocalForwards(forwards []string) {
+// ForwardLabel returns the configured name of a local forward, or its spec.
+func (c *Client) ForwardLabel(spec string) string {
+	c.localMu.Lock()
+	defer c.localMu.Unlock()
+	return config.ForwardLabel(c.cfg.Client.LocalForwards, spec)
+}
+
+// DisabledForwards returns the labels of local forwards kept in config but not forwarded.
+func (c *Client) DisabledForwards() []string {
 	c.localMu.Lock()
 	defer c.localMu.Unlock()
+	var out []string
+	for _, e := range c.cfg.Client.LocalForwards {
+		if !e.IsEnabled() {
+			out = append(out, e.Label())
+		}
+	}
+	return out
+}
+
+func (c *Client) SetLocalForwards(forwards []string) {
+	c.localMu.Lock()
 	config.SetLocalForwards(c.cfg, forwards)
+	c.localMu.Unlock()
+	c.syncListeners()
 }
 
-func (c *Client) EnsureLocalForward(forward string) bool {
-	trimmed := strings.TrimSpace(forward)
-	if trimmed == "" {
+func (c *Client) EnsureLocalForward(entry config.ForwardEntry) bool {
+	entry.Spec = strings.TrimSpace(entry.Spec)
+	if entry.Spec == "" {
 		return false
 	}
 	c.localMu.Lock()
 	defer c.localMu.Unlock()
 	current := config.NormalizeLocalForwards(c.cfg)
-	for _, existing := range current {
-		if existing == trimmed {
-			return false
-		}
-	}
-	current = append(current, trimmed)
-	config.SetLocalForwards(c.cfg, current)
-	return true
+	c.cfg.Client.LocalForwards = config.UpsertForwardEntry(c.cfg.Client.LocalForwards, entry)
+	return len(config.NormalizeLocalForwards(c.cfg)) > len(current)
 }
 
 func (c *Client) RemoveLocalForward(forward string) (bool, error) {
@@ -190,23 +306,23 @@ func (c *Client) RemoveLocalForward(forward string) (bool, error) {
 	c.localMu.Lock()
 	defer c.localMu.Unlock()
 	current := config.NormalizeLocalForwards(c.cfg)
+	removed := config.ForwardKeys(trimmed)
 	next := make([]string, 0, len(current))
-	removed := false
 	for _, existing := range current {
-		if existing == trimmed {
-			removed = true
+		if _, ok := removed[config.ForwardKey(existing)]; ok {
 			continue
 		}
 		next = append(next, existing)
 	}
-	if !removed {
+	if len(next) == len(current) {
 		return false, nil
 	}
-	if len(next) == 0 {
+	if len(next) == 0 && len(config.NormalizeDynamicForwards(c.cfg)) == 0 {
 		return false, fmt.Errorf("at least one local forward is required")
 	}
 	config.SetLocalForwards(c.cfg, next)
 	c.RequestRestart("local forward removed")
+	go c.syncListeners()
 	return true, nil
 }
 
@@ -218,6 +334,11 @@ func (c *Client) ClearLocalForwards() bool {
 		return false
 	}
 	config.SetLocalForwards(c.cfg, nil)
-	c.RequestStop()
+	if len(config.NormalizeDynamicForwards(c.cfg)) > 0 {
+		c.RequestRestart("local forwards cleared")
+	} else {
+		c.RequestStop()
+	}
+	go c.syncListeners()
 	return true
 }
@@ -17,6 +17,7 @@ import (
 	"reverse-proxy-agent/internal/client"
 	"reverse-proxy-agent/pkg/config"
 	"reverse-proxy-agent/pkg/logging"
+	"reverse-proxy-agent/pkg/probe"
 )
 
 type Server struct {
@@ -129,6 +130,10 @@ func (s *Server) handleConn(conn net.Conn) {
 		s.handleAddLocalForward(conn, req.Args)
 	case "remove_local_forward":
 		s.handleRemoveLocalForward(conn, req.Args)
+	case "add_dynamic_forward":
+		s.handleAddDynamicForward(conn, req.Args)
+	case "remove_dynamic_forward":
+		s.handleRemoveDynamicForward(conn, req.Args)
 	case "clear_local_forwards":
 		s.handleClearLocalForwards(conn)
 	default:
@@ -148,7 +153,24 @@ func (s *Server) handleStatus(conn net.Conn) {
 		"last_class":   s.client.LastClass(),
 		"last_trigger": s.client.LastTriggerReason(),
 	}
-	data["local_forwards"] = strings.Join(s.client.LocalForwards(), ",")
+	forwards := s.client.LocalForwards()
+	labels := make([]string, 0, len(forwards))
+	for _, forward := range forwards {
+		labels = append(labels, s.client.ForwardLabel(forward))
+	}
+	data["local_forwards"] = strings.Join(labels, ",")
+	if disabled := s.client.DisabledForwards(); len(disabled) > 0 {
+		data["disabled_forwards"] = strings.Join(disabled, ",")
+	}
+	if managed := s.client.ManagedListeners(); len(managed) > 0 {
+		data["managed_listeners"] = strings.Join(managed, ",")
+	}
+	if dynamic := s.client.DynamicForwards(); len(dynamic) > 0 {
+		data["dynamic_forwards"] = strings.Join(dynamic, ",")
+	}
+	if fronts := s.client.SOCKSFronts(); len(fronts) > 0 {
+		data["socks_auth"] = strings.Join(fronts, ",")
+	}
 	if !s.client.LastSuccess().IsZero() {
 		data["last_success_unix"] = fmt.Sprintf("%d", s.client.LastSuccess().Unix())
 	}
@@ -161,6 +183,22 @@ func (s *Server) handleStatus(conn net.Conn) {
 			data["tcp_check_unix"] = fmt.Sprintf("%d", at.Unix())
 		}
 	}
+	if periodic := s.client.PeriodicStatus(); !periodic.Next.IsZero() {
+		data["periodic_next_unix"] = fmt.Sprintf("%d", periodic.Next.Unix())
+		if periodic.DeferReason != "" {
+			deferred := fmt.Sprintf("%s since_unix=%d", periodic.DeferReason, periodic.DeferredSince.Unix())
+			if periodic.ActiveConnections > 0 {
+				deferred += fmt.Sprintf(" active_connections=%d", periodic.ActiveConnections)
+			}
+			data["periodic_deferred"] = deferred
+		}
+	}
+	for _, st := range s.client.ProbeStatuses() {
+		data[probe.StatusKey(s.client.ForwardLabel(st.Forward), st)] = probe.FormatStatus(st)
+	}
+	for forward, st := range s.client.ForwardStats() {
+		data["forward."+s.client.ForwardLabel(forward)] = st.String()
+	}
 	if backoff := s.client.CurrentBackoff(); backoff > 0 {
 		data["backoff_ms"] = fmt.Sprintf("%d", backoff.Milliseconds())
 	}
@@ -184,6 +222,20 @@ func (s *Server) handleMetrics(conn net.Conn) {
 	if backoff := s.client.CurrentBackoff(); backoff > 0 {
 		data["rpa_client_backoff_ms"] = fmt.Sprintf("%d", backoff.Milliseconds())
 	}
+	for forward, st := range s.client.ForwardStats() {
+		label := fmt.Sprintf("{forward=%q}", forward)
+		data["rpa_client_forward_accepted_total"+label] = fmt.Sprintf("%d", st.Accepted)
+		data["rpa_client_forward_active"+label] = fmt.Sprintf("%d", st.Active)
+		data["rpa_client_forward_bytes_in_total"+label] = fmt.Sprintf("%d", st.BytesIn)
+		data["rpa_client_forward_bytes_out_total"+label] = fmt.Sprintf("%d", st.BytesOut)
+		data["rpa_client_forward_connect_errors_total"+label] = fmt.Sprintf("%d", st.ConnectErrors)
+		data["rpa_client_forward_rejected_total"+label] = fmt.Sprintf("%d", st.Rejected)
+		data["rpa_client_forward_closed_total"+label] = fmt.Sprintf("%d", st.Closed)
+		data["rpa_client_forward_duration_sec_total"+label] = fmt.Sprintf("%.3f", st.Duration.Seconds())
+		if !st.LastAccepted.IsZero() {
+			data["rpa_client_forward_last_accepted_unix"+label] = fmt.Sprintf("%d", st.LastAccepted.Unix())
+		}
+	}
 	writeResponse(conn, response{OK: true, Data: data})
 }
 
@@ -197,16 +249,21 @@ func (s *Server) handleStop(conn net.Conn) {
 }
 
 func (s *Server) handleAddLocalForward(conn net.Conn, args map[string]string) {
-	forward := ""
+	var entry config.ForwardEntry
+	if args != nil && args["entry"] != "" {
+		if err := json.Unmarshal([]byte(args["entry"]), &entry); err != nil {
+			writeResponse(conn, response{OK: false, Message: fmt.Sprintf("invalid entry: %v", err)})
+			return
+		}
+	}
 	if args != nil {
-		forward = args["local_forward"]
+		entry.Spec = strings.TrimSpace(args["local_forward"])
 	}
-	forward = strings.TrimSpace(forward)
-	if forward == "" {
+	if entry.Spec == "" {
 		writeResponse(conn, response{OK: false, Message: "local_forward is required"})
 		return
 	}
-	added := s.client.EnsureLocalForward(forward)
+	added := s.client.EnsureLocalForward(entry)
 	msg := "local forward already present"
 	if added {
 		msg = "local forward added"
@@ -240,11 +297,63 @@ func (s *Server) handleRemoveLocalForward(conn net.Conn, args map[string]string)
 	})
 }
 
+func (s *Server) handleAddDynamicForward(conn net.Conn, args map[string]string) {
+	forward := ""
+	if args != nil {
+		forward = args["dynamic_forward"]
+	}
+	forward = strings.TrimSpace(forward)
+	if forward == "" {
+		writeResponse(conn, response{OK: false, Message: "dynamic_forward is required"})
+		return
+	}
+	added, err := s.client.EnsureDynamicForward(forward)
+	if err != nil {
+		writeResponse(conn, response{OK: false, Message: err.Error()})
+		return
+	}
+	msg := "dynamic forward already present"
+	if added {
+		msg = "dynamic forward added"
+		s.client.RequestRestart("client_add_dynamic")
+	}
+	writeResponse(conn, response{
+		OK:      true,
+		Message: msg,
+		Data:    map[string]string{"added": fmt.Sprintf("%t", added)},
+	})
+}
+
+func (s *Server) handleRemoveDynamicForward(conn net.Conn, args map[string]string) {
+	forward := ""
+	if args != nil {
+		forward = args["dynamic_forward"]
+	}
+	removed, err := s.client.RemoveDynamicForward(forward)
+	if err != nil {
+		writeResponse(conn, response{OK: false, Message: err.Error()})
+		return
+	}
+	msg := "dynamic forward not found"
+	if removed {
+		msg = "dynamic forward removed"
+		s.client.RequestRestart("client_remove_dynamic")
+	}
+	writeResponse(conn, response{
+		OK:      true,
+		Message: msg,
+		Data:    map[string]string{"removed": fmt.Sprintf("%t", removed)},
+	})
+}
+
 func (s *Server) handleClearLocalForwards(conn net.Conn) {
 	cleared := s.client.ClearLocalForwards()
 	msg := "no local forwards to clear"
 	if cleared {
 		msg = "local forwards cleared; stopping client"
+		if len(s.client.DynamicForwards()) > 0 {
+			msg = "local forwards cleared; dynamic forwards remain"
+		}
 	}
 	writeResponse(conn, response{
 		OK:      true,
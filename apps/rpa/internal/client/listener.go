@@ -0,0 +1,194 @@
+// Package client runs rpa-owned listeners for managed local forwards.
+// Each accepted connection is multiplexed over the SSH control master with `ssh -W`.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"time"
+
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/forward"
+	"reverse-proxy-agent/pkg/logging"
+	"reverse-proxy-agent/pkg/relay"
+	"reverse-proxy-agent/pkg/sshutil"
+	"reverse-proxy-agent/pkg/state"
+)
+
+const tunnelPollInterval = 200 * time.Millisecond
+
+func (c *Client) buildCommand() (*exec.Cmd, error) {
+	managed := c.managedForwards()
+	forwards := make([]string, 0)
+	for _, forward := range c.currentLocalForwards() {
+		if _, ok := managed[forward]; ok {
+			continue
+		}
+		forwards = append(forwards, forward)
+	}
+	dynamic, err := c.dynamicForwardArgs()
+	if err != nil {
+		return nil, err
+	}
+	controlPath := ""
+	if len(managed) > 0 {
+		path, err := config.ClientControlPath(c.cfg)
+		if err != nil {
+			return nil, err
+		}
+		controlPath = path
+	}
+	return buildSSHCommand(c.cfg, forwards, dynamic, controlPath)
+}
+
+func (c *Client) managedForwards() map[string]struct{} {
+	c.localMu.Lock()
+	defer c.localMu.Unlock()
+	out := make(map[string]struct{})
+	for _, forward := range config.NormalizeLocalForwards(c.cfg) {
+		if config.IsManagedForward(c.cfg, forward) {
+			out[forward] = struct{}{}
+		}
+	}
+	return out
+}
+
+// syncListeners starts listeners for newly managed forwards and closes those
+// whose forward has been removed.
+func (c *Client) syncListeners() {
+	managed := c.managedForwards()
+
+	c.listenerMu.Lock()
+	defer c.listenerMu.Unlock()
+	logger := c.logger
+	if logger == nil {
+		return
+	}
+	for forward, ln := range c.listeners {
+		if _, ok := managed[forward]; ok {
+			continue
+		}
+		_ = ln.Close()
+		delete(c.listeners, forward)
+		logger.Event("INFO", "listener_stopped", map[string]any{"forward": forward})
+	}
+	for forward := range managed {
+		if _, ok := c.listeners[forward]; ok {
+			continue
+		}
+		ln, err := c.startListener(forward, logger)
+		if err != nil {
+			logger.Event("ERROR", "listener_start_failed", map[string]any{
+				"forward": forward,
+				"error":   err.Error(),
+			})
+			continue
+		}
+		c.listeners[forward] = ln
+		logger.Event("INFO", "listener_started", map[string]any{
+			"forward": forward,
+			"addr":    ln.Addr,
+		})
+	}
+}
+
+func (c *Client) startListener(value string, logger *logging.Logger) (*relay.Listener, error) {
+	f, err := forward.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	controlPath, err := config.ClientControlPath(c.cfg)
+	if err != nil {
+		return nil, err
+	}
+	target := f.Target.Address()
+	ln := relay.NewListener(value, f.Bind.Address(), func(ctx context.Context) (io.ReadWriteCloser, error) {
+		if err := c.waitTunnel(ctx, controlPath); err != nil {
+			return nil, err
+		}
+		return sshutil.DialCommand(buildStdioCommand(c.cfg, controlPath, target))
+	}, logger)
+	ln.DialTimeout = c.cfg.Client.ManagedWait()
+	stats, ok := c.stats[value]
+	if !ok {
+		stats = &relay.Stats{}
+		c.stats[value] = stats
+	}
+	ln.Stats = stats
+	if err := ln.Start(); err != nil {
+		return nil, err
+	}
+	return ln, nil
+}
+
+// ManagedListeners returns the forwards whose listener is currently open.
+func (c *Client) ManagedListeners() []string {
+	c.listenerMu.Lock()
+	defer c.listenerMu.Unlock()
+	out := make([]string, 0, len(c.listeners))
+	for forward := range c.listeners {
+		out = append(out, forward)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ForwardStats returns traffic counters for every forward that has had a
+// managed listener since the client started.
+func (c *Client) ForwardStats() map[string]relay.StatsSnapshot {
+	c.listenerMu.Lock()
+	defer c.listenerMu.Unlock()
+	out := make(map[string]relay.StatsSnapshot, len(c.stats))
+	for forward, stats := range c.stats {
+		out[forward] = stats.Snapshot()
+	}
+	return out
+}
+
+func (c *Client) stopListeners() {
+	c.listenerMu.Lock()
+	defer c.listenerMu.Unlock()
+	for forward, ln := range c.listeners {
+		_ = ln.Close()
+		delete(c.listeners, forward)
+	}
+	for forward, ln := range c.fronts {
+		_ = ln.Close()
+		delete(c.fronts, forward)
+	}
+}
+
+// waitTunnel blocks until the session is running and its control socket, if
+// any, is accepting clients, so connections made during a restart queue instead of failing.
+func (c *Client) waitTunnel(ctx context.Context, controlPath string) error {
+	for {
+		if c.runner.State() == state.StateConnected {
+			if controlPath == "" {
+				return nil
+			}
+			if _, err := os.Stat(controlPath); err == nil {
+				return nil
+			}
+		}
+		if c.cfg.Client.ManagedWait() == 0 {
+			return errors.New("tunnel not ready")
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("tunnel not ready: %w", ctx.Err())
+		case <-time.After(tunnelPollInterval):
+		}
+	}
+}
+
+func (c *Client) setLogger(logger *logging.Logger) {
+	c.listenerMu.Lock()
+	defer c.listenerMu.Unlock()
+	c.logger = logger
+}
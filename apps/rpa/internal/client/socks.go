@@ -0,0 +1,289 @@
+// Package client runs dynamic (SOCKS5) forwards with `ssh -D`.
+// Non-loopback binds get an rpa front that enforces SOCKS username/password before reaching ssh.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/forward"
+	"reverse-proxy-agent/pkg/logging"
+	"reverse-proxy-agent/pkg/relay"
+	"reverse-proxy-agent/pkg/socks"
+)
+
+// listenFailurePattern matches ssh's error when a forward's listen port is
+// already taken.
+var listenFailurePattern = regexp.MustCompile(`cannot listen to port: (\d+)`)
+
+// dynamicForwardArgs returns the `-D` specs for ssh. Authenticated forwards
+// bind ssh to a private loopback port that only the front dials.
+func (c *Client) dynamicForwardArgs() ([]string, error) {
+	forwards := c.currentDynamicForwards()
+	out := make([]string, 0, len(forwards))
+	for _, forward := range forwards {
+		if !c.needsSOCKSAuth(forward) {
+			out = append(out, forward)
+			continue
+		}
+		inner, err := c.innerSOCKSAddr(forward)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, inner)
+	}
+	return out, nil
+}
+
+func (c *Client) needsSOCKSAuth(forward string) bool {
+	c.localMu.Lock()
+	defer c.localMu.Unlock()
+	return config.DynamicForwardNeedsAuth(c.cfg, forward)
+}
+
+// innerSOCKSAddr picks the loopback port once per forward so the front and
+// ssh agree on it across restarts. The port is only free when picked, so
+// dropInnerSOCKSPort forgets it if ssh later finds it taken.
+func (c *Client) innerSOCKSAddr(forward string) (string, error) {
+	c.listenerMu.Lock()
+	defer c.listenerMu.Unlock()
+	if addr, ok := c.socksInner[forward]; ok {
+		return addr, nil
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("reserve socks port: %w", err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+	c.socksInner[forward] = addr
+	return addr, nil
+}
+
+// dropInnerSOCKSPort watches ssh output for a listen failure on an inner
+// port and forgets that port, so the next session picks another one instead
+// of failing on the same port again.
+func (c *Client) dropInnerSOCKSPort(line string) {
+	m := listenFailurePattern.FindStringSubmatch(line)
+	if m == nil {
+		return
+	}
+	c.listenerMu.Lock()
+	defer c.listenerMu.Unlock()
+	for forward, addr := range c.socksInner {
+		if _, port, err := net.SplitHostPort(addr); err != nil || port != m[1] {
+			continue
+		}
+		delete(c.socksInner, forward)
+		if c.logger != nil {
+			c.logger.Event("WARN", "socks_inner_port_taken", map[string]any{
+				"forward": forward,
+				"inner":   addr,
+			})
+		}
+	}
+}
+
+func (c *Client) innerAddr(forward string) (string, error) {
+	c.listenerMu.Lock()
+	defer c.listenerMu.Unlock()
+	addr, ok := c.socksInner[forward]
+	if !ok {
+		return "", fmt.Errorf("socks forward %s has no ssh port yet", forward)
+	}
+	return addr, nil
+}
+
+func (c *Client) authForwards() map[string]struct{} {
+	c.localMu.Lock()
+	defer c.localMu.Unlock()
+	out := make(map[string]struct{})
+	for _, forward := range config.NormalizeDynamicForwards(c.cfg) {
+		if config.DynamicForwardNeedsAuth(c.cfg, forward) {
+			out[forward] = struct{}{}
+		}
+	}
+	return out
+}
+
+// syncFronts mirrors syncListeners for authenticated dynamic forwards.
+func (c *Client) syncFronts() {
+	wanted := c.authForwards()
+	for forward := range wanted {
+		if _, err := c.innerSOCKSAddr(forward); err != nil {
+			delete(wanted, forward)
+		}
+	}
+
+	c.listenerMu.Lock()
+	defer c.listenerMu.Unlock()
+	logger := c.logger
+	if logger == nil {
+		return
+	}
+	for forward, ln := range c.fronts {
+		if _, ok := wanted[forward]; ok {
+			continue
+		}
+		_ = ln.Close()
+		delete(c.fronts, forward)
+		delete(c.socksInner, forward)
+		logger.Event("INFO", "socks_front_stopped", map[string]any{"forward": forward})
+	}
+	for forward := range wanted {
+		if _, ok := c.fronts[forward]; ok {
+			continue
+		}
+		ln, err := c.startFront(forward, logger)
+		if err != nil {
+			logger.Event("ERROR", "socks_front_start_failed", map[string]any{
+				"forward": forward,
+				"error":   err.Error(),
+			})
+			continue
+		}
+		c.fronts[forward] = ln
+		logger.Event("INFO", "socks_front_started", map[string]any{
+			"forward": forward,
+			"addr":    ln.Addr,
+			"inner":   c.socksInner[forward],
+		})
+	}
+}
+
+// startFront is called with listenerMu held.
+func (c *Client) startFront(value string, logger *logging.Logger) (*relay.Listener, error) {
+	f, err := forward.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	host := f.Bind.Host
+	if host == "*" {
+		host = ""
+	}
+	c.localMu.Lock()
+	hashes := make(map[string]string, len(c.cfg.Client.SOCKSUsers))
+	for _, u := range c.cfg.Client.SOCKSUsers {
+		hashes[u.Username] = u.PasswordHash
+	}
+	c.localMu.Unlock()
+	users := socks.NewUsers(hashes)
+
+	ln := relay.NewListener(value, net.JoinHostPort(host, strconv.Itoa(f.Bind.Port)), func(ctx context.Context) (io.ReadWriteCloser, error) {
+		if err := c.waitTunnel(ctx, ""); err != nil {
+			return nil, err
+		}
+		// Looked up per connection: the port changes if ssh found it taken.
+		inner, err := c.innerAddr(value)
+		if err != nil {
+			return nil, err
+		}
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", inner)
+		if err != nil {
+			return nil, err
+		}
+		if err := socks.ClientNoAuth(conn); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}, logger)
+	ln.DialTimeout = c.cfg.Client.ManagedWait()
+	ln.Handshake = func(conn net.Conn) (relay.DialFunc, error) {
+		user, err := socks.ServerAuth(conn, users)
+		if err != nil && user != "" {
+			return nil, fmt.Errorf("%w (user %q)", err, user)
+		}
+		return nil, err
+	}
+	stats, ok := c.stats[value]
+	if !ok {
+		stats = &relay.Stats{}
+		c.stats[value] = stats
+	}
+	ln.Stats = stats
+	if err := ln.Start(); err != nil {
+		return nil, err
+	}
+	return ln, nil
+}
+
+// SOCKSFronts returns the dynamic forwards currently guarded by rpa.
+func (c *Client) SOCKSFronts() []string {
+	c.listenerMu.Lock()
+	defer c.listenerMu.Unlock()
+	out := make([]string, 0, len(c.fronts))
+	for forward := range c.fronts {
+		out = append(out, forward)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (c *Client) currentDynamicForwards() []string {
+	c.localMu.Lock()
+	defer c.localMu.Unlock()
+	return config.NormalizeDynamicForwards(c.cfg)
+}
+
+func (c *Client) DynamicForwards() []string {
+	return c.currentDynamicForwards()
+}
+
+func (c *Client) EnsureDynamicForward(value string) (bool, error) {
+	trimmed := strings.TrimSpace(value)
+	members, err := forward.Expand(trimmed)
+	if err != nil {
+		return false, err
+	}
+	if !members[0].Dynamic {
+		return false, fmt.Errorf("invalid dynamic forward %q: takes no destination", trimmed)
+	}
+	c.localMu.Lock()
+	current := config.NormalizeDynamicForwards(c.cfg)
+	config.SetDynamicForwards(c.cfg, append(current, trimmed))
+	added := len(config.NormalizeDynamicForwards(c.cfg)) > len(current)
+	c.localMu.Unlock()
+	if !added {
+		return false, nil
+	}
+	c.syncFronts()
+	return true, nil
+}
+
+func (c *Client) RemoveDynamicForward(value string) (bool, error) {
+	trimmed := strings.TrimSpace(value)
+	if trimmed == "" {
+		return false, fmt.Errorf("dynamic forward is required")
+	}
+	c.localMu.Lock()
+	current := config.NormalizeDynamicForwards(c.cfg)
+	removed := config.ForwardKeys(trimmed)
+	next := make([]string, 0, len(current))
+	for _, existing := range current {
+		if _, ok := removed[config.ForwardKey(existing)]; !ok {
+			next = append(next, existing)
+		}
+	}
+	if len(next) == len(current) {
+		c.localMu.Unlock()
+		return false, nil
+	}
+	if len(next) == 0 && len(config.NormalizeLocalForwards(c.cfg)) == 0 {
+		c.localMu.Unlock()
+		return false, fmt.Errorf("at least one local or dynamic forward is required")
+	}
+	config.SetDynamicForwards(c.cfg, next)
+	c.localMu.Unlock()
+	c.syncFronts()
+	return true, nil
+}
@@ -0,0 +1,29 @@
+package client
+
+import (
+	"testing"
+
+	"reverse-proxy-agent/pkg/config"
+)
+
+func TestDropInnerSOCKSPort(t *testing.T) {
+	c := New(&config.Config{})
+	c.socksInner["0.0.0.0:1080"] = "127.0.0.1:40123"
+	c.socksInner["0.0.0.0:1081"] = "127.0.0.1:40124"
+
+	c.dropInnerSOCKSPort("channel_setup_fwd_listener_tcpip: cannot listen to port: 40123")
+	if _, ok := c.socksInner["0.0.0.0:1080"]; ok {
+		t.Fatal("taken port was kept")
+	}
+	if addr := c.socksInner["0.0.0.0:1081"]; addr != "127.0.0.1:40124" {
+		t.Fatalf("other forward lost its port: %q", addr)
+	}
+
+	addr, err := c.innerSOCKSAddr("0.0.0.0:1080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr == "127.0.0.1:40123" || addr == "" {
+		t.Fatalf("innerSOCKSAddr = %q, want a new port", addr)
+	}
+}
@@ -4,20 +4,25 @@
 package client
 
 import (
-	"fmt"
 	"os"
 	"os/exec"
-	"path/filepath"
-	"strconv"
 	"strings"
 
 	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/sshutil"
 )
 
-func buildSSHCommand(cfg *config.Config, localForwards []string) (*exec.Cmd, error) {
+// buildSSHCommand starts the client session. Callers leave managed forwards out
+// of localForwards; when any exist the session becomes a ControlMaster at
+// controlPath so that rpa-owned listeners can multiplex connections over it.
+// dynamicForwards become `-D` SOCKS listeners.
+func buildSSHCommand(cfg *config.Config, localForwards, dynamicForwards []string, controlPath string) (*exec.Cmd, error) {
 	if err := config.ValidateClient(cfg); err != nil {
 		return nil, err
 	}
+	if err := sshutil.SyncKnownHosts(cfg); err != nil {
+		return nil, err
+	}
 
 	args := []string{
 		"-N",
@@ -32,41 +37,50 @@ func buildSSHCommand(cfg *config.Config, localForwards []string) (*exec.Cmd, err
 		}
 		args = append(args, "-L", forward)
 	}
-
-	if cfg.SSH.IdentityFile != "" {
-		args = append(args, "-i", expandTilde(cfg.SSH.IdentityFile))
-	}
-
-	for _, opt := range cfg.SSH.Options {
-		if strings.TrimSpace(opt) == "" {
+	for _, forward := range dynamicForwards {
+		if strings.TrimSpace(forward) == "" {
 			continue
 		}
-		args = append(args, "-o", opt)
+		args = append(args, "-D", forward)
 	}
+	args = append(args, streamLocalArgs(cfg, localForwards)...)
 
-	if cfg.SSH.Port > 0 {
-		args = append(args, "-p", strconv.Itoa(cfg.SSH.Port))
+	if controlPath != "" {
+		_ = os.Remove(controlPath)
+		args = append(args,
+			"-o", "ControlMaster=yes",
+			"-o", "ControlPath="+controlPath,
+			"-o", "ControlPersist=no",
+		)
 	}
 
-	userHost := cfg.SSH.Host
-	if cfg.SSH.User != "" {
-		userHost = fmt.Sprintf("%s@%s", cfg.SSH.User, cfg.SSH.Host)
-	}
-
-	args = append(args, userHost)
+	args = append(args, sshutil.ConnectionArgs(cfg)...)
 	return exec.Command("ssh", args...), nil
 }
 
-func expandTilde(path string) string {
-	if path == "" || path[0] != '~' {
-		return path
+// buildStdioCommand opens one `ssh -W` stream multiplexed over the control master.
+func buildStdioCommand(cfg *config.Config, controlPath, target string) *exec.Cmd {
+	args := []string{
+		"-T",
+		"-o", "BatchMode=yes",
+		"-o", "ClearAllForwardings=yes",
+		"-o", "ControlMaster=no",
+		"-o", "ControlPath=" + controlPath,
+		"-W", target,
 	}
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return path
+	args = append(args, sshutil.ConnectionArgs(cfg)...)
+	return exec.Command("ssh", args...)
+}
+
+// streamLocalArgs lets ssh replace a stale local Unix socket left by a
+// previous session and applies the configured socket permissions.
+func streamLocalArgs(cfg *config.Config, forwards []string) []string {
+	if !config.HasSocketBind(forwards) {
+		return nil
 	}
-	if path == "~" {
-		return home
+	args := []string{"-o", "StreamLocalBindUnlink=yes"}
+	if mask := strings.TrimSpace(cfg.SSH.StreamLocalBindMask); mask != "" {
+		args = append(args, "-o", "StreamLocalBindMask="+mask)
 	}
-	return filepath.Join(home, path[2:])
+	return args
 }
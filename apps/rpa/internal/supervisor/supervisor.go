@@ -8,6 +8,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math/rand"
 	"net"
 	"os"
 	"os/exec"
@@ -18,6 +19,7 @@ import (
 
 	"reverse-proxy-agent/pkg/logging"
 	"reverse-proxy-agent/pkg/monitor"
+	"reverse-proxy-agent/pkg/probe"
 	"reverse-proxy-agent/pkg/restart"
 	"reverse-proxy-agent/pkg/sshutil"
 	"reverse-proxy-agent/pkg/state"
@@ -33,8 +35,28 @@ type Options struct {
 	BuildInfo          map[string]any
 	TCPCheckSec        int
 	TCPCheckAddr       string
+	Probes             []*probe.Probe
+
+	// PeriodicJitterSec adds a random delay to each periodic restart interval.
+	PeriodicJitterSec int
+	// PeriodicMaxDeferSec bounds how long a periodic restart waits for active
+	// forwarded connections to finish; 0 disables connection-aware deferral.
+	PeriodicMaxDeferSec int
+	QuietHours          func(time.Time) bool
+	ActiveConnections   func() (int, error)
+}
+
+// PeriodicStatus describes the next periodic restart and any active deferral.
+type PeriodicStatus struct {
+	Next              time.Time
+	DeferReason       string
+	DeferredSince     time.Time
+	ActiveConnections int
 }
 
+// ProbeStatus is the latest result of a forward probe.
+type ProbeStatus = probe.Status
+
 type Runner struct {
 	sm *state.StateMachine
 
@@ -69,11 +91,17 @@ type Runner struct {
 	tcpCheckError  string
 	lastTCPCheck   time.Time
 
+	probeStatus []ProbeStatus
+
+	periodic PeriodicStatus
+
 	stateWriter func(statefile.Snapshot)
+	stderrHook  func(string)
 }
 
 const successGracePeriod = 2 * time.Second
 const tcpCheckTimeout = 3 * time.Second
+const periodicDeferCheck = 30 * time.Second
 
 func New(policy restart.Policy, backoff *restart.Backoff) *Runner {
 	return &Runner{
@@ -122,6 +150,7 @@ func (r *Runner) Start(build func() (*exec.Cmd, error)) error {
 	r.waitErr = nil
 	r.errLines = sshutil.NewLineBuffer(10)
 	waitDone := r.waitDone
+	stderrHook := r.stderrHook
 	r.mu.Unlock()
 
 	go func() {
@@ -134,8 +163,8 @@ func (r *Runner) Start(build func() (*exec.Cmd, error)) error {
 		close(waitDone)
 	}()
 
-	go drain(stdout, nil)
-	go drain(stderr, r.errLines)
+	go drainStdout(stdout, stderrHook)
+	go drainStderr(stderr, r.errLines, stderrHook)
 
 	if err := r.sm.Transition(state.StateConnected); err != nil {
 		r.terminateProcess()
@@ -238,11 +267,19 @@ func (r *Runner) RunWithLogger(logger *logging.Logger, build func() (*exec.Cmd,
 			r.tcpCheckLoop(monitorCtx, time.Duration(opts.TCPCheckSec)*time.Second, opts.TCPCheckAddr)
 		}()
 	}
+	r.resetProbes(opts.Probes)
+	for i, p := range opts.Probes {
+		eventWG.Add(1)
+		go func(idx int, p *probe.Probe) {
+			defer eventWG.Done()
+			r.probeLoop(monitorCtx, logger, idx, p, opts.DebounceMs)
+		}(i, p)
+	}
 
 	var periodicStop chan struct{}
 	if opts.PeriodicRestartSec > 0 {
 		periodicStop = make(chan struct{})
-		go r.periodicRestartLoop(logger, time.Duration(opts.PeriodicRestartSec)*time.Second, opts.DebounceMs, periodicStop)
+		go r.periodicRestartLoop(logger, opts, periodicStop)
 	}
 	defer func() {
 		cancel()
@@ -355,11 +392,15 @@ func (r *Runner) RunWithLogger(logger *logging.Logger, build func() (*exec.Cmd,
 			return nil
 		}
 		if class == "auth" || class == "hostkey" {
-			logger.Event("ERROR", "restart_policy_stop", map[string]any{
+			fields := map[string]any{
 				"policy": r.policy.Name(),
 				"class":  class,
 				"reason": "manual intervention required",
-			})
+			}
+			if class == "hostkey" {
+				fields["hint"] = "server host key does not match; verify its fingerprint out of band, then run `rpa hostkey pin`"
+			}
+			logger.Event("ERROR", "restart_policy_stop", fields)
 			return nil
 		}
 		if err == nil {
@@ -444,39 +485,122 @@ func (r *Runner) triggerRestart(logger *logging.Logger, reason string, debounceM
 	r.terminateProcess()
 }
 
-func (r *Runner) periodicRestartLoop(logger *logging.Logger, interval time.Duration, debounceMs int, stop <-chan struct{}) {
+func (r *Runner) periodicRestartLoop(logger *logging.Logger, opts Options, stop <-chan struct{}) {
+	interval := time.Duration(opts.PeriodicRestartSec) * time.Second
 	if interval <= 0 {
 		return
 	}
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+	maxDefer := time.Duration(opts.PeriodicMaxDeferSec) * time.Second
+	unsupportedLogged := false
 
 	for {
-		select {
-		case <-stop:
-			return
-		case <-r.stopCh:
+		wait := interval
+		if opts.PeriodicJitterSec > 0 {
+			wait += time.Duration(rand.Int63n(int64(opts.PeriodicJitterSec) * int64(time.Second)))
+		}
+		r.setPeriodicNext(time.Now().Add(wait))
+		if !r.waitPeriodic(wait, stop) {
 			return
-		case <-ticker.C:
-			if r.State() != state.StateConnected {
-				continue
+		}
+
+		var deferredSince time.Time
+		for r.State() == state.StateConnected {
+			reason := ""
+			active := 0
+			if opts.QuietHours != nil && opts.QuietHours(time.Now()) {
+				reason = "quiet_hours"
+			} else if maxDefer > 0 && opts.ActiveConnections != nil {
+				n, err := opts.ActiveConnections()
+				if err != nil && !unsupportedLogged {
+					unsupportedLogged = true
+					logger.Event("WARN", "restart_defer_unavailable", map[string]any{
+						"reason": "periodic",
+						"error":  err.Error(),
+					})
+				}
+				if err == nil && n > 0 {
+					reason = "active_connections"
+					active = n
+				}
+			}
+			if reason == "" {
+				r.periodicRestart(logger, opts.DebounceMs)
+				break
 			}
-			if !r.allowTrigger(time.Duration(debounceMs) * time.Millisecond) {
-				logger.Event("INFO", "restart_skipped", map[string]any{
-					"reason": "periodic",
-					"detail": "debounced",
+			if deferredSince.IsZero() {
+				deferredSince = time.Now()
+				logger.Event("INFO", "restart_deferred", map[string]any{
+					"reason":             "periodic",
+					"detail":             reason,
+					"active_connections": active,
 				})
-				continue
 			}
-			r.setLastTriggerReason("periodic")
-			logger.Event("INFO", "restart_triggered", map[string]any{
-				"reason": "periodic",
-			})
-			r.terminateProcess()
+			if reason == "active_connections" && time.Since(deferredSince) >= maxDefer {
+				logger.Event("INFO", "restart_defer_expired", map[string]any{
+					"reason":             "periodic",
+					"active_connections": active,
+					"deferred_sec":       int(time.Since(deferredSince).Seconds()),
+				})
+				r.periodicRestart(logger, opts.DebounceMs)
+				break
+			}
+			r.setPeriodicDeferral(reason, active, deferredSince)
+			if !r.waitPeriodic(periodicDeferCheck, stop) {
+				return
+			}
 		}
+		r.setPeriodicDeferral("", 0, time.Time{})
+	}
+}
+
+func (r *Runner) periodicRestart(logger *logging.Logger, debounceMs int) {
+	if !r.allowTrigger(time.Duration(debounceMs) * time.Millisecond) {
+		logger.Event("INFO", "restart_skipped", map[string]any{
+			"reason": "periodic",
+			"detail": "debounced",
+		})
+		return
+	}
+	r.setLastTriggerReason("periodic")
+	logger.Event("INFO", "restart_triggered", map[string]any{
+		"reason": "periodic",
+	})
+	r.terminateProcess()
+}
+
+func (r *Runner) waitPeriodic(d time.Duration, stop <-chan struct{}) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-stop:
+		return false
+	case <-r.stopCh:
+		return false
+	case <-timer.C:
+		return true
 	}
 }
 
+func (r *Runner) setPeriodicNext(next time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.periodic.Next = next
+}
+
+func (r *Runner) setPeriodicDeferral(reason string, active int, since time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.periodic.DeferReason = reason
+	r.periodic.ActiveConnections = active
+	r.periodic.DeferredSince = since
+}
+
+func (r *Runner) PeriodicStatus() PeriodicStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.periodic
+}
+
 func (r *Runner) allowTrigger(window time.Duration) bool {
 	if window <= 0 {
 		return true
@@ -644,6 +768,97 @@ func (r *Runner) recordTCPCheck(err error) {
 	r.mu.Unlock()
 }
 
+func (r *Runner) resetProbes(probes []*probe.Probe) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.probeStatus = make([]ProbeStatus, len(probes))
+	ordinals := make(map[string]int)
+	for i, p := range probes {
+		ordinals[p.Forward]++
+		r.probeStatus[i] = ProbeStatus{Forward: p.Forward, Ordinal: ordinals[p.Forward], Type: p.Type, Status: "unknown"}
+	}
+}
+
+func (r *Runner) probeLoop(ctx context.Context, logger *logging.Logger, idx int, p *probe.Probe, debounceMs int) {
+	if p.Interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if r.State() != state.StateConnected {
+			continue
+		}
+		err := p.Check(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		failures, recovered := r.recordProbe(idx, err)
+		if err == nil {
+			if recovered {
+				logger.Event("INFO", "probe_recovered", map[string]any{
+					"forward": p.Forward,
+					"type":    p.Type,
+				})
+			}
+			continue
+		}
+		logger.Event("WARN", "probe_failed", map[string]any{
+			"forward":  p.Forward,
+			"type":     p.Type,
+			"error":    err.Error(),
+			"failures": failures,
+		})
+		if failures >= p.Threshold {
+			r.clearProbeFailures(idx)
+			r.triggerRestart(logger, "probe_failed", debounceMs)
+		}
+	}
+}
+
+func (r *Runner) recordProbe(idx int, err error) (int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if idx >= len(r.probeStatus) {
+		return 0, false
+	}
+	st := &r.probeStatus[idx]
+	st.LastCheck = time.Now()
+	if err != nil {
+		st.Status = "failed"
+		st.Error = err.Error()
+		st.Failures++
+		return st.Failures, false
+	}
+	recovered := st.Status == "failed"
+	st.Status = "ok"
+	st.Error = ""
+	st.Failures = 0
+	return 0, recovered
+}
+
+func (r *Runner) clearProbeFailures(idx int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if idx < len(r.probeStatus) {
+		r.probeStatus[idx].Failures = 0
+	}
+}
+
+func (r *Runner) ProbeStatuses() []ProbeStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]ProbeStatus, len(r.probeStatus))
+	copy(out, r.probeStatus)
+	return out
+}
+
 func tcpCheck(addr string) error {
 	conn, err := net.DialTimeout("tcp", addr, tcpCheckTimeout)
 	if err != nil {
@@ -658,6 +873,16 @@ func (r *Runner) SetStateWriter(writer func(statefile.Snapshot)) {
 	r.stateWriter = writer
 }
 
+// SetStderrHook receives every ssh stderr line. Verbose `debug1:` lines are
+// passed only to the hook so they do not crowd out errors used for exit classification.
+// Stdout lines go to the hook too, since some ssh versions print notices such
+// as allocated ports there.
+func (r *Runner) SetStderrHook(hook func(string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stderrHook = hook
+}
+
 func (r *Runner) snapshotLocked() statefile.Snapshot {
 	snap := statefile.Snapshot{
 		LastExit:    r.lastExit,
@@ -716,6 +941,33 @@ func drain(r io.Reader, lines *sshutil.LineBuffer) {
 	}
 }
 
+func drainStdout(r io.Reader, hook func(string)) {
+	if hook == nil {
+		drain(r, nil)
+		return
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		hook(scanner.Text())
+	}
+}
+
+func drainStderr(r io.Reader, lines *sshutil.LineBuffer, hook func(string)) {
+	if hook == nil {
+		drain(r, lines)
+		return
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		hook(line)
+		if strings.HasPrefix(line, "debug") {
+			continue
+		}
+		lines.Add(line)
+	}
+}
+
 func stderrSummary(lines *sshutil.LineBuffer) string {
 	if lines == nil {
 		return ""
@@ -0,0 +1,119 @@
+// Package audit appends inbound connection records to a JSON Lines file and queries them.
+// The agent writes an open record when a relayed connection is accepted and a close record when it ends; `rpa audit` reads them back.
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Record events; records written before events were added have none and
+// describe a finished connection.
+const (
+	EventOpen  = "open"
+	EventClose = "close"
+)
+
+// Record is one audit line. Time is when the connection was accepted, so an
+// open record and its close record share Forward, Source and Time.
+type Record struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event,omitempty"`
+	Forward    string    `json:"forward"`
+	Source     string    `json:"source"`
+	DurationMs int64     `json:"duration_ms"`
+	BytesIn    int64     `json:"bytes_in"`
+	BytesOut   int64     `json:"bytes_out"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Writer appends records; the file is opened per write so rotation or removal
+// by other tools is picked up without restarting the agent.
+type Writer struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewWriter(path string) (*Writer, error) {
+	if path == "" {
+		return nil, errors.New("audit path is empty")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create audit dir: %w", err)
+	}
+	return &Writer{path: path}, nil
+}
+
+func (w *Writer) Append(rec Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshal audit record: %w", err)
+	}
+	data = append(data, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("write audit log: %w", err)
+	}
+	return nil
+}
+
+// Filter selects records; zero values match everything.
+type Filter struct {
+	Forward string
+	Since   time.Time
+	Until   time.Time
+}
+
+func (f Filter) Match(rec Record) bool {
+	if f.Forward != "" && rec.Forward != f.Forward {
+		return false
+	}
+	if !f.Since.IsZero() && rec.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && rec.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Query reads path and returns matching records in file order. Lines that do
+// not parse are skipped.
+func Query(path string, filter Filter) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		if filter.Match(rec) {
+			out = append(out, rec)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return out, fmt.Errorf("read audit log: %w", err)
+	}
+	return out, nil
+}
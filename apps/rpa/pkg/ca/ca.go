@@ -0,0 +1,266 @@
+// Package ca manages a small local certificate authority for TLS on exposed services.
+// The CA and the leaf certificates it issues live as PEM files in one directory.
+
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	caCertFile = "ca.crt"
+	caKeyFile  = "ca.key"
+	leafDir    = "certs"
+
+	caValidity = 10 * 365 * 24 * time.Hour
+)
+
+var ErrNotInitialized = errors.New("ca is not initialized (run `rpa ca init`)")
+
+type CA struct {
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// CertPath is the CA certificate users install to trust issued certificates.
+func CertPath(dir string) string {
+	return filepath.Join(dir, caCertFile)
+}
+
+// CheckHost returns host lowercased when it is an IP address, a DNS name or
+// a `*.`-prefixed DNS name, the only names a certificate is issued for.
+func CheckHost(host string) (string, error) {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if host == "" {
+		return "", errors.New("host is required")
+	}
+	if net.ParseIP(host) != nil {
+		return host, nil
+	}
+	if !validDNSName(strings.TrimPrefix(host, "*.")) {
+		return "", fmt.Errorf("invalid host %q: want a DNS name, *.domain or an IP address", host)
+	}
+	return host, nil
+}
+
+func validDNSName(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// LeafPaths returns where the certificate and key for host are stored.
+// Wildcard hosts are stored as `_wildcard.<domain>`.
+func LeafPaths(dir, host string) (string, string, error) {
+	host, err := CheckHost(host)
+	if err != nil {
+		return "", "", err
+	}
+	name := strings.Replace(host, "*", "_wildcard", 1)
+	return filepath.Join(dir, leafDir, name+".crt"), filepath.Join(dir, leafDir, name+".key"), nil
+}
+
+// Init creates a new CA in dir. An existing CA is kept unless force is set.
+func Init(dir string, force bool) (*CA, error) {
+	if _, err := os.Stat(CertPath(dir)); err == nil && !force {
+		return nil, fmt.Errorf("ca already exists in %s", dir)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, leafDir), 0o700); err != nil {
+		return nil, fmt.Errorf("create ca dir: %w", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate ca key: %w", err)
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "rpa local CA " + hostname, Organization: []string{"rpa"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("create ca cert: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("parse ca cert: %w", err)
+	}
+	if err := writeKeyPair(CertPath(dir), filepath.Join(dir, caKeyFile), der, key); err != nil {
+		return nil, err
+	}
+	return &CA{dir: dir, cert: cert, key: key}, nil
+}
+
+func Load(dir string) (*CA, error) {
+	pair, err := tls.LoadX509KeyPair(CertPath(dir), filepath.Join(dir, caKeyFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotInitialized
+		}
+		return nil, fmt.Errorf("load ca: %w", err)
+	}
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("load ca: unsupported key type")
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse ca cert: %w", err)
+	}
+	return &CA{dir: dir, cert: cert, key: key}, nil
+}
+
+func (c *CA) Certificate() *x509.Certificate {
+	return c.cert
+}
+
+// Issue signs a server certificate for host (a DNS name, `*.domain`, or IP)
+// and writes it next to the CA, replacing any previous one.
+func (c *CA) Issue(host string, validity time.Duration) (*x509.Certificate, error) {
+	host, err := CheckHost(host)
+	if err != nil {
+		return nil, err
+	}
+	if validity <= 0 {
+		return nil, errors.New("validity must be > 0")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	notAfter := now.Add(validity)
+	if notAfter.After(c.cert.NotAfter) {
+		notAfter = c.cert.NotAfter
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host, Organization: []string{"rpa"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, c.cert, &key.PublicKey, c.key)
+	if err != nil {
+		return nil, fmt.Errorf("issue cert for %s: %w", host, err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("parse cert: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Join(c.dir, leafDir), 0o700); err != nil {
+		return nil, fmt.Errorf("create certs dir: %w", err)
+	}
+	certPath, keyPath, err := LeafPaths(c.dir, host)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeKeyPair(certPath, keyPath, der, key, c.cert.Raw); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// LoadLeaf loads the issued certificate for host with Leaf populated.
+func LoadLeaf(dir, host string) (*tls.Certificate, error) {
+	certPath, keyPath, err := LeafPaths(dir, host)
+	if err != nil {
+		return nil, err
+	}
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	if pair.Leaf == nil {
+		leaf, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("parse cert: %w", err)
+		}
+		pair.Leaf = leaf
+	}
+	return &pair, nil
+}
+
+// writeKeyPair writes the certificate chain and key atomically; the key is 0600.
+func writeKeyPair(certPath, keyPath string, der []byte, key *ecdsa.PrivateKey, chain ...[]byte) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("encode key: %w", err)
+	}
+	var certPEM []byte
+	for _, block := range append([][]byte{der}, chain...) {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: block})...)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := writeFile(keyPath, keyPEM, 0o600); err != nil {
+		return err
+	}
+	return writeFile(certPath, certPEM, 0o644)
+}
+
+func writeFile(path string, data []byte, mode os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, mode); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+func newSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("generate serial: %w", err)
+	}
+	return serial, nil
+}
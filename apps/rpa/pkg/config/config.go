@@ -4,13 +4,21 @@
 package config
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"reverse-proxy-agent/pkg/ca"
+	"reverse-proxy-agent/pkg/forward"
+	"reverse-proxy-agent/pkg/socks"
 )
 
 type Config struct {
@@ -22,42 +30,77 @@ type Config struct {
 }
 
 type AgentConfig struct {
-	Name               string        `yaml:"name"`
-	LaunchdLabel       string        `yaml:"launchd_label"`
-	RestartPolicy      string        `yaml:"restart_policy"`
-	Restart            RestartConfig `yaml:"restart"`
-	PeriodicRestartSec int           `yaml:"periodic_restart_sec"`
-	SleepCheckSec      int           `yaml:"sleep_check_sec"`
-	SleepGapSec        int           `yaml:"sleep_gap_sec"`
-	NetworkPollSec     int           `yaml:"network_poll_sec"`
-	PreventSleep       bool          `yaml:"prevent_sleep"`
+	Name                       string          `yaml:"name"`
+	LaunchdLabel               string          `yaml:"launchd_label"`
+	RestartPolicy              string          `yaml:"restart_policy"`
+	Restart                    RestartConfig   `yaml:"restart"`
+	PeriodicRestartSec         int             `yaml:"periodic_restart_sec"`
+	PeriodicRestartMaxDeferSec int             `yaml:"periodic_restart_max_defer_sec"`
+	PeriodicRestartJitterSec   int             `yaml:"periodic_restart_jitter_sec"`
+	PeriodicRestartQuietHours  []string        `yaml:"periodic_restart_quiet_hours"`
+	SleepCheckSec              int             `yaml:"sleep_check_sec"`
+	SleepGapSec                int             `yaml:"sleep_gap_sec"`
+	NetworkPollSec             int             `yaml:"network_poll_sec"`
+	PreventSleep               bool            `yaml:"prevent_sleep"`
+	Probes                     []ProbeConfig   `yaml:"probes"`
+	Relays                     []RelayConfig   `yaml:"relays"`
+	AuditPath                  string          `yaml:"audit_path"`
+	HTTPProxy                  HTTPProxyConfig `yaml:"http_proxy,omitempty"`
+	// SOCKSAllow limits the destinations reachable through reverse dynamic
+	// forwards; when set, rpa serves SOCKS itself instead of ssh.
+	SOCKSAllow []string `yaml:"socks_allow,omitempty"`
+	// EventHook is run with `sh -c` on agent events such as an expired
+	// forward; details are passed in RPA_* environment variables.
+	EventHook string `yaml:"event_hook,omitempty"`
+	// PortPool is the server port range `rpa share` allocates from, e.g. 20000-20999.
+	PortPool string `yaml:"port_pool,omitempty"`
 }
 
 type ClientConfig struct {
-	Name               string        `yaml:"name"`
-	LaunchdLabel       string        `yaml:"launchd_label"`
-	RestartPolicy      string        `yaml:"restart_policy"`
-	Restart            RestartConfig `yaml:"restart"`
-	PeriodicRestartSec int           `yaml:"periodic_restart_sec"`
-	SleepCheckSec      int           `yaml:"sleep_check_sec"`
-	SleepGapSec        int           `yaml:"sleep_gap_sec"`
-	NetworkPollSec     int           `yaml:"network_poll_sec"`
-	LocalForwards      []string      `yaml:"local_forwards"`
-	PreventSleep       bool          `yaml:"prevent_sleep"`
+	Name                       string         `yaml:"name"`
+	LaunchdLabel               string         `yaml:"launchd_label"`
+	RestartPolicy              string         `yaml:"restart_policy"`
+	Restart                    RestartConfig  `yaml:"restart"`
+	PeriodicRestartSec         int            `yaml:"periodic_restart_sec"`
+	PeriodicRestartMaxDeferSec int            `yaml:"periodic_restart_max_defer_sec"`
+	PeriodicRestartJitterSec   int            `yaml:"periodic_restart_jitter_sec"`
+	PeriodicRestartQuietHours  []string       `yaml:"periodic_restart_quiet_hours"`
+	SleepCheckSec              int            `yaml:"sleep_check_sec"`
+	SleepGapSec                int            `yaml:"sleep_gap_sec"`
+	NetworkPollSec             int            `yaml:"network_poll_sec"`
+	LocalForwards              []ForwardEntry `yaml:"local_forwards"`
+	ManagedForwards            []string       `yaml:"managed_forwards"`
+	ManagedWaitSec             *int           `yaml:"managed_wait_sec,omitempty"`
+	DynamicForwards            []string       `yaml:"dynamic_forwards,omitempty"`
+	SOCKSUsers                 []SOCKSUser    `yaml:"socks_users,omitempty"`
+	PreventSleep               bool           `yaml:"prevent_sleep"`
+	Probes                     []ProbeConfig  `yaml:"probes"`
+
+	// ranges are the port-range values of DynamicForwards and
+	// ManagedForwards, which only hold expanded members at runtime.
+	ranges []string
 }
 
 type clientConfigRaw struct {
-	Name               string        `yaml:"name"`
-	LaunchdLabel       string        `yaml:"launchd_label"`
-	RestartPolicy      string        `yaml:"restart_policy"`
-	Restart            RestartConfig `yaml:"restart"`
-	PeriodicRestartSec int           `yaml:"periodic_restart_sec"`
-	SleepCheckSec      int           `yaml:"sleep_check_sec"`
-	SleepGapSec        int           `yaml:"sleep_gap_sec"`
-	NetworkPollSec     int           `yaml:"network_poll_sec"`
-	LocalForward       string        `yaml:"local_forward"`
-	LocalForwards      []string      `yaml:"local_forwards"`
-	PreventSleep       bool          `yaml:"prevent_sleep"`
+	Name                       string         `yaml:"name"`
+	LaunchdLabel               string         `yaml:"launchd_label"`
+	RestartPolicy              string         `yaml:"restart_policy"`
+	Restart                    RestartConfig  `yaml:"restart"`
+	PeriodicRestartSec         int            `yaml:"periodic_restart_sec"`
+	PeriodicRestartMaxDeferSec int            `yaml:"periodic_restart_max_defer_sec"`
+	PeriodicRestartJitterSec   int            `yaml:"periodic_restart_jitter_sec"`
+	PeriodicRestartQuietHours  []string       `yaml:"periodic_restart_quiet_hours"`
+	SleepCheckSec              int            `yaml:"sleep_check_sec"`
+	SleepGapSec                int            `yaml:"sleep_gap_sec"`
+	NetworkPollSec             int            `yaml:"network_poll_sec"`
+	LocalForward               string         `yaml:"local_forward"`
+	LocalForwards              []ForwardEntry `yaml:"local_forwards"`
+	ManagedForwards            []string       `yaml:"managed_forwards"`
+	ManagedWaitSec             *int           `yaml:"managed_wait_sec,omitempty"`
+	DynamicForwards            []string       `yaml:"dynamic_forwards,omitempty"`
+	SOCKSUsers                 []SOCKSUser    `yaml:"socks_users,omitempty"`
+	PreventSleep               bool           `yaml:"prevent_sleep"`
+	Probes                     []ProbeConfig  `yaml:"probes"`
 }
 
 func (c *ClientConfig) UnmarshalYAML(value *yaml.Node) error {
@@ -66,28 +109,147 @@ func (c *ClientConfig) UnmarshalYAML(value *yaml.Node) error {
 		return err
 	}
 	*c = ClientConfig{
-		Name:               raw.Name,
-		LaunchdLabel:       raw.LaunchdLabel,
-		RestartPolicy:      raw.RestartPolicy,
-		Restart:            raw.Restart,
-		PeriodicRestartSec: raw.PeriodicRestartSec,
-		SleepCheckSec:      raw.SleepCheckSec,
-		SleepGapSec:        raw.SleepGapSec,
-		NetworkPollSec:     raw.NetworkPollSec,
-		LocalForwards:      mergeLocalForwards(raw.LocalForward, raw.LocalForwards),
-		PreventSleep:       raw.PreventSleep,
+		Name:                       raw.Name,
+		LaunchdLabel:               raw.LaunchdLabel,
+		RestartPolicy:              raw.RestartPolicy,
+		Restart:                    raw.Restart,
+		PeriodicRestartSec:         raw.PeriodicRestartSec,
+		PeriodicRestartMaxDeferSec: raw.PeriodicRestartMaxDeferSec,
+		PeriodicRestartJitterSec:   raw.PeriodicRestartJitterSec,
+		PeriodicRestartQuietHours:  raw.PeriodicRestartQuietHours,
+		SleepCheckSec:              raw.SleepCheckSec,
+		SleepGapSec:                raw.SleepGapSec,
+		NetworkPollSec:             raw.NetworkPollSec,
+		LocalForwards:              mergeLocalForwards(raw.LocalForward, raw.LocalForwards),
+		ManagedForwards:            raw.ManagedForwards,
+		ManagedWaitSec:             raw.ManagedWaitSec,
+		DynamicForwards:            raw.DynamicForwards,
+		SOCKSUsers:                 raw.SOCKSUsers,
+		PreventSleep:               raw.PreventSleep,
+		Probes:                     raw.Probes,
 	}
 	return nil
 }
 
+// ManagedWait is how long a managed forward's connection waits for the
+// tunnel: 30 seconds when unset, and 0 fails it at once.
+func (c ClientConfig) ManagedWait() time.Duration {
+	if c.ManagedWaitSec == nil {
+		return 30 * time.Second
+	}
+	return time.Duration(*c.ManagedWaitSec) * time.Second
+}
+
+// SOCKSUser is required on dynamic forwards bound to a non-loopback address
+// once any are configured. PasswordHash is bcrypt.
+type SOCKSUser struct {
+	Username     string `yaml:"username"`
+	PasswordHash string `yaml:"password_hash"`
+}
+
 type SSHConfig struct {
-	User           string   `yaml:"user"`
-	Host           string   `yaml:"host"`
-	Port           int      `yaml:"port"`
-	RemoteForwards []string `yaml:"remote_forwards"`
-	IdentityFile   string   `yaml:"identity_file"`
-	Options        []string `yaml:"options"`
-	CheckSec       int      `yaml:"check_sec"`
+	User           string         `yaml:"user"`
+	Host           string         `yaml:"host"`
+	Port           int            `yaml:"port"`
+	RemoteForwards []ForwardEntry `yaml:"remote_forwards"`
+	IdentityFile   string         `yaml:"identity_file"`
+	Options        []string       `yaml:"options"`
+	CheckSec       int            `yaml:"check_sec"`
+	// StreamLocalBindMask is the octal umask for Unix sockets ssh creates
+	// locally (OpenSSH default 0177, owner only).
+	StreamLocalBindMask string `yaml:"stream_local_bind_mask,omitempty"`
+	// JumpHosts are bastions between this machine and Host, in order.
+	JumpHosts []JumpHost `yaml:"jump_hosts,omitempty"`
+	// ConfigAlias names a ~/.ssh/config Host whose settings fill the
+	// fields above that are left empty.
+	ConfigAlias string `yaml:"config_alias,omitempty"`
+	// HostKeyFingerprints pins the server's host keys (SHA256:...). When
+	// set, or when HostKeyCA is, ssh checks strictly against
+	// ~/.rpa/known_hosts instead of trusting the first key it sees.
+	HostKeyFingerprints []string `yaml:"host_key_fingerprints,omitempty"`
+	// HostKeyCA is a CA public key, inline or as a path to a .pub file,
+	// whose certificates the server's host key may carry.
+	HostKeyCA string `yaml:"host_key_ca,omitempty"`
+	// GrantAdmin is the account `rpa grant` and the agent's grant cleanup
+	// log in as instead of User, which `rpa server-snippet` locks down with
+	// ForceCommand. It edits User's authorized_keys, so it must be root or
+	// have passwordless sudo.
+	GrantAdmin *GrantAdminConfig `yaml:"grant_admin,omitempty"`
+
+	fromAlias *aliasValues
+}
+
+type GrantAdminConfig struct {
+	User         string `yaml:"user"`
+	IdentityFile string `yaml:"identity_file,omitempty"`
+	// Sudo runs the edits with `sudo -n` for an admin that is not root.
+	Sudo bool `yaml:"sudo,omitempty"`
+}
+
+// ProbeConfig describes an end-to-end check that runs through a forward.
+// Forward must match one of the configured forward specs.
+type ProbeConfig struct {
+	Forward          string `yaml:"forward"`
+	Type             string `yaml:"type"`
+	Address          string `yaml:"address,omitempty"`
+	Expect           string `yaml:"expect,omitempty"`
+	Path             string `yaml:"path,omitempty"`
+	ExpectStatus     int    `yaml:"expect_status,omitempty"`
+	IntervalSec      int    `yaml:"interval_sec"`
+	TimeoutMs        int    `yaml:"timeout_ms"`
+	FailureThreshold int    `yaml:"failure_threshold"`
+}
+
+// RelayConfig puts an rpa-owned relay between a remote forward and its target
+// so that every inbound connection is recorded in the audit log. Limits of 0
+// are unlimited; bandwidth is shared by all connections of the forward.
+type RelayConfig struct {
+	Forward           string `yaml:"forward"`
+	MaxConnections    int    `yaml:"max_connections,omitempty"`
+	ConnectionsPerMin int    `yaml:"connections_per_min,omitempty"`
+	BandwidthKbps     int    `yaml:"bandwidth_kbps,omitempty"`
+
+	group *RelayConfig
+}
+
+// HTTPProxyConfig runs an embedded reverse proxy on Listen and exposes it on
+// the server at RemoteBind (`[host:]port`) through one extra remote forward.
+type HTTPProxyConfig struct {
+	Listen     string        `yaml:"listen,omitempty"`
+	RemoteBind string        `yaml:"remote_bind,omitempty"`
+	Routes     []RouteConfig `yaml:"routes,omitempty"`
+	// LinkSecret is the hex HMAC key for signed links; it is generated the
+	// first time a link is created.
+	LinkSecret            string             `yaml:"link_secret,omitempty"`
+	MaxAuthFailuresPerMin int                `yaml:"max_auth_failures_per_min,omitempty"`
+	TLS                   HTTPProxyTLSConfig `yaml:"tls,omitempty"`
+}
+
+// HTTPProxyTLSConfig terminates TLS on the HTTP proxy with certificates from
+// the rpa local CA; missing or expiring certificates are issued automatically.
+type HTTPProxyTLSConfig struct {
+	Enabled   bool   `yaml:"enabled,omitempty"`
+	CADir     string `yaml:"ca_dir,omitempty"`
+	CertDays  int    `yaml:"cert_days,omitempty"`
+	RenewDays int    `yaml:"renew_days,omitempty"`
+}
+
+type RouteConfig struct {
+	Host       string         `yaml:"host"`
+	PathPrefix string         `yaml:"path_prefix,omitempty"`
+	Target     string         `yaml:"target"`
+	Access     []AccessConfig `yaml:"access,omitempty"`
+}
+
+// AccessConfig is one credential that admits requests to a route. Only hashes
+// are stored: bcrypt for basic passwords and hex SHA-256 for bearer tokens.
+type AccessConfig struct {
+	ID           string    `yaml:"id"`
+	Type         string    `yaml:"type"`
+	Username     string    `yaml:"username,omitempty"`
+	PasswordHash string    `yaml:"password_hash,omitempty"`
+	TokenHash    string    `yaml:"token_hash,omitempty"`
+	ExpiresAt    time.Time `yaml:"expires_at,omitempty"`
 }
 
 type LoggingConfig struct {
@@ -118,6 +280,10 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("parse yaml: %w", err)
 	}
 
+	expandRanges(&cfg)
+	if err := applySSHAlias(&cfg); err != nil {
+		return nil, err
+	}
 	applyDefaults(&cfg)
 	return &cfg, nil
 }
@@ -166,6 +332,7 @@ func applyDefaults(cfg *Config) {
 	if cfg.Agent.Restart.DebounceMs == 0 {
 		cfg.Agent.Restart.DebounceMs = 2000
 	}
+	applyProbeDefaults(cfg.Agent.Probes)
 	if cfg.Client.Name == "" {
 		cfg.Client.Name = "rpa-client"
 	}
@@ -202,6 +369,7 @@ func applyDefaults(cfg *Config) {
 	if cfg.Client.Restart.DebounceMs == 0 {
 		cfg.Client.Restart.DebounceMs = 2000
 	}
+	applyProbeDefaults(cfg.Client.Probes)
 	if cfg.SSH.Port == 0 {
 		cfg.SSH.Port = 22
 	}
@@ -220,6 +388,23 @@ func applyDefaults(cfg *Config) {
 	if cfg.Logging.Path == "" {
 		cfg.Logging.Path = "~/.rpa/logs/agent.log"
 	}
+	if cfg.Agent.HTTPProxy.RemoteBind != "" && cfg.Agent.HTTPProxy.Listen == "" {
+		cfg.Agent.HTTPProxy.Listen = "127.0.0.1:18080"
+	}
+	if cfg.Agent.HTTPProxy.RemoteBind != "" && cfg.Agent.HTTPProxy.MaxAuthFailuresPerMin == 0 {
+		cfg.Agent.HTTPProxy.MaxAuthFailuresPerMin = 10
+	}
+	if cfg.Agent.HTTPProxy.TLS.Enabled {
+		if cfg.Agent.HTTPProxy.TLS.CertDays == 0 {
+			cfg.Agent.HTTPProxy.TLS.CertDays = 90
+		}
+		if cfg.Agent.HTTPProxy.TLS.RenewDays == 0 {
+			cfg.Agent.HTTPProxy.TLS.RenewDays = 30
+		}
+	}
+	if cfg.Agent.AuditPath == "" {
+		cfg.Agent.AuditPath = "~/.rpa/logs/audit.log"
+	}
 	if cfg.ClientLogging.Level == "" {
 		cfg.ClientLogging.Level = "info"
 	}
@@ -228,6 +413,33 @@ func applyDefaults(cfg *Config) {
 	}
 }
 
+func applyProbeDefaults(probes []ProbeConfig) {
+	for i := range probes {
+		p := &probes[i]
+		p.Type = strings.ToLower(strings.TrimSpace(p.Type))
+		if p.Type == "" {
+			p.Type = "tcp"
+		}
+		if p.IntervalSec == 0 {
+			p.IntervalSec = 30
+		}
+		if p.TimeoutMs == 0 {
+			p.TimeoutMs = 5000
+		}
+		if p.FailureThreshold == 0 {
+			p.FailureThreshold = 3
+		}
+		if p.Type == "http" {
+			if p.Path == "" {
+				p.Path = "/"
+			}
+			if p.ExpectStatus == 0 {
+				p.ExpectStatus = 200
+			}
+		}
+	}
+}
+
 func ensureSSHOption(options *[]string, value string) {
 	key := optionKey(value)
 	if key == "" {
@@ -256,9 +468,37 @@ func ValidateAgent(cfg *Config) error {
 	if err := validateCommon(cfg); err != nil {
 		return err
 	}
-	if len(NormalizeRemoteForwards(cfg)) == 0 {
+	if err := validateForwardEntries(cfg.SSH.RemoteForwards, "ssh.remote_forwards"); err != nil {
+		return err
+	}
+	forwards := NormalizeRemoteForwards(cfg)
+	if len(forwards) == 0 && HTTPProxyForward(cfg) == "" {
 		return errors.New("ssh.remote_forwards is required")
 	}
+	known := allSpecs(cfg.SSH.RemoteForwards)
+	if err := validateHTTPProxy(cfg.Agent.HTTPProxy); err != nil {
+		return err
+	}
+	if err := validateProbes(cfg.Agent.Probes, known, "agent"); err != nil {
+		return err
+	}
+	if err := validateRemoteForwards(forwards, HTTPProxyForward(cfg)); err != nil {
+		return err
+	}
+	if err := validateSOCKSAllow(cfg.Agent.SOCKSAllow); err != nil {
+		return err
+	}
+	if strings.TrimSpace(cfg.Agent.PortPool) != "" {
+		if _, _, err := forward.ParsePortRange(cfg.Agent.PortPool); err != nil {
+			return fmt.Errorf("agent.port_pool: %w", err)
+		}
+	}
+	if err := validateRelays(cfg.Agent.Relays, known, len(cfg.Agent.SOCKSAllow) > 0); err != nil {
+		return err
+	}
+	if err := validatePeriodic(cfg.Agent.PeriodicRestartMaxDeferSec, cfg.Agent.PeriodicRestartJitterSec, cfg.Agent.PeriodicRestartQuietHours, "agent"); err != nil {
+		return err
+	}
 	return validateSupervisor(cfg.Agent.RestartPolicy, cfg.Agent.Restart, cfg.Agent.PeriodicRestartSec, cfg.Agent.SleepCheckSec, cfg.Agent.SleepGapSec, cfg.Agent.NetworkPollSec, "agent")
 }
 
@@ -266,8 +506,32 @@ func ValidateClient(cfg *Config) error {
 	if err := validateCommon(cfg); err != nil {
 		return err
 	}
-	if len(NormalizeLocalForwards(cfg)) == 0 {
-		return errors.New("client.local_forwards is required")
+	if err := validateForwardEntries(cfg.Client.LocalForwards, "client.local_forwards"); err != nil {
+		return err
+	}
+	forwards := NormalizeLocalForwards(cfg)
+	dynamic := NormalizeDynamicForwards(cfg)
+	if len(forwards) == 0 && len(dynamic) == 0 {
+		return errors.New("client.local_forwards or client.dynamic_forwards is required")
+	}
+	known := allSpecs(cfg.Client.LocalForwards)
+	if err := validateClientForwards(forwards, dynamic); err != nil {
+		return err
+	}
+	if err := validateSOCKSUsers(cfg.Client.SOCKSUsers); err != nil {
+		return err
+	}
+	if err := validateProbes(cfg.Client.Probes, known, "client"); err != nil {
+		return err
+	}
+	if err := validateManagedForwards(cfg.Client.ManagedForwards, known); err != nil {
+		return err
+	}
+	if cfg.Client.ManagedWaitSec != nil && *cfg.Client.ManagedWaitSec < 0 {
+		return errors.New("client.managed_wait_sec must be >= 0")
+	}
+	if err := validatePeriodic(cfg.Client.PeriodicRestartMaxDeferSec, cfg.Client.PeriodicRestartJitterSec, cfg.Client.PeriodicRestartQuietHours, "client"); err != nil {
+		return err
 	}
 	return validateSupervisor(cfg.Client.RestartPolicy, cfg.Client.Restart, cfg.Client.PeriodicRestartSec, cfg.Client.SleepCheckSec, cfg.Client.SleepGapSec, cfg.Client.NetworkPollSec, "client")
 }
@@ -288,9 +552,40 @@ func validateCommon(cfg *Config) error {
 	if cfg.SSH.CheckSec < 0 {
 		return fmt.Errorf("ssh.check_sec must be >= 0 (got %d)", cfg.SSH.CheckSec)
 	}
+	if mask := strings.TrimSpace(cfg.SSH.StreamLocalBindMask); mask != "" {
+		if n, err := strconv.ParseUint(mask, 8, 32); err != nil || n > 0o777 {
+			return fmt.Errorf("ssh.stream_local_bind_mask must be an octal mask like 0177 (got %q)", mask)
+		}
+	}
+	if err := validateHostKeys(cfg); err != nil {
+		return err
+	}
+	if err := ValidateGrantAdmin(cfg); err != nil {
+		return err
+	}
+	return validateJumpHosts(cfg)
+}
+
+// ValidateGrantAdmin checks ssh.grant_admin. Both account names end up in a
+// remote shell script, so they are limited to what login names use.
+func ValidateGrantAdmin(cfg *Config) error {
+	admin := cfg.SSH.GrantAdmin
+	if admin == nil {
+		return nil
+	}
+	if !validAccountName(admin.User) {
+		return fmt.Errorf("ssh.grant_admin.user %q must be a login name (letters, digits, '.', '_', '-')", admin.User)
+	}
+	if !validAccountName(cfg.SSH.User) {
+		return fmt.Errorf("ssh.user %q must be a login name to be used with ssh.grant_admin", cfg.SSH.User)
+	}
 	return nil
 }
 
+func validAccountName(name string) bool {
+	return name != "" && name[0] != '-' && validForwardName(name)
+}
+
 func validateSupervisor(policy string, restartCfg RestartConfig, periodic, sleepCheck, sleepGap, networkPoll int, label string) error {
 	switch strings.ToLower(policy) {
 	case "always", "on-failure":
@@ -327,96 +622,562 @@ func validateSupervisor(policy string, restartCfg RestartConfig, periodic, sleep
 	return nil
 }
 
-func NormalizeRemoteForwards(cfg *Config) []string {
-	if cfg == nil {
+func validateHTTPProxy(hp HTTPProxyConfig) error {
+	if hp.RemoteBind == "" {
+		if len(hp.Routes) > 0 {
+			return errors.New("agent.http_proxy.remote_bind is required when routes are set")
+		}
 		return nil
 	}
-	out := make([]string, 0, len(cfg.SSH.RemoteForwards))
+	if _, _, err := net.SplitHostPort(hp.Listen); err != nil {
+		return fmt.Errorf("agent.http_proxy.listen must be host:port: %w", err)
+	}
+	bind := strings.Split(hp.RemoteBind, ":")
+	if len(bind) > 2 {
+		return fmt.Errorf("agent.http_proxy.remote_bind must be [host:]port: %s", hp.RemoteBind)
+	}
+	if _, err := strconv.Atoi(bind[len(bind)-1]); err != nil {
+		return fmt.Errorf("agent.http_proxy.remote_bind must be [host:]port: %s", hp.RemoteBind)
+	}
+	if hp.MaxAuthFailuresPerMin < 0 {
+		return errors.New("agent.http_proxy.max_auth_failures_per_min must be >= 0")
+	}
+	if hp.TLS.Enabled {
+		if hp.TLS.CertDays <= 0 {
+			return errors.New("agent.http_proxy.tls.cert_days must be > 0")
+		}
+		if hp.TLS.RenewDays < 0 || hp.TLS.RenewDays >= hp.TLS.CertDays {
+			return errors.New("agent.http_proxy.tls.renew_days must be >= 0 and < cert_days")
+		}
+	}
+	if hp.LinkSecret != "" {
+		if _, err := hex.DecodeString(hp.LinkSecret); err != nil {
+			return fmt.Errorf("agent.http_proxy.link_secret must be hex: %w", err)
+		}
+	}
+	seen := make(map[string]struct{})
+	for i, rc := range hp.Routes {
+		if err := ValidateRoute(rc); err != nil {
+			return fmt.Errorf("agent.http_proxy.routes[%d]: %w", i, err)
+		}
+		for _, ac := range rc.Access {
+			if ac.Type == "link" && hp.LinkSecret == "" {
+				return fmt.Errorf("agent.http_proxy.routes[%d]: link access requires agent.http_proxy.link_secret", i)
+			}
+		}
+		key := strings.ToLower(rc.Host) + rc.PathPrefix
+		if _, ok := seen[key]; ok {
+			return fmt.Errorf("agent.http_proxy.routes[%d]: duplicate host/path_prefix %s%s", i, rc.Host, rc.PathPrefix)
+		}
+		seen[key] = struct{}{}
+	}
+	return nil
+}
+
+// ValidateRoute checks one route. Its host must be a host name, `*.domain`
+// or an IP address: anything else never matches a Host header, and TLS
+// issues a certificate named after it.
+func ValidateRoute(rc RouteConfig) error {
+	if _, err := ca.CheckHost(rc.Host); err != nil {
+		return err
+	}
+	if rc.PathPrefix != "" && !strings.HasPrefix(rc.PathPrefix, "/") {
+		return fmt.Errorf("path_prefix must start with /: %s", rc.PathPrefix)
+	}
+	if strings.TrimSpace(rc.Target) == "" {
+		return errors.New("target is required")
+	}
+	ids := make(map[string]struct{})
+	for i, ac := range rc.Access {
+		if err := validateAccess(ac); err != nil {
+			return fmt.Errorf("access[%d]: %w", i, err)
+		}
+		if _, ok := ids[ac.ID]; ok {
+			return fmt.Errorf("access[%d]: duplicate id %q", i, ac.ID)
+		}
+		ids[ac.ID] = struct{}{}
+	}
+	return nil
+}
+
+func validateAccess(ac AccessConfig) error {
+	if strings.TrimSpace(ac.ID) == "" {
+		return errors.New("id is required")
+	}
+	if strings.ContainsAny(ac.ID, ". ") {
+		return fmt.Errorf("id must not contain dots or spaces: %s", ac.ID)
+	}
+	switch ac.Type {
+	case "basic":
+		if ac.Username == "" || ac.PasswordHash == "" {
+			return errors.New("basic access requires username and password_hash")
+		}
+	case "bearer":
+		if ac.TokenHash == "" {
+			return errors.New("bearer access requires token_hash")
+		}
+	case "link":
+		if ac.ExpiresAt.IsZero() {
+			return errors.New("link access requires expires_at")
+		}
+	default:
+		return fmt.Errorf("access type must be basic, bearer, or link: %s", ac.Type)
+	}
+	return nil
+}
+
+// HTTPProxyForward is the remote forward that exposes the HTTP proxy, or ""
+// when the proxy is not configured.
+func HTTPProxyForward(cfg *Config) string {
+	if cfg == nil || cfg.Agent.HTTPProxy.RemoteBind == "" || cfg.Agent.HTTPProxy.Listen == "" {
+		return ""
+	}
+	return cfg.Agent.HTTPProxy.RemoteBind + ":" + cfg.Agent.HTTPProxy.Listen
+}
+
+// validateRemoteForwards parses every remote forward, including the HTTP
+// proxy's, and rejects two forwards that would bind the same server address.
+func validateRemoteForwards(forwards []string, proxyForward string) error {
+	parsed := make([]forward.Forward, 0, len(forwards)+1)
+	for _, value := range forwards {
+		f, err := parseForward(value)
+		if err != nil {
+			return fmt.Errorf("ssh.remote_forwards: %w", err)
+		}
+		parsed = append(parsed, f)
+	}
+	if proxyForward != "" {
+		f, err := forward.Parse(proxyForward)
+		if err != nil {
+			return fmt.Errorf("agent.http_proxy: %w", err)
+		}
+		if err := f.MustBeStatic(); err != nil {
+			return fmt.Errorf("agent.http_proxy: %w", err)
+		}
+		parsed = append(parsed, f)
+	}
+	if a, b, ok := forward.FindConflict(parsed); ok {
+		return fmt.Errorf("ssh.remote_forwards: %q and %q bind the same address", a.Spec, b.Spec)
+	}
+	return nil
+}
+
+// validateClientForwards checks local and dynamic forwards together because
+// both bind on this machine.
+func validateClientForwards(local, dynamic []string) error {
+	parsed := make([]forward.Forward, 0, len(local)+len(dynamic))
+	for _, value := range local {
+		f, err := parseForward(value)
+		if err == nil {
+			err = f.MustBeStatic()
+		}
+		if err == nil && !f.Bind.IsSocket() && f.Bind.Port == 0 {
+			err = fmt.Errorf("invalid forward %q: bind port must be 1-65535", f.Spec)
+		}
+		if err != nil {
+			return fmt.Errorf("client.local_forwards: %w", err)
+		}
+		parsed = append(parsed, f)
+	}
+	for _, value := range dynamic {
+		f, err := parseForward(value)
+		if err == nil && !f.Dynamic {
+			err = fmt.Errorf("invalid forward %q: dynamic forwards take no destination", f.Spec)
+		}
+		if err == nil && f.Bind.Port == 0 {
+			err = fmt.Errorf("invalid forward %q: bind port must be 1-65535", f.Spec)
+		}
+		if err != nil {
+			return fmt.Errorf("client.dynamic_forwards: %w", err)
+		}
+		parsed = append(parsed, f)
+	}
+	if a, b, ok := forward.FindConflict(parsed); ok {
+		return fmt.Errorf("client forwards %q and %q bind the same address", a.Spec, b.Spec)
+	}
+	return nil
+}
+
+// parseForward is forward.Parse, except that a range left unexpanded reports
+// why the range is invalid.
+func parseForward(value string) (forward.Forward, error) {
+	if forward.IsRange(value) {
+		if _, err := forward.Expand(value); err != nil {
+			return forward.Forward{}, err
+		}
+	}
+	return forward.Parse(value)
+}
+
+func validateSOCKSAllow(entries []string) error {
+	for i, entry := range entries {
+		if err := socks.ParseAllowRule(entry); err != nil {
+			return fmt.Errorf("agent.socks_allow[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func validateRelays(relays []RelayConfig, forwards []string, socksAllow bool) error {
 	seen := make(map[string]struct{})
-	add := func(value string) {
+	for i, rc := range relays {
+		value := strings.TrimSpace(rc.Forward)
+		if value == "" {
+			return fmt.Errorf("agent.relays[%d].forward is required", i)
+		}
+		if !containsString(forwards, value) {
+			return fmt.Errorf("agent.relays[%d].forward %q does not match a remote forward", i, value)
+		}
+		if _, ok := seen[value]; ok {
+			return fmt.Errorf("agent.relays[%d].forward %q is duplicated", i, value)
+		}
+		seen[value] = struct{}{}
+		f, err := forward.Parse(value)
+		if err != nil {
+			return fmt.Errorf("agent.relays[%d]: %w", i, err)
+		}
+		if f.Dynamic && !socksAllow {
+			return fmt.Errorf("agent.relays[%d]: reverse dynamic forward %q is only relayed with agent.socks_allow", i, value)
+		}
+		if rc.MaxConnections < 0 || rc.ConnectionsPerMin < 0 || rc.BandwidthKbps < 0 {
+			return fmt.Errorf("agent.relays[%d]: limits must be >= 0", i)
+		}
+	}
+	return nil
+}
+
+func validateManagedForwards(managed, forwards []string) error {
+	for _, value := range managed {
 		trimmed := strings.TrimSpace(value)
 		if trimmed == "" {
-			return
+			continue
 		}
-		if _, ok := seen[trimmed]; ok {
-			return
+		if !containsString(forwards, trimmed) {
+			return fmt.Errorf("client.managed_forwards entry %q does not match a local forward", trimmed)
+		}
+		f, err := forward.Parse(trimmed)
+		if err != nil {
+			return fmt.Errorf("client.managed_forwards: %w", err)
+		}
+		if f.Bind.IsSocket() || f.Target.IsSocket() {
+			return fmt.Errorf("client.managed_forwards entry %q: unix socket forwards cannot be managed", trimmed)
 		}
-		seen[trimmed] = struct{}{}
-		out = append(out, trimmed)
 	}
-	for _, value := range cfg.SSH.RemoteForwards {
-		add(value)
+	return nil
+}
+
+func validateSOCKSUsers(users []SOCKSUser) error {
+	seen := make(map[string]struct{})
+	for i, u := range users {
+		if strings.TrimSpace(u.Username) == "" {
+			return fmt.Errorf("client.socks_users[%d].username is required", i)
+		}
+		if _, ok := seen[u.Username]; ok {
+			return fmt.Errorf("client.socks_users[%d]: duplicate username %s", i, u.Username)
+		}
+		seen[u.Username] = struct{}{}
+		if !strings.HasPrefix(u.PasswordHash, "$2") {
+			return fmt.Errorf("client.socks_users[%d].password_hash must be a bcrypt hash", i)
+		}
 	}
-	return out
+	return nil
 }
 
-func NormalizeLocalForwards(cfg *Config) []string {
+// DynamicForwardNeedsAuth reports whether rpa fronts forward with SOCKS
+// username/password instead of handing the bind to ssh directly.
+func DynamicForwardNeedsAuth(cfg *Config, value string) bool {
+	if cfg == nil || len(cfg.Client.SOCKSUsers) == 0 {
+		return false
+	}
+	f, err := forward.Parse(value)
+	return err == nil && f.Dynamic && !f.IsLoopback()
+}
+
+// IsManagedForward reports whether rpa owns the local listener for forward.
+func IsManagedForward(cfg *Config, forward string) bool {
 	if cfg == nil {
-		return nil
+		return false
 	}
-	out := make([]string, 0, len(cfg.Client.LocalForwards))
-	seen := make(map[string]struct{})
-	add := func(value string) {
+	return containsString(cfg.Client.ManagedForwards, strings.TrimSpace(forward))
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if strings.TrimSpace(item) == value {
+			return true
+		}
+	}
+	return false
+}
+
+func validatePeriodic(maxDefer, jitter int, quietHours []string, label string) error {
+	if maxDefer < 0 {
+		return fmt.Errorf("%s.periodic_restart_max_defer_sec must be >= 0", label)
+	}
+	if jitter < 0 {
+		return fmt.Errorf("%s.periodic_restart_jitter_sec must be >= 0", label)
+	}
+	if _, err := ParseQuietHours(quietHours); err != nil {
+		return fmt.Errorf("%s.periodic_restart_quiet_hours: %w", label, err)
+	}
+	return nil
+}
+
+// QuietWindow is a daily local-time window in minutes since midnight.
+// End may be smaller than Start for windows that cross midnight.
+type QuietWindow struct {
+	Start int
+	End   int
+}
+
+func (w QuietWindow) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.Start <= w.End {
+		return minute >= w.Start && minute < w.End
+	}
+	return minute >= w.Start || minute < w.End
+}
+
+// ParseQuietHours parses "HH:MM-HH:MM" entries such as "22:00-07:00".
+func ParseQuietHours(values []string) ([]QuietWindow, error) {
+	out := make([]QuietWindow, 0, len(values))
+	for _, value := range values {
 		trimmed := strings.TrimSpace(value)
 		if trimmed == "" {
-			return
+			continue
 		}
-		if _, ok := seen[trimmed]; ok {
-			return
+		startRaw, endRaw, ok := strings.Cut(trimmed, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid window %q (want HH:MM-HH:MM)", value)
+		}
+		start, err := parseClock(startRaw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q: %w", value, err)
+		}
+		end, err := parseClock(endRaw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q: %w", value, err)
 		}
-		seen[trimmed] = struct{}{}
-		out = append(out, trimmed)
+		if start == end {
+			return nil, fmt.Errorf("invalid window %q: start equals end", value)
+		}
+		out = append(out, QuietWindow{Start: start, End: end})
 	}
-	for _, value := range cfg.Client.LocalForwards {
-		add(value)
+	return out, nil
+}
+
+// QuietHoursFunc reports whether a time falls in one of values' windows,
+// or is nil when there are none.
+func QuietHoursFunc(values []string) func(time.Time) bool {
+	windows, err := ParseQuietHours(values)
+	if err != nil || len(windows) == 0 {
+		return nil
+	}
+	return func(t time.Time) bool {
+		for _, w := range windows {
+			if w.Contains(t) {
+				return true
+			}
+		}
+		return false
 	}
-	return out
 }
 
-func SetRemoteForwards(cfg *Config, forwards []string) {
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("bad time %q", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func validateProbes(probes []ProbeConfig, forwards []string, label string) error {
+	known := make(map[string]struct{}, len(forwards))
+	for _, forward := range forwards {
+		known[forward] = struct{}{}
+	}
+	for i, p := range probes {
+		forward := strings.TrimSpace(p.Forward)
+		if forward == "" {
+			return fmt.Errorf("%s.probes[%d].forward is required", label, i)
+		}
+		if _, ok := known[forward]; !ok {
+			return fmt.Errorf("%s.probes[%d].forward %q does not match a configured forward", label, i, forward)
+		}
+		switch p.Type {
+		case "tcp", "banner", "http", "redis", "postgres":
+		default:
+			return fmt.Errorf("%s.probes[%d].type must be tcp, banner, http, redis or postgres (got %q)", label, i, p.Type)
+		}
+		if p.IntervalSec < 0 || p.TimeoutMs < 0 || p.FailureThreshold < 0 {
+			return fmt.Errorf("%s.probes[%d] interval, timeout and failure threshold must be >= 0", label, i)
+		}
+	}
+	return nil
+}
+
+func NormalizeRemoteForwards(cfg *Config) []string {
+	if cfg == nil {
+		return nil
+	}
+	return enabledSpecs(cfg.SSH.RemoteForwards)
+}
+
+func NormalizeLocalForwards(cfg *Config) []string {
+	if cfg == nil {
+		return nil
+	}
+	return enabledSpecs(cfg.Client.LocalForwards)
+}
+
+func NormalizeDynamicForwards(cfg *Config) []string {
+	if cfg == nil {
+		return nil
+	}
+	return dedupeForwards(cfg.Client.DynamicForwards)
+}
+
+func SetDynamicForwards(cfg *Config, forwards []string) {
 	if cfg == nil {
 		return
 	}
-	trimmed := make([]string, 0, len(forwards))
+	cfg.Client.DynamicForwards = dedupeForwards(forwards)
+	cfg.Client.rememberRanges(forwards)
+}
+
+// dedupeForwards trims and drops empty entries and entries equivalent to an
+// earlier one (e.g. `localhost` vs `127.0.0.1`), keeping the first spelling.
+// Port ranges are expanded; unparsable entries are kept for validation to report.
+func dedupeForwards(values []string) []string {
+	out := make([]string, 0, len(values))
 	seen := make(map[string]struct{})
-	for _, value := range forwards {
-		val := strings.TrimSpace(value)
-		if val == "" {
-			continue
-		}
-		if _, ok := seen[val]; ok {
-			continue
+	for _, value := range values {
+		for _, val := range ExpandForward(value) {
+			if val == "" {
+				continue
+			}
+			key := ForwardKey(val)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			out = append(out, val)
 		}
-		seen[val] = struct{}{}
-		trimmed = append(trimmed, val)
 	}
-	cfg.SSH.RemoteForwards = append([]string(nil), trimmed...)
+	return out
+}
+
+// ExpandForward returns one spec per port of a port-range spec, or the
+// trimmed spec itself when it is not a valid range.
+func ExpandForward(value string) []string {
+	trimmed := strings.TrimSpace(value)
+	if !forward.IsRange(trimmed) {
+		return []string{trimmed}
+	}
+	expanded, err := forward.Expand(trimmed)
+	if err != nil {
+		return []string{trimmed}
+	}
+	out := make([]string, len(expanded))
+	for i, f := range expanded {
+		out[i] = f.Spec
+	}
+	return out
+}
+
+// ForwardKey returns the canonical form of a forward spec for comparisons,
+// or the trimmed spec when it does not parse.
+func ForwardKey(value string) string {
+	f, err := forward.Parse(value)
+	if err != nil {
+		return strings.TrimSpace(value)
+	}
+	return f.Key()
+}
+
+// ForwardKeys returns the canonical keys of every forward value expands to,
+// so a range can be removed as a unit.
+func ForwardKeys(value string) map[string]struct{} {
+	out := make(map[string]struct{})
+	for _, member := range ExpandForward(value) {
+		out[ForwardKey(member)] = struct{}{}
+	}
+	return out
+}
+
+func keepSet(values []string) map[string]struct{} {
+	out := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		out[value] = struct{}{}
+	}
+	return out
+}
+
+func SetRemoteForwards(cfg *Config, forwards []string) {
+	if cfg == nil {
+		return
+	}
+	cfg.SSH.RemoteForwards = mergeForwardEntries(cfg.SSH.RemoteForwards, forwards)
+	kept := keepSet(allSpecs(cfg.SSH.RemoteForwards))
+	cfg.Agent.Probes = pruneProbes(cfg.Agent.Probes, kept)
+	cfg.Agent.Relays = pruneRelays(cfg.Agent.Relays, kept)
 }
 
 func SetLocalForwards(cfg *Config, forwards []string) {
 	if cfg == nil {
 		return
 	}
-	trimmed := make([]string, 0, len(forwards))
-	seen := make(map[string]struct{})
-	for _, value := range forwards {
-		val := strings.TrimSpace(value)
-		if val == "" {
-			continue
+	cfg.Client.LocalForwards = mergeForwardEntries(cfg.Client.LocalForwards, forwards)
+	kept := keepSet(allSpecs(cfg.Client.LocalForwards))
+	cfg.Client.Probes = pruneProbes(cfg.Client.Probes, kept)
+	cfg.Client.ManagedForwards = pruneStrings(cfg.Client.ManagedForwards, kept)
+}
+
+func pruneStrings(values []string, keep map[string]struct{}) []string {
+	if len(values) == 0 {
+		return values
+	}
+	out := make([]string, 0, len(values))
+	for _, value := range values {
+		if _, ok := keep[strings.TrimSpace(value)]; ok {
+			out = append(out, value)
 		}
-		if _, ok := seen[val]; ok {
-			continue
+	}
+	return out
+}
+
+// pruneProbes drops probes whose forward is no longer configured so that
+// removing a forward never leaves the config invalid.
+func pruneProbes(probes []ProbeConfig, forwards map[string]struct{}) []ProbeConfig {
+	if len(probes) == 0 {
+		return probes
+	}
+	out := make([]ProbeConfig, 0, len(probes))
+	for _, p := range probes {
+		if _, ok := forwards[strings.TrimSpace(p.Forward)]; ok {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func pruneRelays(relays []RelayConfig, forwards map[string]struct{}) []RelayConfig {
+	if len(relays) == 0 {
+		return relays
+	}
+	out := make([]RelayConfig, 0, len(relays))
+	for _, rc := range relays {
+		if _, ok := forwards[strings.TrimSpace(rc.Forward)]; ok {
+			out = append(out, rc)
 		}
-		seen[val] = struct{}{}
-		trimmed = append(trimmed, val)
 	}
-	cfg.Client.LocalForwards = append([]string(nil), trimmed...)
+	return out
 }
 
-func mergeLocalForwards(single string, list []string) []string {
-	out := make([]string, 0, len(list)+1)
+func mergeLocalForwards(single string, list []ForwardEntry) []ForwardEntry {
+	out := make([]ForwardEntry, 0, len(list)+1)
 	if strings.TrimSpace(single) != "" {
-		out = append(out, single)
+		out = append(out, ForwardEntry{Spec: single})
 	}
 	out = append(out, list...)
 	return out
@@ -426,7 +1187,7 @@ func Save(path string, cfg *Config) error {
 	if strings.TrimSpace(path) == "" {
 		return errors.New("config path is empty")
 	}
-	data, err := yaml.Marshal(cfg)
+	data, err := yaml.Marshal(withRanges(withoutAliasValues(cfg)))
 	if err != nil {
 		return fmt.Errorf("marshal config: %w", err)
 	}
@@ -464,6 +1225,18 @@ func ClientSocketPath(cfg *Config) (string, error) {
 	return filepath.Join(home, ".rpa", "client.sock"), nil
 }
 
+// ClientControlPath is the ControlMaster socket used to multiplex managed forwards.
+func ClientControlPath(cfg *Config) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("resolve home dir: %w", err)
+	}
+	if cfg == nil {
+		return "", errors.New("config is nil")
+	}
+	return filepath.Join(home, ".rpa", "client.ctl"), nil
+}
+
 func LogPath(cfg *Config) (string, error) {
 	if cfg == nil {
 		return "", errors.New("config is nil")
@@ -471,6 +1244,35 @@ func LogPath(cfg *Config) (string, error) {
 	return expandHome(cfg.Logging.Path)
 }
 
+func AuditPath(cfg *Config) (string, error) {
+	if cfg == nil {
+		return "", errors.New("config is nil")
+	}
+	return expandHome(cfg.Agent.AuditPath)
+}
+
+// KeysDir is where `rpa keys generate` puts dedicated tunnel identities.
+func KeysDir() (string, error) {
+	return expandHome("~/.rpa/keys")
+}
+
+// ServerSnippetPath is where `rpa server-snippet --save` keeps the last
+// snippet for mode (agent or client).
+func ServerSnippetPath(mode string) (string, error) {
+	return expandHome("~/.rpa/server-snippet-" + mode + ".txt")
+}
+
+// CADir is where the rpa local CA and its issued certificates live.
+func CADir(cfg *Config) (string, error) {
+	if cfg == nil {
+		return "", errors.New("config is nil")
+	}
+	if cfg.Agent.HTTPProxy.TLS.CADir == "" {
+		return expandHome("~/.rpa/ca")
+	}
+	return expandHome(cfg.Agent.HTTPProxy.TLS.CADir)
+}
+
 func ClientLogPath(cfg *Config) (string, error) {
 	if cfg == nil {
 		return "", errors.New("config is nil")
@@ -516,3 +1318,13 @@ func expandHome(path string) (string, error) {
 	}
 	return filepath.Join(home, strings.TrimPrefix(path, "~/")), nil
 }
+
+// HasSocketBind reports whether any forward makes ssh create a Unix socket.
+func HasSocketBind(forwards []string) bool {
+	for _, value := range forwards {
+		if f, err := forward.Parse(value); err == nil && f.Bind.IsSocket() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,281 @@
+// Package config models forward entries: a spec plus optional name, owner, tags and an enable flag.
+// Plain string entries stay strings on save, so configs written before structured entries keep their shape.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"reverse-proxy-agent/pkg/forward"
+)
+
+// ForwardEntry is one configured forward. In YAML it is either the spec
+// string or a mapping with the fields below.
+type ForwardEntry struct {
+	Name        string     `yaml:"name,omitempty" json:"name,omitempty"`
+	Spec        string     `yaml:"spec" json:"spec"`
+	Description string     `yaml:"description,omitempty" json:"description,omitempty"`
+	Owner       string     `yaml:"owner,omitempty" json:"owner,omitempty"`
+	Tags        []string   `yaml:"tags,omitempty" json:"tags,omitempty"`
+	Enabled     *bool      `yaml:"enabled,omitempty" json:"enabled,omitempty"`
+	CreatedAt   *time.Time `yaml:"created_at,omitempty" json:"created_at,omitempty"`
+	// ExpiresAt makes the forward temporary; the agent removes it once past.
+	ExpiresAt *time.Time `yaml:"expires_at,omitempty" json:"expires_at,omitempty"`
+	// Pooled marks a bind port allocated from agent.port_pool by `rpa share`.
+	Pooled bool `yaml:"pooled,omitempty" json:"pooled,omitempty"`
+
+	// group is the port-range entry this one was expanded from.
+	group *ForwardEntry
+}
+
+type forwardEntryFields ForwardEntry
+
+func (e *ForwardEntry) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*e = ForwardEntry{}
+		return value.Decode(&e.Spec)
+	}
+	var fields forwardEntryFields
+	if err := value.Decode(&fields); err != nil {
+		return err
+	}
+	*e = ForwardEntry(fields)
+	return nil
+}
+
+func (e ForwardEntry) MarshalYAML() (any, error) {
+	if e.plain() {
+		return e.Spec, nil
+	}
+	return forwardEntryFields(e), nil
+}
+
+// UnmarshalJSON accepts the spec string as well, so imports can mix forms.
+func (e *ForwardEntry) UnmarshalJSON(data []byte) error {
+	if strings.HasPrefix(strings.TrimSpace(string(data)), `"`) {
+		*e = ForwardEntry{}
+		return json.Unmarshal(data, &e.Spec)
+	}
+	var fields forwardEntryFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	*e = ForwardEntry(fields)
+	return nil
+}
+
+func (e ForwardEntry) plain() bool {
+	return e.Name == "" && e.Description == "" && e.Owner == "" && len(e.Tags) == 0 && e.Enabled == nil && e.CreatedAt == nil && e.ExpiresAt == nil && !e.Pooled
+}
+
+func (e ForwardEntry) IsEnabled() bool {
+	return e.Enabled == nil || *e.Enabled
+}
+
+func (e ForwardEntry) Expired(now time.Time) bool {
+	return e.ExpiresAt != nil && !now.Before(*e.ExpiresAt)
+}
+
+// Label is the name when set, otherwise the spec.
+func (e ForwardEntry) Label() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return strings.TrimSpace(e.Spec)
+}
+
+// enabledSpecs returns the specs of enabled, unexpired entries, deduplicated.
+func enabledSpecs(entries []ForwardEntry) []string {
+	now := time.Now()
+	specs := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.IsEnabled() && !e.Expired(now) {
+			specs = append(specs, e.Spec)
+		}
+	}
+	return dedupeForwards(specs)
+}
+
+func allSpecs(entries []ForwardEntry) []string {
+	specs := make([]string, 0, len(entries))
+	for _, e := range entries {
+		specs = append(specs, strings.TrimSpace(e.Spec))
+	}
+	return specs
+}
+
+// mergeForwardEntries makes specs the enabled forwards. Entries that stay keep
+// their metadata and position, disabled entries are left alone and new specs
+// are appended as plain entries.
+func mergeForwardEntries(entries []ForwardEntry, specs []string) []ForwardEntry {
+	wanted := dedupeForwards(specs)
+	index := make(map[string]int, len(wanted))
+	for i, spec := range wanted {
+		index[ForwardKey(spec)] = i
+	}
+	used := make([]bool, len(wanted))
+	out := make([]ForwardEntry, 0, len(entries)+len(wanted))
+	for _, e := range entries {
+		if !e.IsEnabled() {
+			out = append(out, e)
+			continue
+		}
+		i, ok := index[ForwardKey(e.Spec)]
+		if !ok || used[i] {
+			continue
+		}
+		used[i] = true
+		out = append(out, e)
+	}
+	for i, spec := range wanted {
+		if !used[i] {
+			out = append(out, ForwardEntry{Spec: spec})
+		}
+	}
+	return out
+}
+
+// FindForwardEntry returns the index of the entry named ref, or whose spec
+// is equivalent to ref, or -1.
+func FindForwardEntry(entries []ForwardEntry, ref string) int {
+	ref = strings.TrimSpace(ref)
+	for i, e := range entries {
+		if e.Name != "" && e.Name == ref {
+			return i
+		}
+	}
+	key := ForwardKey(ref)
+	for i, e := range entries {
+		if ForwardKey(e.Spec) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// UpsertForwardEntry adds entry, expanding a port range, or fills in the
+// metadata of the equivalent existing entry and takes its enabled state, so
+// adding a disabled forward enables it again.
+func UpsertForwardEntry(entries []ForwardEntry, entry ForwardEntry) []ForwardEntry {
+	for _, member := range expandEntry(entry) {
+		i := FindForwardEntry(entries, member.Spec)
+		if i < 0 {
+			entries = append(entries, member)
+			continue
+		}
+		existing := &entries[i]
+		if member.Name != "" {
+			existing.Name = member.Name
+		}
+		if member.Description != "" {
+			existing.Description = member.Description
+		}
+		if member.Owner != "" {
+			existing.Owner = member.Owner
+		}
+		if len(member.Tags) > 0 {
+			existing.Tags = member.Tags
+		}
+		existing.Enabled = member.Enabled
+		if existing.CreatedAt == nil {
+			existing.CreatedAt = member.CreatedAt
+		}
+		if member.ExpiresAt != nil {
+			existing.ExpiresAt = member.ExpiresAt
+		}
+		if member.Pooled {
+			existing.Pooled = true
+		}
+	}
+	return entries
+}
+
+// ForwardLabel returns the label of the entry for spec, or spec itself.
+func ForwardLabel(entries []ForwardEntry, spec string) string {
+	key := ForwardKey(spec)
+	for _, e := range entries {
+		if ForwardKey(e.Spec) == key {
+			return e.Label()
+		}
+	}
+	return spec
+}
+
+// expandEntry splits a port-range entry into one entry per port; named
+// members are suffixed with their bind port and remember the range entry.
+func expandEntry(e ForwardEntry) []ForwardEntry {
+	specs := ExpandForward(e.Spec)
+	if len(specs) == 1 {
+		e.Spec = specs[0]
+		return []ForwardEntry{e}
+	}
+	group := e
+	group.Spec = strings.TrimSpace(e.Spec)
+	out := make([]ForwardEntry, 0, len(specs))
+	for _, spec := range specs {
+		member := e
+		member.Spec = spec
+		member.group = &group
+		if e.Name != "" {
+			if f, err := forward.Parse(spec); err == nil {
+				member.Name = e.Name + "-" + strconv.Itoa(f.Bind.Port)
+			}
+		}
+		out = append(out, member)
+	}
+	return out
+}
+
+func expandEntries(entries []ForwardEntry) []ForwardEntry {
+	if len(entries) == 0 {
+		return entries
+	}
+	out := make([]ForwardEntry, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, expandEntry(e)...)
+	}
+	return out
+}
+
+// validateForwardEntries checks names and the specs of disabled entries;
+// enabled specs are validated with the other forwards.
+func validateForwardEntries(entries []ForwardEntry, label string) error {
+	names := make(map[string]struct{})
+	for i, e := range entries {
+		if strings.TrimSpace(e.Spec) == "" {
+			return fmt.Errorf("%s[%d].spec is required", label, i)
+		}
+		if e.Name != "" {
+			if !validForwardName(e.Name) {
+				return fmt.Errorf("%s[%d].name %q may only contain letters, digits, '.', '_' and '-'", label, i, e.Name)
+			}
+			if _, ok := names[e.Name]; ok {
+				return fmt.Errorf("%s[%d].name %q is duplicated", label, i, e.Name)
+			}
+			names[e.Name] = struct{}{}
+		}
+		if !e.IsEnabled() {
+			if _, err := parseForward(e.Spec); err != nil {
+				return fmt.Errorf("%s[%d]: %w", label, i, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validForwardName(name string) bool {
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
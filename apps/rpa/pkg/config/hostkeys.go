@@ -0,0 +1,56 @@
+// Package config handles pinned server host keys: ssh.host_key_fingerprints and ssh.host_key_ca.
+// Pinned configs use rpa's own known_hosts file so accept-new never applies to them.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"reverse-proxy-agent/pkg/hostkey"
+)
+
+// HostKeysPinned reports whether ssh must verify the server strictly against
+// pinned fingerprints or a host CA.
+func HostKeysPinned(cfg *Config) bool {
+	return len(cfg.SSH.HostKeyFingerprints) > 0 || strings.TrimSpace(cfg.SSH.HostKeyCA) != ""
+}
+
+// KnownHostsPath is the known_hosts file rpa maintains for pinned keys.
+func KnownHostsPath(cfg *Config) (string, error) {
+	if cfg == nil {
+		return "", errors.New("config is nil")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("resolve home dir: %w", err)
+	}
+	return filepath.Join(home, ".rpa", "known_hosts"), nil
+}
+
+func validateHostKeys(cfg *Config) error {
+	for i, fp := range cfg.SSH.HostKeyFingerprints {
+		if !hostkey.ValidFingerprint(fp) {
+			return fmt.Errorf("ssh.host_key_fingerprints[%d] must be a SHA256 fingerprint like SHA256:abc... (got %q)", i, fp)
+		}
+	}
+	if ca := strings.TrimSpace(cfg.SSH.HostKeyCA); ca != "" {
+		if _, err := hostkey.ParseCA(ca); err != nil {
+			return fmt.Errorf("ssh.host_key_ca: %w", err)
+		}
+	}
+	for _, opt := range cfg.SSH.Options {
+		if !HostKeysPinned(cfg) {
+			break
+		}
+		key, _, _ := strings.Cut(strings.TrimSpace(opt), "=")
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "userknownhostsfile", "globalknownhostsfile":
+			return fmt.Errorf("ssh.options %q conflicts with pinned host keys, which use rpa's known_hosts", opt)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+// Package config models ssh.jump_hosts, the bastions ssh passes through before ssh.host.
+// Hops are listed in connection order; the first one must be reachable from this machine.
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// JumpHost is one hop of ssh.jump_hosts. Port 0 means 22.
+type JumpHost struct {
+	User         string   `yaml:"user,omitempty"`
+	Host         string   `yaml:"host"`
+	Port         int      `yaml:"port,omitempty"`
+	IdentityFile string   `yaml:"identity_file,omitempty"`
+	Options      []string `yaml:"options,omitempty"`
+}
+
+func (h JumpHost) SSHPort() int {
+	if h.Port > 0 {
+		return h.Port
+	}
+	return 22
+}
+
+// Addr is host:port for dialing the hop.
+func (h JumpHost) Addr() string {
+	return net.JoinHostPort(strings.TrimSpace(h.Host), strconv.Itoa(h.SSHPort()))
+}
+
+// Spec renders the hop as an ssh -J element, [user@]host[:port].
+func (h JumpHost) Spec() string {
+	host := strings.TrimSpace(h.Host)
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if h.Port > 0 {
+		host += ":" + strconv.Itoa(h.Port)
+	}
+	if h.User != "" {
+		host = h.User + "@" + host
+	}
+	return host
+}
+
+// JumpChain renders the hops for display, e.g. `me@bastion:2222,edge`.
+func JumpChain(hops []JumpHost) string {
+	specs := make([]string, 0, len(hops))
+	for _, h := range hops {
+		specs = append(specs, h.Spec())
+	}
+	return strings.Join(specs, ",")
+}
+
+// SSHDialAddr is the first address ssh connects to: the first jump host, or
+// ssh.host when there are none.
+func SSHDialAddr(cfg *Config) string {
+	if len(cfg.SSH.JumpHosts) > 0 {
+		return cfg.SSH.JumpHosts[0].Addr()
+	}
+	return net.JoinHostPort(cfg.SSH.Host, strconv.Itoa(cfg.SSH.Port))
+}
+
+func validateJumpHosts(cfg *Config) error {
+	if len(cfg.SSH.JumpHosts) == 0 {
+		return nil
+	}
+	for _, opt := range cfg.SSH.Options {
+		key, _, _ := strings.Cut(strings.TrimSpace(opt), "=")
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "proxyjump", "proxycommand":
+			return fmt.Errorf("ssh.options %q conflicts with ssh.jump_hosts", opt)
+		}
+	}
+	for i, h := range cfg.SSH.JumpHosts {
+		if strings.TrimSpace(h.Host) == "" {
+			return fmt.Errorf("ssh.jump_hosts[%d].host is required", i)
+		}
+		if strings.ContainsAny(h.Host, " ,@") || strings.ContainsAny(h.User, " ,@:") {
+			return fmt.Errorf("ssh.jump_hosts[%d]: invalid user or host %q", i, h.Spec())
+		}
+		if h.Port < 0 || h.Port > 65535 {
+			return fmt.Errorf("ssh.jump_hosts[%d].port must be 0-65535 (got %d)", i, h.Port)
+		}
+		for _, opt := range h.Options {
+			if !strings.Contains(opt, "=") {
+				return fmt.Errorf("ssh.jump_hosts[%d].options: %q must be Key=Value", i, opt)
+			}
+		}
+	}
+	return nil
+}
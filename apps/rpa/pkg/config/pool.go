@@ -0,0 +1,80 @@
+// Package config allocates server ports for `rpa share` from agent.port_pool.
+// Allocations are the remote forward entries marked pooled; removing the entry releases the port.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"reverse-proxy-agent/pkg/forward"
+)
+
+// AllocatePoolPort returns the lowest pool port not bound by any remote
+// forward, including disabled ones and the HTTP proxy forward.
+func AllocatePoolPort(cfg *Config) (int, error) {
+	lo, hi, err := portPool(cfg)
+	if err != nil {
+		return 0, err
+	}
+	used := remoteBindPorts(cfg)
+	for port := lo; port <= hi; port++ {
+		if _, ok := used[port]; !ok {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("agent.port_pool %s is exhausted", strings.TrimSpace(cfg.Agent.PortPool))
+}
+
+// PoolUsage returns how many pool ports are bound and the pool size.
+func PoolUsage(cfg *Config) (int, int, error) {
+	lo, hi, err := portPool(cfg)
+	if err != nil {
+		return 0, 0, err
+	}
+	used := 0
+	for port := range remoteBindPorts(cfg) {
+		if port >= lo && port <= hi {
+			used++
+		}
+	}
+	return used, hi - lo + 1, nil
+}
+
+// PooledForwards returns the remote forward entries allocated by `rpa share`.
+func PooledForwards(cfg *Config) []ForwardEntry {
+	var out []ForwardEntry
+	for _, e := range cfg.SSH.RemoteForwards {
+		if e.Pooled {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func portPool(cfg *Config) (int, int, error) {
+	value := strings.TrimSpace(cfg.Agent.PortPool)
+	if value == "" {
+		return 0, 0, errors.New("agent.port_pool is not configured")
+	}
+	lo, hi, err := forward.ParsePortRange(value)
+	if err != nil {
+		return 0, 0, fmt.Errorf("agent.port_pool: %w", err)
+	}
+	return lo, hi, nil
+}
+
+func remoteBindPorts(cfg *Config) map[int]struct{} {
+	used := make(map[int]struct{})
+	specs := allSpecs(cfg.SSH.RemoteForwards)
+	if proxy := HTTPProxyForward(cfg); proxy != "" {
+		specs = append(specs, proxy)
+	}
+	for _, spec := range specs {
+		if f, err := forward.Parse(spec); err == nil && !f.Bind.IsSocket() {
+			used[f.Bind.Port] = struct{}{}
+		}
+	}
+	return used
+}
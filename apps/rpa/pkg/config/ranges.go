@@ -0,0 +1,187 @@
+// Package config expands port-range forwards into one forward per port for the runtime.
+// Save writes a range back as the single entry it was written as, as long as all of its ports are unchanged.
+
+package config
+
+import (
+	"reflect"
+	"strings"
+
+	"reverse-proxy-agent/pkg/forward"
+)
+
+// expandRanges turns port-range specs into individual forwards as the config
+// is loaded, including the managed forwards and relays that name them.
+func expandRanges(cfg *Config) {
+	cfg.SSH.RemoteForwards = expandEntries(cfg.SSH.RemoteForwards)
+	cfg.Client.LocalForwards = expandEntries(cfg.Client.LocalForwards)
+	cfg.Client.rememberRanges(cfg.Client.DynamicForwards)
+	cfg.Client.rememberRanges(cfg.Client.ManagedForwards)
+	cfg.Client.DynamicForwards = expandStrings(cfg.Client.DynamicForwards)
+	cfg.Client.ManagedForwards = expandStrings(cfg.Client.ManagedForwards)
+	if len(cfg.Agent.Relays) == 0 {
+		return
+	}
+	relays := make([]RelayConfig, 0, len(cfg.Agent.Relays))
+	for _, rc := range cfg.Agent.Relays {
+		specs := ExpandForward(rc.Forward)
+		if len(specs) == 1 {
+			relays = append(relays, rc)
+			continue
+		}
+		group := rc
+		group.Forward = strings.TrimSpace(rc.Forward)
+		for _, value := range specs {
+			member := rc
+			member.Forward = value
+			member.group = &group
+			relays = append(relays, member)
+		}
+	}
+	cfg.Agent.Relays = relays
+}
+
+func expandStrings(values []string) []string {
+	if len(values) == 0 {
+		return values
+	}
+	out := make([]string, 0, len(values))
+	for _, value := range values {
+		out = append(out, ExpandForward(value)...)
+	}
+	return out
+}
+
+func (c *ClientConfig) rememberRanges(values []string) {
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if forward.IsRange(value) && !containsString(c.ranges, value) {
+			c.ranges = append(c.ranges, value)
+		}
+	}
+}
+
+// withRanges returns cfg with every range whose ports are all still present
+// and unchanged written as the original range entry, in place of its first
+// port. A range that lost or changed a port is saved port by port.
+func withRanges(cfg *Config) *Config {
+	out := *cfg
+	out.SSH.RemoteForwards = collapseEntries(cfg.SSH.RemoteForwards)
+	out.Client.LocalForwards = collapseEntries(cfg.Client.LocalForwards)
+	out.Client.DynamicForwards = collapseStrings(cfg.Client.DynamicForwards, cfg.Client.ranges)
+	out.Client.ManagedForwards = collapseStrings(cfg.Client.ManagedForwards, cfg.Client.ranges)
+	out.Agent.Relays = collapseRelays(cfg.Agent.Relays)
+	return &out
+}
+
+func collapseEntries(entries []ForwardEntry) []ForwardEntry {
+	intact := make(map[*ForwardEntry]bool)
+	for _, e := range entries {
+		if e.group == nil {
+			continue
+		}
+		if _, ok := intact[e.group]; ok {
+			continue
+		}
+		var members []ForwardEntry
+		for _, other := range entries {
+			if other.group == e.group {
+				members = append(members, other)
+			}
+		}
+		intact[e.group] = reflect.DeepEqual(members, expandEntry(*e.group))
+	}
+	return collapse(entries, func(e ForwardEntry) (*ForwardEntry, ForwardEntry, bool) {
+		if e.group == nil || !intact[e.group] {
+			return nil, e, false
+		}
+		return e.group, *e.group, true
+	})
+}
+
+func collapseRelays(relays []RelayConfig) []RelayConfig {
+	intact := make(map[*RelayConfig]bool)
+	for _, rc := range relays {
+		if rc.group == nil {
+			continue
+		}
+		if _, ok := intact[rc.group]; ok {
+			continue
+		}
+		specs := ExpandForward(rc.group.Forward)
+		n := 0
+		for _, other := range relays {
+			if other.group != rc.group {
+				continue
+			}
+			want := *rc.group
+			want.Forward = other.Forward
+			want.group = rc.group
+			if n >= len(specs) || other != want || other.Forward != specs[n] {
+				n = -1
+				break
+			}
+			n++
+		}
+		intact[rc.group] = n == len(specs)
+	}
+	return collapse(relays, func(rc RelayConfig) (*RelayConfig, RelayConfig, bool) {
+		if rc.group == nil || !intact[rc.group] {
+			return nil, rc, false
+		}
+		return rc.group, *rc.group, true
+	})
+}
+
+func collapseStrings(values, ranges []string) []string {
+	if len(values) == 0 || len(ranges) == 0 {
+		return values
+	}
+	present := keepSet(values)
+	owner := make(map[string]string)
+	for _, r := range ranges {
+		members := ExpandForward(r)
+		if len(members) < 2 {
+			continue
+		}
+		complete := true
+		for _, m := range members {
+			if _, ok := present[m]; !ok {
+				complete = false
+				break
+			}
+		}
+		if !complete {
+			continue
+		}
+		for _, m := range members {
+			if _, ok := owner[m]; !ok {
+				owner[m] = r
+			}
+		}
+	}
+	return collapse(values, func(value string) (string, string, bool) {
+		r, ok := owner[strings.TrimSpace(value)]
+		return r, r, ok
+	})
+}
+
+// collapse replaces each member of a group with the group's value, which is
+// written once at the position of its first member.
+func collapse[T any, K comparable](values []T, group func(T) (K, T, bool)) []T {
+	out := make([]T, 0, len(values))
+	seen := make(map[K]struct{})
+	for _, v := range values {
+		key, g, ok := group(v)
+		if !ok {
+			out = append(out, v)
+			continue
+		}
+		if _, done := seen[key]; done {
+			continue
+		}
+		seen[key] = struct{}{}
+		out = append(out, g)
+	}
+	return out
+}
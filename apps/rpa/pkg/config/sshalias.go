@@ -0,0 +1,153 @@
+// Package config fills unset ssh settings from the ~/.ssh/config entry named by ssh.config_alias.
+// Filled values are only used at runtime; Save leaves them out so ssh_config stays the source of truth.
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"reverse-proxy-agent/pkg/sshconfig"
+)
+
+// aliasValues records what applySSHAlias filled in.
+type aliasValues struct {
+	User         string
+	Host         string
+	Port         int
+	IdentityFile string
+	JumpHosts    []JumpHost
+}
+
+// ResolveSSHAlias fills the empty ssh fields of a config built in code; Load
+// does this itself.
+func ResolveSSHAlias(cfg *Config) error {
+	return applySSHAlias(cfg)
+}
+
+func applySSHAlias(cfg *Config) error {
+	alias := strings.TrimSpace(cfg.SSH.ConfigAlias)
+	if alias == "" {
+		return nil
+	}
+	settings, err := sshconfig.Resolve(alias, sshconfig.DefaultFiles()...)
+	if err != nil {
+		return fmt.Errorf("ssh.config_alias %q: %w", alias, err)
+	}
+	filled := &aliasValues{}
+	if strings.TrimSpace(cfg.SSH.Host) == "" {
+		cfg.SSH.Host = settings.HostName().Value
+		filled.Host = cfg.SSH.Host
+	}
+	if strings.TrimSpace(cfg.SSH.User) == "" {
+		cfg.SSH.User = settings.User().Value
+		filled.User = cfg.SSH.User
+	}
+	if cfg.SSH.Port == 0 {
+		port, _, err := settings.Port()
+		if err != nil {
+			return fmt.Errorf("ssh.config_alias %q: %w", alias, err)
+		}
+		cfg.SSH.Port = port
+		filled.Port = port
+	}
+	if strings.TrimSpace(cfg.SSH.IdentityFile) == "" {
+		if files := settings.IdentityFiles(); len(files) > 0 {
+			cfg.SSH.IdentityFile = files[0].Value
+			filled.IdentityFile = cfg.SSH.IdentityFile
+		}
+	}
+	if len(cfg.SSH.JumpHosts) == 0 {
+		if v, ok := settings.Get("proxyjump"); ok && !strings.EqualFold(v.Value, "none") {
+			hops, err := ParseJumpChain(v.Value)
+			if err != nil {
+				return fmt.Errorf("ssh.config_alias %q: %s: %w", alias, v.Source, err)
+			}
+			cfg.SSH.JumpHosts = hops
+			filled.JumpHosts = hops
+		}
+	}
+	cfg.SSH.fromAlias = filled
+	return nil
+}
+
+// withoutAliasValues returns cfg with the values filled from ssh_config
+// cleared, unless they were changed since Load.
+func withoutAliasValues(cfg *Config) *Config {
+	filled := cfg.SSH.fromAlias
+	if filled == nil {
+		return cfg
+	}
+	out := *cfg
+	if filled.Host != "" && out.SSH.Host == filled.Host {
+		out.SSH.Host = ""
+	}
+	if filled.User != "" && out.SSH.User == filled.User {
+		out.SSH.User = ""
+	}
+	if filled.Port != 0 && out.SSH.Port == filled.Port {
+		out.SSH.Port = 0
+	}
+	if filled.IdentityFile != "" && out.SSH.IdentityFile == filled.IdentityFile {
+		out.SSH.IdentityFile = ""
+	}
+	if len(filled.JumpHosts) > 0 && reflect.DeepEqual(out.SSH.JumpHosts, filled.JumpHosts) {
+		out.SSH.JumpHosts = nil
+	}
+	return &out
+}
+
+// FromSSHConfig reports whether the ssh field (host, user, port,
+// identity_file or jump_hosts) was filled from ssh.config_alias.
+func FromSSHConfig(cfg *Config, field string) bool {
+	filled := cfg.SSH.fromAlias
+	if filled == nil {
+		return false
+	}
+	switch field {
+	case "host":
+		return filled.Host != "" && cfg.SSH.Host == filled.Host
+	case "user":
+		return filled.User != "" && cfg.SSH.User == filled.User
+	case "port":
+		return filled.Port != 0 && cfg.SSH.Port == filled.Port
+	case "identity_file":
+		return filled.IdentityFile != "" && cfg.SSH.IdentityFile == filled.IdentityFile
+	case "jump_hosts":
+		return len(filled.JumpHosts) > 0 && reflect.DeepEqual(cfg.SSH.JumpHosts, filled.JumpHosts)
+	}
+	return false
+}
+
+// ParseJumpChain parses a ProxyJump value, `[user@]host[:port]` hops
+// separated by commas.
+func ParseJumpChain(value string) ([]JumpHost, error) {
+	var hops []JumpHost
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var hop JumpHost
+		if at := strings.LastIndex(part, "@"); at >= 0 {
+			hop.User = part[:at]
+			part = part[at+1:]
+		}
+		hop.Host = part
+		if host, port, err := net.SplitHostPort(part); err == nil {
+			n, err := strconv.Atoi(port)
+			if err != nil || n < 1 || n > 65535 {
+				return nil, fmt.Errorf("invalid jump host port in %q", part)
+			}
+			hop.Host, hop.Port = host, n
+		}
+		if hop.Host == "" {
+			return nil, fmt.Errorf("invalid jump host %q", part)
+		}
+		hops = append(hops, hop)
+	}
+	return hops, nil
+}
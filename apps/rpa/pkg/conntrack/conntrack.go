@@ -0,0 +1,48 @@
+// Package conntrack counts established TCP connections on given ports.
+// It lets supervisors avoid restarting SSH while forwarded sessions are active.
+
+package conntrack
+
+import (
+	"errors"
+	"net/netip"
+)
+
+// ErrUnsupported is returned on platforms without a connection table reader.
+var ErrUnsupported = errors.New("connection tracking not supported on this platform")
+
+// Filter selects connections whose local port is in LocalPorts or whose
+// remote end is exactly one of Remotes. Matching remotes by address as well
+// as port keeps unrelated outbound connections, such as ssh's own session,
+// from being counted.
+type Filter struct {
+	LocalPorts []int
+	Remotes    []netip.AddrPort
+}
+
+func (f Filter) Empty() bool {
+	return len(f.LocalPorts) == 0 && len(f.Remotes) == 0
+}
+
+func (f Filter) match(localPort int, remote netip.AddrPort) bool {
+	for _, p := range f.LocalPorts {
+		if p == localPort {
+			return true
+		}
+	}
+	remote = netip.AddrPortFrom(remote.Addr().Unmap(), remote.Port())
+	for _, r := range f.Remotes {
+		if netip.AddrPortFrom(r.Addr().Unmap(), r.Port()) == remote {
+			return true
+		}
+	}
+	return false
+}
+
+// Established returns the number of established TCP connections matching f.
+func Established(f Filter) (int, error) {
+	if f.Empty() {
+		return 0, nil
+	}
+	return established(f)
+}
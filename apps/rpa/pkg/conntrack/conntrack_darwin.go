@@ -0,0 +1,62 @@
+//go:build darwin
+
+// Package conntrack parses `netstat -an -p tcp` on macOS.
+// It is used by the periodic restart deferral logic.
+
+package conntrack
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+func established(f Filter) (int, error) {
+	out, err := exec.Command("netstat", "-an", "-p", "tcp").Output()
+	if err != nil {
+		return 0, fmt.Errorf("netstat: %w", err)
+	}
+	count := 0
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || !strings.HasPrefix(fields[0], "tcp") || fields[5] != "ESTABLISHED" {
+			continue
+		}
+		local, ok := dottedAddrPort(fields[3])
+		if !ok {
+			continue
+		}
+		remote, ok := dottedAddrPort(fields[4])
+		if !ok {
+			continue
+		}
+		if f.match(int(local.Port()), remote) {
+			count++
+		}
+	}
+	return count, scanner.Err()
+}
+
+// dottedAddrPort parses netstat's "addr.port" notation; IPv6 addresses may
+// carry a "%zone" suffix.
+func dottedAddrPort(value string) (netip.AddrPort, bool) {
+	idx := strings.LastIndex(value, ".")
+	if idx < 0 {
+		return netip.AddrPort{}, false
+	}
+	port, err := strconv.ParseUint(value[idx+1:], 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, false
+	}
+	host, _, _ := strings.Cut(value[:idx], "%")
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.AddrPort{}, false
+	}
+	return netip.AddrPortFrom(addr, uint16(port)), true
+}
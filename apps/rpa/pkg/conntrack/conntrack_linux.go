@@ -0,0 +1,91 @@
+//go:build linux
+
+// Package conntrack reads /proc/net/tcp and /proc/net/tcp6 on Linux.
+// It is used by the periodic restart deferral logic.
+
+package conntrack
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// tcpEstablished is the TCP_ESTABLISHED state as printed by the kernel.
+const tcpEstablished = "01"
+
+func established(f Filter) (int, error) {
+	total := 0
+	found := false
+	for _, path := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		n, err := countProcTable(path, f)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return 0, err
+		}
+		found = true
+		total += n
+	}
+	if !found {
+		return 0, ErrUnsupported
+	}
+	return total, nil
+}
+
+func countProcTable(path string, f Filter) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[3] != tcpEstablished {
+			continue
+		}
+		local, ok := hexAddrPort(fields[1])
+		if !ok {
+			continue
+		}
+		remote, ok := hexAddrPort(fields[2])
+		if !ok {
+			continue
+		}
+		if f.match(int(local.Port()), remote) {
+			count++
+		}
+	}
+	return count, scanner.Err()
+}
+
+// hexAddrPort parses the kernel's "0100007F:1F90" notation, where the
+// address is stored as native-endian (little-endian) 32-bit words.
+func hexAddrPort(value string) (netip.AddrPort, bool) {
+	addrHex, portHex, ok := strings.Cut(value, ":")
+	if !ok {
+		return netip.AddrPort{}, false
+	}
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return netip.AddrPort{}, false
+	}
+	raw, err := hex.DecodeString(addrHex)
+	if err != nil || (len(raw) != 4 && len(raw) != 16) {
+		return netip.AddrPort{}, false
+	}
+	for i := 0; i < len(raw); i += 4 {
+		raw[i], raw[i+1], raw[i+2], raw[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+	}
+	addr, _ := netip.AddrFromSlice(raw)
+	return netip.AddrPortFrom(addr, uint16(port)), true
+}
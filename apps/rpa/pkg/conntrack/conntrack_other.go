@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+// Package conntrack has no connection table reader on this platform.
+// Callers treat ErrUnsupported as "no active connections".
+
+package conntrack
+
+func established(Filter) (int, error) {
+	return 0, ErrUnsupported
+}
@@ -0,0 +1,462 @@
+// Package forward parses OpenSSH forward specs (-L, -R and -D) into a typed Forward.
+// config validates and de-duplicates with it; agent, client and cli read binds and targets from it.
+
+package forward
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Endpoint is one side of a forward: a host and port, or a Unix socket path.
+type Endpoint struct {
+	Host   string
+	Port   int
+	Socket string
+}
+
+func (e Endpoint) IsSocket() bool {
+	return e.Socket != ""
+}
+
+// Network returns the dial/listen network for the endpoint.
+func (e Endpoint) Network() string {
+	if e.IsSocket() {
+		return "unix"
+	}
+	return "tcp"
+}
+
+// Address returns the endpoint in net.Dial form.
+func (e Endpoint) Address() string {
+	if e.IsSocket() {
+		return e.Socket
+	}
+	return net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+}
+
+// Forward is a parsed spec. Hosts are kept as written, without brackets;
+// Bind.Host is "127.0.0.1" when the spec omits the bind address (OpenSSH
+// without GatewayPorts). Dynamic forwards have no Target.
+type Forward struct {
+	Spec    string
+	Bind    Endpoint
+	Target  Endpoint
+	Dynamic bool
+
+	bindOmitted bool
+}
+
+// Parse accepts every OpenSSH form:
+//
+//	[bind:]port:host:hostport   [bind:]port:/target.sock
+//	/bind.sock:host:hostport    /bind.sock:/target.sock
+//	[bind:]port                 (dynamic: -D, or -R without a destination)
+//
+// IPv6 addresses are written in brackets, e.g. `[::1]:8080:[fd00::2]:80`.
+func Parse(spec string) (Forward, error) {
+	trimmed := strings.TrimSpace(spec)
+	f, err := parse(trimmed)
+	if err != nil {
+		return Forward{}, fmt.Errorf("invalid forward %q: %w", trimmed, err)
+	}
+	f.Spec = trimmed
+	return f, nil
+}
+
+func parse(spec string) (Forward, error) {
+	if spec == "" {
+		return Forward{}, errors.New("empty spec")
+	}
+	tokens, err := split(spec)
+	if err != nil {
+		return Forward{}, err
+	}
+	var f Forward
+	var bind []token
+	last := tokens[len(tokens)-1]
+	switch {
+	case last.socket():
+		f.Target = Endpoint{Socket: last.text}
+		bind = tokens[:len(tokens)-1]
+	case len(tokens) >= 3:
+		host := tokens[len(tokens)-2]
+		if host.socket() {
+			return Forward{}, errors.New("target socket cannot have a port")
+		}
+		if err := checkHost(host, false); err != nil {
+			return Forward{}, fmt.Errorf("target host: %w", err)
+		}
+		port, err := parsePort(last.text, false)
+		if err != nil {
+			return Forward{}, fmt.Errorf("target port: %w", err)
+		}
+		f.Target = Endpoint{Host: host.text, Port: port}
+		bind = tokens[:len(tokens)-2]
+	default:
+		f.Dynamic = true
+		bind = tokens
+	}
+
+	switch {
+	case len(bind) == 1 && bind[0].socket():
+		if f.Dynamic {
+			return Forward{}, errors.New("dynamic forwards cannot bind a unix socket")
+		}
+		f.Bind = Endpoint{Socket: bind[0].text}
+	case len(bind) == 1:
+		port, err := parsePort(bind[0].text, true)
+		if err != nil {
+			return Forward{}, fmt.Errorf("bind port: %w", err)
+		}
+		f.Bind = Endpoint{Host: "127.0.0.1", Port: port}
+		f.bindOmitted = true
+	case len(bind) == 2:
+		if bind[0].socket() || bind[1].socket() {
+			return Forward{}, errors.New("unix socket bind cannot have a port")
+		}
+		if err := checkHost(bind[0], true); err != nil {
+			return Forward{}, fmt.Errorf("bind host: %w", err)
+		}
+		port, err := parsePort(bind[1].text, true)
+		if err != nil {
+			return Forward{}, fmt.Errorf("bind port: %w", err)
+		}
+		f.Bind = Endpoint{Host: bind[0].text, Port: port}
+	default:
+		return Forward{}, errors.New("too many fields")
+	}
+	return f, nil
+}
+
+// maxRange caps how many forwards one range spec may expand to.
+const maxRange = 1024
+
+// IsRange reports whether spec uses port-range syntax (`8000-8010`).
+func IsRange(spec string) bool {
+	tokens, err := split(strings.TrimSpace(spec))
+	if err != nil {
+		return false
+	}
+	for _, t := range tokens {
+		if _, _, ok := portRange(t); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Expand parses spec and returns one Forward per port. A range may appear in
+// the bind port and the target port, e.g. `0.0.0.0:8000-8010:localhost:8000-8010`;
+// when both are ranges they must have the same length. Specs without a range
+// expand to themselves.
+func Expand(spec string) ([]Forward, error) {
+	trimmed := strings.TrimSpace(spec)
+	tokens, err := split(trimmed)
+	if err != nil || !IsRange(trimmed) {
+		f, err := Parse(trimmed)
+		if err != nil {
+			return nil, err
+		}
+		return []Forward{f}, nil
+	}
+	fail := func(msg string) ([]Forward, error) {
+		return nil, fmt.Errorf("invalid forward %q: %s", trimmed, msg)
+	}
+
+	bindPort, targetPort := -1, -1
+	n := len(tokens)
+	bindEnd := n
+	switch {
+	case tokens[n-1].socket():
+		bindEnd = n - 1
+	case n >= 3:
+		targetPort, bindEnd = n-1, n-2
+	}
+	if bindEnd > 0 && !tokens[bindEnd-1].socket() {
+		bindPort = bindEnd - 1
+	}
+	size := 0
+	for i, t := range tokens {
+		lo, hi, ok := portRange(t)
+		if !ok {
+			continue
+		}
+		if i != bindPort && i != targetPort {
+			return fail("port range outside a port field")
+		}
+		if hi < lo {
+			return fail(fmt.Sprintf("port range %s is reversed", t.text))
+		}
+		count := hi - lo + 1
+		if count > maxRange {
+			return fail(fmt.Sprintf("port range %s is larger than %d ports", t.text, maxRange))
+		}
+		if size != 0 && size != count {
+			return fail("bind and target port ranges have different lengths")
+		}
+		size = count
+	}
+	if bindPort < 0 {
+		return fail("port range requires a bind port")
+	}
+	if _, _, ok := portRange(tokens[bindPort]); !ok {
+		return fail("target port range requires a bind port range")
+	}
+
+	out := make([]Forward, 0, size)
+	for k := 0; k < size; k++ {
+		parts := make([]string, len(tokens))
+		for i, t := range tokens {
+			switch lo, _, ok := portRange(t); {
+			case ok:
+				parts[i] = strconv.Itoa(lo + k)
+			case t.bracketed:
+				parts[i] = "[" + t.text + "]"
+			default:
+				parts[i] = t.text
+			}
+		}
+		f, err := Parse(strings.Join(parts, ":"))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+func portRange(t token) (int, int, bool) {
+	if t.bracketed || t.socket() {
+		return 0, 0, false
+	}
+	lo, hi, ok := strings.Cut(t.text, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	a, errA := strconv.Atoi(lo)
+	b, errB := strconv.Atoi(hi)
+	if errA != nil || errB != nil || a < 0 || b < 0 {
+		return 0, 0, false
+	}
+	return a, b, true
+}
+
+// ParsePortRange parses `lo-hi` or a single port into an inclusive range.
+func ParsePortRange(value string) (int, int, error) {
+	value = strings.TrimSpace(value)
+	lo, hi, ok := strings.Cut(value, "-")
+	if !ok {
+		hi = lo
+	}
+	a, err := parsePort(strings.TrimSpace(lo), false)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %w", value, err)
+	}
+	b, err := parsePort(strings.TrimSpace(hi), false)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %w", value, err)
+	}
+	if b < a {
+		return 0, 0, fmt.Errorf("invalid port range %q: reversed", value)
+	}
+	return a, b, nil
+}
+
+// BindSpec returns the bind part as it appears in a spec, so callers can
+// point the same bind at another target.
+func (f Forward) BindSpec() string {
+	switch {
+	case f.Bind.IsSocket():
+		return f.Bind.Socket
+	case f.bindOmitted:
+		return strconv.Itoa(f.Bind.Port)
+	case strings.Contains(f.Bind.Host, ":"):
+		return "[" + f.Bind.Host + "]:" + strconv.Itoa(f.Bind.Port)
+	default:
+		return f.Bind.Host + ":" + strconv.Itoa(f.Bind.Port)
+	}
+}
+
+// MustBeStatic rejects dynamic forwards for callers that need a target.
+func (f Forward) MustBeStatic() error {
+	if f.Dynamic {
+		return fmt.Errorf("invalid forward %q: missing destination", f.Spec)
+	}
+	return nil
+}
+
+// Key is the canonical form used for de-duplication: two specs with the same
+// key forward the same bind to the same target.
+func (f Forward) Key() string {
+	bind := f.Bind.Socket
+	if !f.Bind.IsSocket() {
+		bind = net.JoinHostPort(canonicalBind(f.Bind.Host), strconv.Itoa(f.Bind.Port))
+	}
+	if f.Dynamic {
+		return bind
+	}
+	target := f.Target.Socket
+	if !f.Target.IsSocket() {
+		target = net.JoinHostPort(canonicalHost(f.Target.Host), strconv.Itoa(f.Target.Port))
+	}
+	return bind + " -> " + target
+}
+
+// IsLoopback reports whether the bind only accepts local connections.
+// Socket binds count as local.
+func (f Forward) IsLoopback() bool {
+	return f.Bind.IsSocket() || IsLoopbackHost(f.Bind.Host)
+}
+
+// IsWildcard reports whether the bind listens on every interface.
+func (f Forward) IsWildcard() bool {
+	return !f.Bind.IsSocket() && isWildcard(canonicalBind(f.Bind.Host))
+}
+
+// Conflicts reports whether two forwards would try to listen on the same
+// address. Port 0 asks for a free port and never conflicts.
+func (f Forward) Conflicts(other Forward) bool {
+	a, b := f.Bind, other.Bind
+	if a.IsSocket() || b.IsSocket() {
+		return a.Socket == b.Socket
+	}
+	if a.Port == 0 || a.Port != b.Port {
+		return false
+	}
+	ha, hb := canonicalBind(a.Host), canonicalBind(b.Host)
+	return ha == hb || isWildcard(ha) || isWildcard(hb)
+}
+
+// FindConflict returns the first pair of forwards that bind the same address.
+func FindConflict(forwards []Forward) (Forward, Forward, bool) {
+	for i := range forwards {
+		for j := i + 1; j < len(forwards); j++ {
+			if forwards[i].Conflicts(forwards[j]) {
+				return forwards[i], forwards[j], true
+			}
+		}
+	}
+	return Forward{}, Forward{}, false
+}
+
+// IsLoopbackHost reports whether a bind host only accepts local connections.
+func IsLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func isWildcard(host string) bool {
+	return host == "*" || host == "0.0.0.0" || host == "::"
+}
+
+type token struct {
+	text      string
+	bracketed bool
+}
+
+func (t token) socket() bool {
+	return !t.bracketed && strings.HasPrefix(t.text, "/")
+}
+
+// split cuts spec at colons outside of brackets.
+func split(spec string) ([]token, error) {
+	var out []token
+	for len(spec) > 0 || len(out) == 0 {
+		if strings.HasPrefix(spec, "[") {
+			end := strings.Index(spec, "]")
+			if end < 0 {
+				return nil, errors.New("unclosed bracket")
+			}
+			out = append(out, token{text: spec[1:end], bracketed: true})
+			spec = spec[end+1:]
+			if spec == "" {
+				break
+			}
+			if spec[0] != ':' {
+				return nil, errors.New("expected ':' after bracketed address")
+			}
+			spec = spec[1:]
+			if spec == "" {
+				return nil, errors.New("trailing ':'")
+			}
+			continue
+		}
+		i := strings.Index(spec, ":")
+		if i < 0 {
+			out = append(out, token{text: spec})
+			break
+		}
+		out = append(out, token{text: spec[:i]})
+		spec = spec[i+1:]
+		if spec == "" {
+			return nil, errors.New("trailing ':'")
+		}
+	}
+	return out, nil
+}
+
+func parsePort(value string, allowZero bool) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", value)
+	}
+	min := 1
+	if allowZero {
+		min = 0
+	}
+	if n < min || n > 65535 {
+		return 0, fmt.Errorf("%d out of range %d-65535", n, min)
+	}
+	return n, nil
+}
+
+func checkHost(t token, bind bool) error {
+	host := t.text
+	if t.bracketed {
+		if ip := net.ParseIP(host); ip == nil || ip.To4() != nil {
+			return fmt.Errorf("%q is not an IPv6 address", host)
+		}
+		return nil
+	}
+	if host == "" || host == "*" {
+		if bind {
+			return nil
+		}
+		return errors.New("missing host")
+	}
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	for _, r := range host {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '.', r == '_':
+		default:
+			return fmt.Errorf("%q is not a valid host name", host)
+		}
+	}
+	return nil
+}
+
+func canonicalBind(host string) string {
+	if host == "" || host == "*" {
+		return "*"
+	}
+	return canonicalHost(host)
+}
+
+func canonicalHost(host string) string {
+	if strings.EqualFold(host, "localhost") {
+		return "127.0.0.1"
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return strings.ToLower(host)
+}
@@ -0,0 +1,306 @@
+// Package grant manages access grants in a section of the server's authorized_keys that rpa owns.
+// Lines outside the section are never touched; each edit is checked against the version that was read.
+
+package grant
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	sectionBegin = "# >>> rpa grants (managed by rpa; edit with `rpa grant`) >>>"
+	sectionEnd   = "# <<< rpa grants <<<"
+	metaPrefix   = "# rpa-grant "
+	commentTag   = "rpa-grant:"
+
+	// expiryLayout is sshd's expiry-time format, in UTC.
+	expiryLayout = "200601021504Z"
+)
+
+// ErrConflict means authorized_keys changed between reading and writing it.
+var ErrConflict = errors.New("authorized_keys changed while it was being edited; try again")
+
+// ErrNotFound means no grant matched.
+var ErrNotFound = errors.New("grant not found")
+
+// Grant is one key allowed to forward through the server.
+type Grant struct {
+	Name      string
+	PublicKey string
+	// Permits are permitopen/permitlisten options, e.g. `permitopen="db:5432"`.
+	Permits   []string
+	CreatedAt time.Time
+	ExpiresAt *time.Time
+}
+
+// Fingerprint is the SHA256 fingerprint of the grant's key.
+func (g Grant) Fingerprint() string {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(g.PublicKey))
+	if err != nil {
+		return ""
+	}
+	return ssh.FingerprintSHA256(key)
+}
+
+// Expired reports whether the grant's expiry has passed at now.
+func (g Grant) Expired(now time.Time) bool {
+	return g.ExpiresAt != nil && !now.Before(*g.ExpiresAt)
+}
+
+// Line renders the authorized_keys entry. sshd enforces expiry-time on its
+// own, so an expired grant stops working even before rpa removes it.
+func (g Grant) Line() string {
+	options := []string{"restrict", "port-forwarding"}
+	options = append(options, g.Permits...)
+	if g.ExpiresAt != nil {
+		options = append(options, fmt.Sprintf("expiry-time=%q", g.ExpiresAt.UTC().Format(expiryLayout)))
+	}
+	key := g.PublicKey
+	if pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(g.PublicKey)); err == nil {
+		key = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+	}
+	return strings.Join(options, ",") + " " + key + " " + commentTag + g.Name
+}
+
+func (g Grant) meta() string {
+	line := metaPrefix + "name=" + g.Name
+	if !g.CreatedAt.IsZero() {
+		line += " created=" + g.CreatedAt.UTC().Format(time.RFC3339)
+	}
+	if g.ExpiresAt != nil {
+		line += " expires=" + g.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	return line
+}
+
+// Store reads and writes authorized_keys. Save must fail with ErrConflict
+// when the file no longer matches the version Load returned.
+type Store interface {
+	Load() (content []byte, version string, err error)
+	Save(content []byte, version string) error
+}
+
+// List returns the grants in the managed section.
+func List(s Store) ([]Grant, error) {
+	content, _, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+	_, grants, _, err := parse(content)
+	return grants, err
+}
+
+// Add writes g into the managed section, replacing a grant of the same name.
+// A key already authorized outside the section is refused because sshd uses
+// the first matching line and the grant's limits would not apply.
+func Add(s Store, g Grant) error {
+	if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(g.PublicKey)); err != nil {
+		return fmt.Errorf("public key: %w", err)
+	}
+	return edit(s, func(before, after []string, grants []Grant) ([]Grant, error) {
+		for _, line := range append(append([]string(nil), before...), after...) {
+			if sameKey(line, g.PublicKey) {
+				return nil, errors.New("this key is already in authorized_keys outside rpa's section; remove that line first")
+			}
+		}
+		out := make([]Grant, 0, len(grants)+1)
+		for _, existing := range grants {
+			if existing.Name == g.Name || sameKey(existing.PublicKey, g.PublicKey) {
+				continue
+			}
+			out = append(out, existing)
+		}
+		return append(out, g), nil
+	})
+}
+
+// Revoke removes the grant whose name or fingerprint is ref.
+func Revoke(s Store, ref string) (Grant, error) {
+	var removed Grant
+	err := edit(s, func(_, _ []string, grants []Grant) ([]Grant, error) {
+		out := make([]Grant, 0, len(grants))
+		found := false
+		for _, g := range grants {
+			if !found && (g.Name == ref || g.Fingerprint() == ref) {
+				removed = g
+				found = true
+				continue
+			}
+			out = append(out, g)
+		}
+		if !found {
+			return nil, ErrNotFound
+		}
+		return out, nil
+	})
+	return removed, err
+}
+
+// RemoveExpired drops every grant that expired by now and returns them.
+func RemoveExpired(s Store, now time.Time) ([]Grant, error) {
+	var expired []Grant
+	err := edit(s, func(_, _ []string, grants []Grant) ([]Grant, error) {
+		out := make([]Grant, 0, len(grants))
+		for _, g := range grants {
+			if g.Expired(now) {
+				expired = append(expired, g)
+				continue
+			}
+			out = append(out, g)
+		}
+		return out, nil
+	})
+	return expired, err
+}
+
+func edit(s Store, change func(before, after []string, grants []Grant) ([]Grant, error)) error {
+	content, version, err := s.Load()
+	if err != nil {
+		return err
+	}
+	before, grants, after, err := parse(content)
+	if err != nil {
+		return err
+	}
+	next, err := change(before, after, grants)
+	if err != nil {
+		return err
+	}
+	updated := render(before, next, after)
+	if bytes.Equal(updated, content) {
+		return nil
+	}
+	return s.Save(updated, version)
+}
+
+// parse splits authorized_keys into the lines before the managed section,
+// its grants and the lines after it.
+func parse(content []byte) ([]string, []Grant, []string, error) {
+	text := strings.TrimSuffix(string(content), "\n")
+	var lines []string
+	if text != "" {
+		lines = strings.Split(text, "\n")
+	}
+	begin, end := -1, -1
+	for i, line := range lines {
+		switch strings.TrimSpace(line) {
+		case sectionBegin:
+			if begin >= 0 {
+				return nil, nil, nil, errors.New("authorized_keys has more than one rpa grants section")
+			}
+			begin = i
+		case sectionEnd:
+			if begin >= 0 && end < 0 {
+				end = i
+			}
+		}
+	}
+	if begin < 0 {
+		return lines, nil, nil, nil
+	}
+	if end < 0 {
+		return nil, nil, nil, errors.New("authorized_keys has an unterminated rpa grants section")
+	}
+
+	var grants []Grant
+	var meta map[string]string
+	for _, line := range lines[begin+1 : end] {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, metaPrefix):
+			meta = parseMeta(strings.TrimPrefix(line, metaPrefix))
+		case strings.HasPrefix(line, "#"):
+		default:
+			g, err := parseGrant(line, meta)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			grants = append(grants, g)
+			meta = nil
+		}
+	}
+	return lines[:begin], grants, lines[end+1:], nil
+}
+
+func parseMeta(value string) map[string]string {
+	out := make(map[string]string)
+	for _, field := range strings.Fields(value) {
+		if k, v, ok := strings.Cut(field, "="); ok {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func parseGrant(line string, meta map[string]string) (Grant, error) {
+	key, comment, options, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return Grant{}, fmt.Errorf("rpa grants section: %w", err)
+	}
+	g := Grant{
+		Name:      strings.TrimPrefix(comment, commentTag),
+		PublicKey: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+	}
+	for _, opt := range options {
+		name, value, _ := strings.Cut(opt, "=")
+		switch strings.ToLower(name) {
+		case "restrict", "port-forwarding":
+		case "expiry-time":
+			if t, err := time.Parse(expiryLayout, strings.Trim(value, `"`)); err == nil {
+				g.ExpiresAt = &t
+			}
+		default:
+			g.Permits = append(g.Permits, opt)
+		}
+	}
+	if v, ok := meta["name"]; ok && v != "" {
+		g.Name = v
+	}
+	if t, err := time.Parse(time.RFC3339, meta["created"]); err == nil {
+		g.CreatedAt = t
+	}
+	if t, err := time.Parse(time.RFC3339, meta["expires"]); err == nil {
+		g.ExpiresAt = &t
+	}
+	return g, nil
+}
+
+func render(before []string, grants []Grant, after []string) []byte {
+	var b strings.Builder
+	for _, line := range before {
+		b.WriteString(line + "\n")
+	}
+	if len(grants) > 0 {
+		b.WriteString(sectionBegin + "\n")
+		for _, g := range grants {
+			b.WriteString(g.meta() + "\n")
+			b.WriteString(g.Line() + "\n")
+		}
+		b.WriteString(sectionEnd + "\n")
+	}
+	for _, line := range after {
+		b.WriteString(line + "\n")
+	}
+	return []byte(b.String())
+}
+
+// sameKey reports whether an authorized_keys line carries the same key as
+// publicKey, ignoring options and comments.
+func sameKey(line, publicKey string) bool {
+	want, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return false
+	}
+	got, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(got.Marshal(), want.Marshal())
+}
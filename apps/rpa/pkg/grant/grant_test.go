@@ -0,0 +1,206 @@
+package grant
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// memStore is an in-memory authorized_keys whose version is a write counter.
+type memStore struct {
+	content []byte
+	version int
+	// beforeSave runs between Load and Save, to simulate a concurrent edit.
+	beforeSave func(s *memStore)
+}
+
+func (s *memStore) Load() ([]byte, string, error) {
+	return append([]byte(nil), s.content...), strconv.Itoa(s.version), nil
+}
+
+func (s *memStore) Save(content []byte, version string) error {
+	if s.beforeSave != nil {
+		s.beforeSave(s)
+		s.beforeSave = nil
+	}
+	if version != strconv.Itoa(s.version) {
+		return ErrConflict
+	}
+	s.content = append([]byte(nil), content...)
+	s.version++
+	return nil
+}
+
+func newKey(t *testing.T) string {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+func TestAddKeepsLinesOutsideSection(t *testing.T) {
+	owner := newKey(t) + " owner@laptop"
+	s := &memStore{content: []byte("# admin keys\n" + owner + "\n")}
+	expires := time.Date(2030, 1, 2, 3, 4, 0, 0, time.UTC)
+	g := Grant{Name: "alice", PublicKey: newKey(t), Permits: []string{`permitopen="db:5432"`}, ExpiresAt: &expires}
+	if err := Add(s, g); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	text := string(s.content)
+	if !strings.HasPrefix(text, "# admin keys\n"+owner+"\n"+sectionBegin+"\n") {
+		t.Fatalf("lines outside the section changed:\n%s", text)
+	}
+	if !strings.Contains(text, `restrict,port-forwarding,permitopen="db:5432",expiry-time="203001020304Z" `) {
+		t.Fatalf("grant line missing options:\n%s", text)
+	}
+	grants, err := List(s)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(grants) != 1 || grants[0].Name != "alice" || grants[0].Fingerprint() != g.Fingerprint() {
+		t.Fatalf("List = %+v", grants)
+	}
+	if grants[0].ExpiresAt == nil || !grants[0].ExpiresAt.Equal(expires) {
+		t.Fatalf("ExpiresAt = %v, want %v", grants[0].ExpiresAt, expires)
+	}
+}
+
+func TestAddReplacesGrantOfSameName(t *testing.T) {
+	s := &memStore{}
+	if err := Add(s, Grant{Name: "alice", PublicKey: newKey(t), Permits: []string{`permitopen="db:5432"`}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Add(s, Grant{Name: "bob", PublicKey: newKey(t), Permits: []string{`permitopen="web:80"`}}); err != nil {
+		t.Fatal(err)
+	}
+	replacement := Grant{Name: "alice", PublicKey: newKey(t), Permits: []string{`permitopen="cache:6379"`}}
+	if err := Add(s, replacement); err != nil {
+		t.Fatal(err)
+	}
+
+	grants, err := List(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grants) != 2 || grants[0].Name != "bob" || grants[1].Name != "alice" {
+		t.Fatalf("List = %+v", grants)
+	}
+	if grants[1].Fingerprint() != replacement.Fingerprint() || grants[1].Permits[0] != `permitopen="cache:6379"` {
+		t.Fatalf("alice was not replaced: %+v", grants[1])
+	}
+	if strings.Count(string(s.content), sectionBegin) != 1 {
+		t.Fatalf("section duplicated:\n%s", s.content)
+	}
+}
+
+func TestAddRefusesKeyAuthorizedOutsideSection(t *testing.T) {
+	key := newKey(t)
+	s := &memStore{content: []byte(key + " someone\n")}
+	err := Add(s, Grant{Name: "alice", PublicKey: key})
+	if err == nil || !strings.Contains(err.Error(), "outside rpa's section") {
+		t.Fatalf("Add = %v, want refusal", err)
+	}
+	if s.version != 0 {
+		t.Fatal("authorized_keys was written")
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	outside := newKey(t) + " admin\n"
+	s := &memStore{content: []byte(outside)}
+	alice := Grant{Name: "alice", PublicKey: newKey(t)}
+	bob := Grant{Name: "bob", PublicKey: newKey(t)}
+	for _, g := range []Grant{alice, bob} {
+		if err := Add(s, g); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := Revoke(s, bob.Fingerprint())
+	if err != nil || removed.Name != "bob" {
+		t.Fatalf("Revoke by fingerprint = %+v, %v", removed, err)
+	}
+	if _, err := Revoke(s, "bob"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("second Revoke = %v, want ErrNotFound", err)
+	}
+	if _, err := Revoke(s, "alice"); err != nil {
+		t.Fatalf("Revoke by name: %v", err)
+	}
+	if string(s.content) != outside {
+		t.Fatalf("revoking every grant should drop the section:\n%s", s.content)
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+	s := &memStore{}
+	for _, g := range []Grant{
+		{Name: "old", PublicKey: newKey(t), ExpiresAt: &past},
+		{Name: "current", PublicKey: newKey(t), ExpiresAt: &future},
+		{Name: "forever", PublicKey: newKey(t)},
+	} {
+		if err := Add(s, g); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := RemoveExpired(s, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0].Name != "old" {
+		t.Fatalf("removed = %+v", removed)
+	}
+	grants, _ := List(s)
+	if len(grants) != 2 || grants[0].Name != "current" || grants[1].Name != "forever" {
+		t.Fatalf("kept = %+v", grants)
+	}
+
+	version := s.version
+	if removed, err := RemoveExpired(s, now); err != nil || len(removed) != 0 {
+		t.Fatalf("second RemoveExpired = %+v, %v", removed, err)
+	}
+	if s.version != version {
+		t.Fatal("nothing expired but authorized_keys was rewritten")
+	}
+}
+
+func TestEditConflict(t *testing.T) {
+	s := &memStore{}
+	s.beforeSave = func(s *memStore) {
+		s.content = append(s.content, []byte(newKey(t)+" added meanwhile\n")...)
+		s.version++
+	}
+	err := Add(s, Grant{Name: "alice", PublicKey: newKey(t)})
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("Add = %v, want ErrConflict", err)
+	}
+	if strings.Contains(string(s.content), sectionBegin) {
+		t.Fatal("conflicting edit was written")
+	}
+}
+
+func TestParseRejectsBrokenSection(t *testing.T) {
+	for _, content := range []string{
+		sectionBegin + "\n",
+		sectionBegin + "\n" + sectionEnd + "\n" + sectionBegin + "\n" + sectionEnd + "\n",
+	} {
+		if _, err := List(&memStore{content: []byte(content)}); err == nil {
+			t.Fatalf("List accepted:\n%s", content)
+		}
+	}
+}
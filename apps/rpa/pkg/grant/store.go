@@ -0,0 +1,240 @@
+// Package grant edits authorized_keys on the server through ssh and keeps a local cache of grant expiries.
+// The cache lets the agent skip connecting until a grant it knows about has actually expired.
+
+package grant
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/sshutil"
+)
+
+// conflictExit is the exit status the save script uses when the file
+// changed since it was read (EX_TEMPFAIL).
+const conflictExit = 75
+
+// The scripts start from h, the home directory holding authorized_keys, and
+// o, the account to hand new files to (empty for the login account).
+const loadScript = `f="$h/.ssh/authorized_keys"
+if [ -f "$f" ]; then cksum < "$f" && cat "$f"; else echo missing; fi`
+
+// saveScript replaces authorized_keys with stdin if its checksum still
+// matches, keeping the previous file as authorized_keys.rpa-bak. The new
+// file is written next to the old one and renamed, so sshd never reads a
+// partial file. The checksum test and the rename run under one lock, taken
+// with flock(1) where it exists and with a lock directory otherwise, so two
+// rpa edits cannot both pass the test.
+const saveScript = `set -e
+umask 077
+d="$h/.ssh"
+f="$d/authorized_keys"
+t="$f.rpa-tmp.$$"
+l="$d/.rpa-grants.lock"
+mkdir -p "$d"
+if [ -n "$o" ]; then chown "$o" "$d"; fi
+trap 'rm -f "$t"' EXIT
+trap 'exit 1' HUP INT TERM
+cat > "$t"
+chmod 600 "$t"
+if [ -n "$o" ]; then chown "$o" "$t"; fi
+if command -v flock >/dev/null 2>&1; then
+  exec 9>"$l"
+  flock -w 10 9 || exit %[2]d
+else
+  n=0
+  until mkdir "$l.d" 2>/dev/null; do
+    n=$((n+1))
+    if [ $n -ge 10 ]; then exit %[2]d; fi
+    sleep 1
+  done
+  trap 'rm -f "$t"; rmdir "$l.d"' EXIT
+fi
+if [ -f "$f" ]; then cur=$(cksum < "$f"); else cur=missing; fi
+if [ "$cur" != %[1]s ]; then exit %[2]d; fi
+if [ -f "$f" ]; then cp -p "$f" "$f.rpa-bak"; fi
+mv -f "$t" "$f"`
+
+// SSHStore reads and writes the remote user's ~/.ssh/authorized_keys.
+// Command returns an ssh command that runs remote on the server.
+type SSHStore struct {
+	Command func(remote string) *exec.Cmd
+	// Account, when set, is the user whose authorized_keys is edited
+	// instead of the login account's; it needs root or Sudo.
+	Account string
+	// Sudo runs the scripts with `sudo -n`.
+	Sudo bool
+}
+
+// NewSSHStore edits the authorized_keys of ssh.user with the tunnel's own
+// connection settings. With ssh.grant_admin it logs in as that account
+// instead and edits ssh.user's file from there. cfg must not change while
+// the store is in use.
+func NewSSHStore(cfg *config.Config) SSHStore {
+	login := cfg
+	store := SSHStore{}
+	if admin := cfg.SSH.GrantAdmin; admin != nil {
+		// The tunnel account may have no shell; edit its file as the admin.
+		adminCfg := *cfg
+		adminCfg.SSH.User = admin.User
+		adminCfg.SSH.IdentityFile = admin.IdentityFile
+		login = &adminCfg
+		store.Account = cfg.SSH.User
+		store.Sudo = admin.Sudo
+	}
+	store.Command = func(remote string) *exec.Cmd {
+		return sshutil.RemoteCommand(login, remote)
+	}
+	return store
+}
+
+func (s SSHStore) Load() ([]byte, string, error) {
+	remote, err := s.remote(loadScript)
+	if err != nil {
+		return nil, "", err
+	}
+	cmd := s.Command(remote)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, "", remoteError("read authorized_keys", err, stderr.String())
+	}
+	version, content, _ := strings.Cut(string(out), "\n")
+	return []byte(content), strings.TrimSpace(version), nil
+}
+
+func (s SSHStore) Save(content []byte, version string) error {
+	remote, err := s.remote(fmt.Sprintf(saveScript, shellQuote(version), conflictExit))
+	if err != nil {
+		return err
+	}
+	cmd := s.Command(remote)
+	var stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(content)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == conflictExit {
+			return ErrConflict
+		}
+		return remoteError("write authorized_keys", err, stderr.String())
+	}
+	return nil
+}
+
+// remote runs script with sh whatever the account's login shell is.
+func (s SSHStore) remote(script string) (string, error) {
+	prelude := "h=\"$HOME\"\no=\n"
+	if s.Account != "" {
+		if strings.Trim(s.Account, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789._-") != "" || s.Account[0] == '-' {
+			return "", fmt.Errorf("invalid account name %q", s.Account)
+		}
+		// An unknown account leaves the tilde unexpanded.
+		prelude = "h=~" + s.Account + "\no=" + s.Account + "\n" +
+			`case "$h" in "~"*) echo "no such account: $o" >&2; exit 1;; esac` + "\n"
+	}
+	command := "sh -c " + shellQuote(prelude+script)
+	if s.Sudo {
+		command = "sudo -n " + command
+	}
+	return command, nil
+}
+
+func remoteError(action string, err error, stderr string) error {
+	if msg := strings.TrimSpace(stderr); msg != "" {
+		lines := strings.Split(msg, "\n")
+		return fmt.Errorf("%s: %s", action, strings.TrimSpace(lines[len(lines)-1]))
+	}
+	return fmt.Errorf("%s: %w", action, err)
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// Server identifies the account whose authorized_keys holds a grant.
+func Server(user, host string, port int) string {
+	return fmt.Sprintf("%s@%s:%d", user, host, port)
+}
+
+// CacheEntry is the local record of one grant.
+type CacheEntry struct {
+	Server      string     `yaml:"server"`
+	Name        string     `yaml:"name"`
+	Fingerprint string     `yaml:"fingerprint"`
+	ExpiresAt   *time.Time `yaml:"expires_at,omitempty"`
+}
+
+type cacheFile struct {
+	Grants []CacheEntry `yaml:"grants"`
+}
+
+// CachePath is where grant expiries are cached.
+func CachePath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("resolve home dir: %w", err)
+	}
+	return filepath.Join(home, ".rpa", "grants.yaml"), nil
+}
+
+// LoadCache reads the cache; a missing file is an empty cache.
+func LoadCache(path string) ([]CacheEntry, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f cacheFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return f.Grants, nil
+}
+
+// SaveCache replaces the cached grants of server with grants.
+func SaveCache(path, server string, grants []Grant) error {
+	entries, err := LoadCache(path)
+	if err != nil {
+		return err
+	}
+	kept := make([]CacheEntry, 0, len(entries)+len(grants))
+	for _, e := range entries {
+		if e.Server != server {
+			kept = append(kept, e)
+		}
+	}
+	for _, g := range grants {
+		kept = append(kept, CacheEntry{Server: server, Name: g.Name, Fingerprint: g.Fingerprint(), ExpiresAt: g.ExpiresAt})
+	}
+	data, err := yaml.Marshal(cacheFile{Grants: kept})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// Due reports whether a cached grant of server has expired by now.
+func Due(entries []CacheEntry, server string, now time.Time) bool {
+	for _, e := range entries {
+		if e.Server == server && e.ExpiresAt != nil && !now.Before(*e.ExpiresAt) {
+			return true
+		}
+	}
+	return false
+}
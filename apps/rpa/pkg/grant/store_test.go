@@ -0,0 +1,290 @@
+package grant
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// The test binary doubles as the ssh client: with sshClientEnv set it
+// connects to the address in it and runs its last argument remotely.
+const sshClientEnv = "RPA_GRANT_TEST_SSH"
+
+func TestMain(m *testing.M) {
+	if addr := os.Getenv(sshClientEnv); addr != "" {
+		os.Exit(runSSHClient(addr, os.Args[len(os.Args)-1]))
+	}
+	os.Exit(m.Run())
+}
+
+func runSSHClient(addr, remote string) int {
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "test",
+		Auth:            []ssh.AuthMethod{ssh.Password("test")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		return 255
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		return 255
+	}
+	session.Stdin = os.Stdin
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+	err = session.Run(remote)
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus()
+	}
+	if err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		return 255
+	}
+	return 0
+}
+
+// startSSHServer serves exec requests by running the command with sh, with
+// HOME set to home, and returns the listen address.
+func startSSHServer(t *testing.T, home string) string {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		_ = ln.Close()
+		wg.Wait()
+	})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				serveSSHConn(conn, config, home)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func serveSSHConn(conn net.Conn, config *ssh.ServerConfig, home string) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			_ = newChan.Reject(ssh.UnknownChannelType, "only sessions")
+			continue
+		}
+		ch, requests, err := newChan.Accept()
+		if err != nil {
+			continue
+		}
+		go serveSession(ch, requests, home)
+	}
+}
+
+func serveSession(ch ssh.Channel, requests <-chan *ssh.Request, home string) {
+	defer ch.Close()
+	for req := range requests {
+		if req.Type != "exec" || len(req.Payload) < 4 {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		command := string(req.Payload[4 : 4+binary.BigEndian.Uint32(req.Payload)])
+		_ = req.Reply(true, nil)
+
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Env = []string{"HOME=" + home, "PATH=" + os.Getenv("PATH")}
+		cmd.Stdin = ch
+		cmd.Stdout = ch
+		cmd.Stderr = ch.Stderr()
+		status := uint32(0)
+		if err := cmd.Run(); err != nil {
+			status = 255
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				status = uint32(exitErr.ExitCode())
+			}
+		}
+		_, _ = ch.SendRequest("exit-status", false, binary.BigEndian.AppendUint32(nil, status))
+		return
+	}
+}
+
+func newSSHStore(t *testing.T) (SSHStore, string) {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	home := t.TempDir()
+	addr := startSSHServer(t, home)
+	store := SSHStore{Command: func(remote string) *exec.Cmd {
+		cmd := exec.Command(os.Args[0], "-test.run=^$", remote)
+		cmd.Env = append(os.Environ(), sshClientEnv+"="+addr)
+		return cmd
+	}}
+	return store, filepath.Join(home, ".ssh", "authorized_keys")
+}
+
+func TestSSHStoreEdits(t *testing.T) {
+	store, path := newSSHStore(t)
+
+	content, version, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load without a file: %v", err)
+	}
+	if len(content) != 0 || version != "missing" {
+		t.Fatalf("Load = %q, %q", content, version)
+	}
+
+	outside := newKey(t) + " admin\n"
+	if err := store.Save([]byte(outside), "missing"); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := Add(store, Grant{Name: "alice", PublicKey: newKey(t), Permits: []string{`permitopen="db:5432"`}}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	grants, err := List(store)
+	if err != nil || len(grants) != 1 || grants[0].Name != "alice" {
+		t.Fatalf("List = %+v, %v", grants, err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), outside+sectionBegin+"\n") {
+		t.Fatalf("authorized_keys:\n%s", data)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("mode = %v, want 0600", info.Mode().Perm())
+	}
+	backup, err := os.ReadFile(path + ".rpa-bak")
+	if err != nil || string(backup) != outside {
+		t.Fatalf("backup = %q, %v", backup, err)
+	}
+	leftovers, _ := filepath.Glob(path + ".rpa-tmp*")
+	if len(leftovers) != 0 {
+		t.Fatalf("temporary files left: %v", leftovers)
+	}
+
+	if _, err := Revoke(store, "alice"); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != outside {
+		t.Fatalf("after revoke:\n%s", data)
+	}
+}
+
+func TestSSHStoreConflict(t *testing.T) {
+	store, path := newSSHStore(t)
+	original := newKey(t) + " admin\n"
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(original), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	content, version, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != original {
+		t.Fatalf("Load = %q", content)
+	}
+	changed := original + newKey(t) + " added meanwhile\n"
+	if err := os.WriteFile(path, []byte(changed), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Save([]byte("replacement\n"), version); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Save = %v, want ErrConflict", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != changed {
+		t.Fatalf("conflicting save overwrote the file:\n%s", data)
+	}
+	leftovers, _ := filepath.Glob(path + ".rpa-tmp*")
+	if len(leftovers) != 0 {
+		t.Fatalf("temporary files left: %v", leftovers)
+	}
+}
+
+func TestSSHStoreSerializesSaves(t *testing.T) {
+	store, path := newSSHStore(t)
+	_, version, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every writer read the same version, so exactly one may win.
+	const writers = 4
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		line := newKey(t) + " writer\n"
+		go func() {
+			errs <- store.Save([]byte(line), version)
+		}()
+	}
+	saved := 0
+	for i := 0; i < writers; i++ {
+		switch err := <-errs; {
+		case err == nil:
+			saved++
+		case !errors.Is(err, ErrConflict):
+			t.Errorf("Save: %v", err)
+		}
+	}
+	if saved != 1 {
+		t.Fatalf("%d saves succeeded, want 1", saved)
+	}
+	if data, _ := os.ReadFile(path); strings.Count(string(data), "\n") != 1 {
+		t.Fatalf("authorized_keys:\n%s", data)
+	}
+}
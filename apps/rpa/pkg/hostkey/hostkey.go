@@ -0,0 +1,229 @@
+// Package hostkey maintains rpa's own known_hosts file for pinned server keys.
+// For a pinned host the file holds only keys whose SHA256 fingerprint is pinned, plus an optional @cert-authority line.
+
+package hostkey
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Fingerprint returns the OpenSSH SHA256 fingerprint of key.
+func Fingerprint(key ssh.PublicKey) string {
+	return ssh.FingerprintSHA256(key)
+}
+
+// ValidFingerprint reports whether value looks like "SHA256:<base64>".
+func ValidFingerprint(value string) bool {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(value), "SHA256:")
+	if !ok {
+		return false
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	return err == nil && len(raw) == 32
+}
+
+// SameFingerprint compares fingerprints, ignoring base64 padding.
+func SameFingerprint(a, b string) bool {
+	return strings.TrimRight(strings.TrimSpace(a), "=") == strings.TrimRight(strings.TrimSpace(b), "=")
+}
+
+// ParseCA reads a CA public key given as an authorized_keys line or a path
+// to a .pub file.
+func ParseCA(value string) (ssh.PublicKey, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, errors.New("empty host key CA")
+	}
+	data := []byte(value)
+	if !strings.HasPrefix(value, "ssh-") && !strings.HasPrefix(value, "ecdsa-") && !strings.HasPrefix(value, "sk-") {
+		path := value
+		if strings.HasPrefix(path, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, fmt.Errorf("resolve home dir: %w", err)
+			}
+			path = filepath.Join(home, path[2:])
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read host key CA: %w", err)
+		}
+		data = b
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse host key CA: %w", err)
+	}
+	return key, nil
+}
+
+// Pattern is the known_hosts name ssh looks up for host and port.
+func Pattern(host string, port int) string {
+	return knownhosts.Normalize(net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+// Keys returns the plain (non-CA, non-revoked) keys path records for host
+// and port. A missing file has no keys.
+func Keys(path, host string, port int) ([]ssh.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	pattern := Pattern(host, port)
+	var keys []ssh.PublicKey
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		marker, hosts, key, _, _, err := ssh.ParseKnownHosts(line)
+		if err != nil || marker != "" || !matchesAny(hosts, pattern) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Sync rewrites path so that host and port keep only the keys whose
+// fingerprint is in pins, followed by a @cert-authority line for ca when it
+// is set. Entries for other hosts and comments are left alone. It returns
+// the number of pinned keys present.
+func Sync(path, host string, port int, pins []string, ca ssh.PublicKey) (int, error) {
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("read %s: %w", path, err)
+	}
+	pattern := Pattern(host, port)
+	var out bytes.Buffer
+	var keep []ssh.PublicKey
+	scanner := bufio.NewScanner(bytes.NewReader(existing))
+	for scanner.Scan() {
+		line := scanner.Text()
+		marker, hosts, key, _, _, err := ssh.ParseKnownHosts([]byte(line))
+		if err == nil && matchesAny(hosts, pattern) {
+			if marker == "" && pinned(key, pins) && !containsKey(keep, key) {
+				keep = append(keep, key)
+			}
+			continue
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("read %s: %w", path, err)
+	}
+	for _, key := range keep {
+		out.WriteString(knownhosts.Line([]string{pattern}, key))
+		out.WriteByte('\n')
+	}
+	if ca != nil {
+		out.WriteString("@cert-authority " + pattern + " " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(ca))))
+		out.WriteByte('\n')
+	}
+	if bytes.Equal(out.Bytes(), existing) {
+		return len(keep), nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return 0, fmt.Errorf("create %s: %w", filepath.Dir(path), err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, out.Bytes(), 0o600); err != nil {
+		return 0, fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return 0, fmt.Errorf("write %s: %w", path, err)
+	}
+	return len(keep), nil
+}
+
+// Add appends keys for host and port to path, skipping ones already present.
+func Add(path, host string, port int, keys []ssh.PublicKey) error {
+	present, err := Keys(path, host, port)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	var out bytes.Buffer
+	for _, key := range keys {
+		if containsKey(present, key) {
+			continue
+		}
+		present = append(present, key)
+		out.WriteString(knownhosts.Line([]string{Pattern(host, port)}, key))
+		out.WriteByte('\n')
+	}
+	if out.Len() == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("create %s: %w", filepath.Dir(path), err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", path, err)
+	}
+	if _, err := f.Write(out.Bytes()); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+func pinned(key ssh.PublicKey, pins []string) bool {
+	fp := Fingerprint(key)
+	for _, pin := range pins {
+		if SameFingerprint(fp, pin) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsKey(keys []ssh.PublicKey, key ssh.PublicKey) bool {
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAny reports whether any known_hosts host entry names pattern,
+// including entries hashed by HashKnownHosts.
+func matchesAny(hosts []string, pattern string) bool {
+	for _, h := range hosts {
+		if h == pattern {
+			return true
+		}
+		if hashed, ok := strings.CutPrefix(h, "|1|"); ok {
+			saltText, hashText, ok := strings.Cut(hashed, "|")
+			if !ok {
+				continue
+			}
+			salt, err1 := base64.StdEncoding.DecodeString(saltText)
+			want, err2 := base64.StdEncoding.DecodeString(hashText)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			mac := hmac.New(sha1.New, salt)
+			mac.Write([]byte(pattern))
+			if hmac.Equal(mac.Sum(nil), want) {
+				return true
+			}
+		}
+	}
+	return false
+}
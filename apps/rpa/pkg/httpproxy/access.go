@@ -0,0 +1,330 @@
+// Package httpproxy gates routes with basic auth, bearer tokens, and signed expiring links.
+// A route without access rules is open; otherwise any one matching rule admits the request.
+
+package httpproxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AccessBasic  = "basic"
+	AccessBearer = "bearer"
+	AccessLink   = "link"
+
+	// LinkParam carries a signed link token; LinkCookie keeps it for follow-up requests.
+	LinkParam  = "rpa_token"
+	LinkCookie = "rpa_access"
+
+	basicCacheTTL = 5 * time.Minute
+	failureWindow = time.Minute
+	// maxFailureKeys bounds the client+route pairs tracked for lockout.
+	maxFailureKeys = 4096
+)
+
+// AccessRule is one credential for a route. Secrets are stored hashed:
+// PasswordHash is bcrypt and TokenHash is hex SHA-256.
+type AccessRule struct {
+	ID           string
+	Type         string
+	Username     string
+	PasswordHash string
+	TokenHash    string
+	ExpiresAt    time.Time
+}
+
+func (r AccessRule) Expired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && now.After(r.ExpiresAt)
+}
+
+// HashToken returns the stored form of a bearer token.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// HashPassword returns the bcrypt hash stored for basic auth.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// SignLink returns the token for a link rule; it is bound to the route and expiry.
+func SignLink(secret []byte, route Route, rule AccessRule) string {
+	exp := strconv.FormatInt(rule.ExpiresAt.Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(route.Key() + "\n" + rule.ID + "\n" + exp))
+	sig := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	return rule.ID + "." + exp + "." + sig
+}
+
+// gate tracks verified basic credentials and recent failures per client and
+// route, so one client's failures do not lock everyone else out of a route.
+type gate struct {
+	mu       sync.Mutex
+	verified map[string]time.Time
+	failures map[string][]time.Time
+}
+
+func newGate() *gate {
+	return &gate{
+		verified: make(map[string]time.Time),
+		failures: make(map[string][]time.Time),
+	}
+}
+
+func failureKey(client, routeKey string) string {
+	return client + "\n" + routeKey
+}
+
+func (g *gate) locked(key string, limit int, now time.Time) bool {
+	if limit <= 0 {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	recent := pruneFailures(g.failures[key], now)
+	if len(recent) == 0 {
+		delete(g.failures, key)
+		return false
+	}
+	g.failures[key] = recent
+	return len(recent) >= limit
+}
+
+func (g *gate) fail(key string, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.failures[key]; !ok && len(g.failures) >= maxFailureKeys {
+		g.sweep(now)
+	}
+	g.failures[key] = append(pruneFailures(g.failures[key], now), now)
+}
+
+// sweep drops stale entries and, if the table is still full, the client whose
+// last failure is oldest. It is called with mu held.
+func (g *gate) sweep(now time.Time) {
+	for key, until := range g.verified {
+		if !now.Before(until) {
+			delete(g.verified, key)
+		}
+	}
+	oldestKey := ""
+	var oldest time.Time
+	for key, list := range g.failures {
+		list = pruneFailures(list, now)
+		if len(list) == 0 {
+			delete(g.failures, key)
+			continue
+		}
+		g.failures[key] = list
+		if last := list[len(list)-1]; oldestKey == "" || last.Before(oldest) {
+			oldestKey, oldest = key, last
+		}
+	}
+	if len(g.failures) >= maxFailureKeys {
+		delete(g.failures, oldestKey)
+	}
+}
+
+func pruneFailures(list []time.Time, now time.Time) []time.Time {
+	i := 0
+	for i < len(list) && now.Sub(list[i]) > failureWindow {
+		i++
+	}
+	return list[i:]
+}
+
+// checkBasic compares against bcrypt, caching successes briefly because
+// browsers resend credentials on every request.
+func (g *gate) checkBasic(rule AccessRule, user, password string, now time.Time) bool {
+	if subtle.ConstantTimeCompare([]byte(user), []byte(rule.Username)) != 1 {
+		return false
+	}
+	key := rule.ID + "\n" + rule.PasswordHash + "\n" + HashToken(password)
+	g.mu.Lock()
+	until, ok := g.verified[key]
+	g.mu.Unlock()
+	if ok && now.Before(until) {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(rule.PasswordHash), []byte(password)) != nil {
+		return false
+	}
+	g.mu.Lock()
+	g.verified[key] = now.Add(basicCacheTTL)
+	g.mu.Unlock()
+	return true
+}
+
+// authorize returns the ID of the admitting rule, or a denial reason.
+func (p *Proxy) authorize(route Route, r *http.Request, now time.Time) (string, string) {
+	user, password, hasBasic := r.BasicAuth()
+	bearer := ""
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		bearer = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	link := r.URL.Query().Get(LinkParam)
+	if link == "" {
+		if c, err := r.Cookie(LinkCookie); err == nil {
+			link = c.Value
+		}
+	}
+	if !hasBasic && bearer == "" && link == "" {
+		return "", "missing_credentials"
+	}
+
+	p.mu.RLock()
+	secret := p.linkSecret
+	p.mu.RUnlock()
+	reason := "invalid_credentials"
+	for _, rule := range route.Access {
+		if rule.Expired(now) {
+			continue
+		}
+		switch rule.Type {
+		case AccessBasic:
+			if hasBasic && p.gate.checkBasic(rule, user, password, now) {
+				return rule.ID, ""
+			}
+		case AccessBearer:
+			if bearer != "" && subtle.ConstantTimeCompare([]byte(HashToken(bearer)), []byte(rule.TokenHash)) == 1 {
+				return rule.ID, ""
+			}
+		case AccessLink:
+			if link == "" || len(secret) == 0 || !strings.HasPrefix(link, rule.ID+".") {
+				continue
+			}
+			if hmac.Equal([]byte(link), []byte(SignLink(secret, route, rule))) {
+				return rule.ID, ""
+			}
+			reason = "invalid_link"
+		}
+	}
+	return "", reason
+}
+
+// gateRequest enforces route access and reports whether the request may be proxied.
+func (p *Proxy) gateRequest(w http.ResponseWriter, r *http.Request, route Route) bool {
+	if len(route.Access) == 0 {
+		return true
+	}
+	now := time.Now()
+	client := clientAddr(r)
+	key := failureKey(client, route.Key())
+	p.mu.RLock()
+	limit := p.maxFailures
+	p.mu.RUnlock()
+	if p.gate.locked(key, limit, now) {
+		p.event("WARN", "access_rate_limited", map[string]any{"host": r.Host, "path": r.URL.Path, "client": client})
+		w.Header().Set("Retry-After", strconv.Itoa(int(failureWindow.Seconds())))
+		http.Error(w, "too many failed attempts", http.StatusTooManyRequests)
+		return false
+	}
+	id, reason := p.authorize(route, r, now)
+	if id == "" {
+		if reason != "missing_credentials" {
+			p.gate.fail(key, now)
+		}
+		p.event("WARN", "access_denied", map[string]any{
+			"host":   r.Host,
+			"path":   r.URL.Path,
+			"reason": reason,
+			"client": client,
+		})
+		for _, rule := range route.Access {
+			if rule.Type == AccessBasic {
+				w.Header().Set("WWW-Authenticate", `Basic realm="rpa"`)
+				break
+			}
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	if token := r.URL.Query().Get(LinkParam); token != "" {
+		expires := now.Add(24 * time.Hour)
+		for _, rule := range route.Access {
+			if rule.ID == id && !rule.ExpiresAt.IsZero() {
+				expires = rule.ExpiresAt
+			}
+		}
+		path := route.PathPrefix
+		if path == "" {
+			path = "/"
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     LinkCookie,
+			Value:    token,
+			Path:     path,
+			Expires:  expires,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		clean := *r.URL
+		q := clean.Query()
+		q.Del(LinkParam)
+		clean.RawQuery = q.Encode()
+		http.Redirect(w, r, (&url.URL{Path: clean.Path, RawQuery: clean.RawQuery}).String(), http.StatusFound)
+		return false
+	}
+
+	r.Header.Del("Authorization")
+	stripCookie(r, LinkCookie)
+	return true
+}
+
+// clientAddr identifies the client for failure counting: the connection's
+// source (the ssh originator when the agent resolves one), or, when that is a
+// loopback peer such as a reverse proxy on the server, the address it put last
+// in X-Forwarded-For. Unresolved sources share one counter.
+func clientAddr(r *http.Request) string {
+	source := r.RemoteAddr
+	if resolve, ok := r.Context().Value(sourceKey{}).(func() string); ok {
+		if s := resolve(); s != "" {
+			source = s
+		}
+	}
+	host := source
+	if h, _, err := net.SplitHostPort(source); err == nil {
+		host = h
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && ip.IsLoopback() {
+		if hops := r.Header.Values("X-Forwarded-For"); len(hops) > 0 {
+			list := strings.Split(hops[len(hops)-1], ",")
+			if last := strings.TrimSpace(list[len(list)-1]); last != "" {
+				return last
+			}
+		}
+	}
+	return host
+}
+
+func stripCookie(r *http.Request, name string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name != name {
+			r.AddCookie(c)
+		}
+	}
+}
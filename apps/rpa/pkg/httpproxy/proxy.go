@@ -0,0 +1,302 @@
+// Package httpproxy routes HTTP requests by Host header and path prefix to local targets.
+// The agent exposes it through a single remote forward so many web apps share one port.
+
+package httpproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"reverse-proxy-agent/pkg/logging"
+)
+
+// Route sends requests whose host matches Host (exact, or `*.domain` for
+// subdomains) and whose path starts with PathPrefix to Target.
+type Route struct {
+	Host       string
+	PathPrefix string
+	Target     string
+	Access     []AccessRule
+}
+
+// Key identifies a route; two routes with the same key replace each other.
+func (r Route) Key() string {
+	return NormalizeHost(r.Host) + r.PathPrefix
+}
+
+type sourceKey struct{}
+
+type entry struct {
+	route Route
+	proxy *httputil.ReverseProxy
+}
+
+type Proxy struct {
+	Addr string
+	// TLSConfig, when set, makes the proxy serve HTTPS only.
+	TLSConfig *tls.Config
+	// Source is called in accept order for each accepted connection and
+	// returns a function that resolves its originating peer; that runs when
+	// a request on the connection first needs it, never on the accept path.
+	// The source defaults to the socket's remote address.
+	Source func(net.Conn) func() string
+
+	logger *logging.Logger
+
+	gate *gate
+
+	mu          sync.RWMutex
+	entries     []entry
+	server      *http.Server
+	done        chan struct{}
+	linkSecret  []byte
+	maxFailures int
+}
+
+func New(addr string, logger *logging.Logger) *Proxy {
+	return &Proxy{Addr: addr, logger: logger, gate: newGate()}
+}
+
+// SetAccessOptions sets the key for signed links and how many failed
+// attempts per minute a route tolerates before answering 429.
+func (p *Proxy) SetAccessOptions(linkSecret []byte, maxFailuresPerMin int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.linkSecret = append([]byte(nil), linkSecret...)
+	p.maxFailures = maxFailuresPerMin
+}
+
+// SetAccess replaces the access rules of an existing route.
+func (p *Proxy) SetAccess(host, pathPrefix string, rules []AccessRule) bool {
+	key := Route{Host: host, PathPrefix: pathPrefix}.Key()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.entries {
+		if p.entries[i].route.Key() == key {
+			p.entries[i].route.Access = append([]AccessRule(nil), rules...)
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTarget accepts `host:port` or an http(s) URL.
+func ParseTarget(target string) (*url.URL, error) {
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return nil, errors.New("target is required")
+	}
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target %q: %w", target, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid target %q: scheme must be http or https", target)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid target %q: host is required", target)
+	}
+	return u, nil
+}
+
+// NormalizeHost lowercases a host and drops any port.
+func NormalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+func (p *Proxy) newEntry(route Route) (entry, error) {
+	target, err := ParseTarget(route.Target)
+	if err != nil {
+		return entry{}, err
+	}
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			p.event("WARN", "http_proxy_error", map[string]any{
+				"host":   r.Host,
+				"path":   r.URL.Path,
+				"target": route.Target,
+				"error":  err.Error(),
+			})
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+		},
+	}
+	return entry{route: route, proxy: rp}, nil
+}
+
+// SetRoutes replaces the routing table.
+func (p *Proxy) SetRoutes(routes []Route) error {
+	entries := make([]entry, 0, len(routes))
+	for _, route := range routes {
+		e, err := p.newEntry(route)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, e)
+	}
+	p.mu.Lock()
+	p.entries = entries
+	p.mu.Unlock()
+	return nil
+}
+
+// Add inserts a route, replacing one with the same host and path prefix.
+// It reports whether the route was new.
+func (p *Proxy) Add(route Route) (bool, error) {
+	e, err := p.newEntry(route)
+	if err != nil {
+		return false, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.entries {
+		if p.entries[i].route.Key() == route.Key() {
+			p.entries[i] = e
+			return false, nil
+		}
+	}
+	p.entries = append(p.entries, e)
+	return true, nil
+}
+
+func (p *Proxy) Remove(host, pathPrefix string) bool {
+	key := Route{Host: host, PathPrefix: pathPrefix}.Key()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.entries {
+		if p.entries[i].route.Key() == key {
+			p.entries = append(p.entries[:i], p.entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Proxy) Routes() []Route {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make([]Route, 0, len(p.entries))
+	for _, e := range p.entries {
+		out = append(out, e.route)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key() < out[j].Key() })
+	return out
+}
+
+// match picks the most specific route: exact hosts beat wildcards, then the
+// longest path prefix wins.
+func (p *Proxy) match(host, path string) (entry, bool) {
+	host = NormalizeHost(host)
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	best := -1
+	bestScore := -1
+	for i, e := range p.entries {
+		if !strings.HasPrefix(path, e.route.PathPrefix) {
+			continue
+		}
+		routeHost := NormalizeHost(e.route.Host)
+		score := len(e.route.PathPrefix)
+		switch {
+		case routeHost == host:
+			score += 1 << 20
+		case strings.HasPrefix(routeHost, "*.") && strings.HasSuffix(host, routeHost[1:]):
+		default:
+			continue
+		}
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if best < 0 {
+		return entry{}, false
+	}
+	return p.entries[best], true
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e, ok := p.match(r.Host, r.URL.Path)
+	if !ok {
+		http.Error(w, "no route for host", http.StatusNotFound)
+		return
+	}
+	if !p.gateRequest(w, r, e.route) {
+		return
+	}
+	e.proxy.ServeHTTP(w, r)
+}
+
+func (p *Proxy) Start() error {
+	ln, err := net.Listen("tcp", p.Addr)
+	if err != nil {
+		return fmt.Errorf("listen %s: %w", p.Addr, err)
+	}
+	if p.TLSConfig != nil {
+		ln = tls.NewListener(ln, p.TLSConfig)
+	}
+	server := &http.Server{Handler: p, ReadHeaderTimeout: 30 * time.Second}
+	if p.Source != nil {
+		source := p.Source
+		server.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, sourceKey{}, sync.OnceValue(source(c)))
+		}
+	}
+	done := make(chan struct{})
+	p.mu.Lock()
+	p.server = server
+	p.done = done
+	p.mu.Unlock()
+	go func() {
+		defer close(done)
+		if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			p.event("ERROR", "http_proxy_serve_failed", map[string]any{"error": err.Error()})
+		}
+	}()
+	return nil
+}
+
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	server := p.server
+	done := p.done
+	p.server = nil
+	p.mu.Unlock()
+	if server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if err != nil {
+		_ = server.Close()
+	}
+	<-done
+	return err
+}
+
+func (p *Proxy) event(level, name string, fields map[string]any) {
+	if p.logger == nil {
+		return
+	}
+	p.logger.Event(level, name, fields)
+}
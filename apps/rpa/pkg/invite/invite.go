@@ -0,0 +1,256 @@
+// Package invite builds and reads onboarding bundles created by `rpa invite`.
+// A bundle carries a client config fragment, a fresh key pair and the pinned host key; it can be sealed with a passphrase.
+
+package invite
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+	"gopkg.in/yaml.v3"
+
+	"reverse-proxy-agent/pkg/config"
+)
+
+const (
+	bundleVersion = 1
+
+	// scrypt parameters recommended for interactive use.
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// ErrPassphrase is returned when a sealed bundle cannot be opened.
+var ErrPassphrase = errors.New("wrong passphrase or damaged bundle")
+
+// namePattern matches names that are safe as a file name; the recipient's
+// key file is named after the bundle.
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// hopOptions are the jump host options a bundle may carry. Anything else
+// (ProxyCommand, LocalCommand, ...) could run commands on the recipient's
+// machine, so it never travels in a bundle.
+var hopOptions = map[string]bool{
+	"addressfamily":       true,
+	"compression":         true,
+	"connectionattempts":  true,
+	"connecttimeout":      true,
+	"serveralivecountmax": true,
+	"serveraliveinterval": true,
+	"tcpkeepalive":        true,
+}
+
+// HopOptionAllowed reports whether a jump host option may travel in a bundle.
+func HopOptionAllowed(opt string) bool {
+	key, _, _ := strings.Cut(strings.TrimSpace(opt), "=")
+	return hopOptions[strings.ToLower(strings.TrimSpace(key))]
+}
+
+// Bundle is everything a recipient needs to run `rpa client` against the
+// inviter's server.
+type Bundle struct {
+	Version             int               `yaml:"rpa_invite"`
+	Name                string            `yaml:"name"`
+	CreatedAt           time.Time         `yaml:"created_at"`
+	User                string            `yaml:"user"`
+	Host                string            `yaml:"host"`
+	Port                int               `yaml:"port"`
+	JumpHosts           []config.JumpHost `yaml:"jump_hosts,omitempty"`
+	HostKeyFingerprints []string          `yaml:"host_key_fingerprints,omitempty"`
+	HostKeyCA           string            `yaml:"host_key_ca,omitempty"`
+	HostKeys            []string          `yaml:"host_keys,omitempty"`
+	LocalForwards       []string          `yaml:"local_forwards,omitempty"`
+	DynamicForwards     []string          `yaml:"dynamic_forwards,omitempty"`
+	PrivateKey          string            `yaml:"private_key"`
+	PublicKey           string            `yaml:"public_key"`
+	AuthorizedKeys      string            `yaml:"authorized_keys"`
+}
+
+// sealed is the on-disk form of a passphrase-protected bundle.
+type sealed struct {
+	Version    int    `yaml:"rpa_invite"`
+	KDF        string `yaml:"kdf"`
+	Salt       string `yaml:"salt"`
+	Nonce      string `yaml:"nonce"`
+	Ciphertext string `yaml:"ciphertext"`
+}
+
+// Encode writes b as YAML, sealed with AES-256-GCM under a scrypt key when
+// passphrase is not empty.
+func Encode(b *Bundle, passphrase string) ([]byte, error) {
+	b.Version = bundleVersion
+	plain, err := yaml.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	if passphrase == "" {
+		return plain, nil
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(sealed{
+		Version:    bundleVersion,
+		KDF:        "scrypt",
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, plain, nil)),
+	})
+}
+
+// Sealed reports whether data needs a passphrase.
+func Sealed(data []byte) bool {
+	var s sealed
+	return yaml.Unmarshal(data, &s) == nil && len(s.Ciphertext) > 0
+}
+
+// Decode reads a bundle, opening it with passphrase when it is sealed.
+func Decode(data []byte, passphrase string) (*Bundle, error) {
+	var s sealed
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parse bundle: %w", err)
+	}
+	if len(s.Ciphertext) > 0 {
+		if s.KDF != "scrypt" {
+			return nil, fmt.Errorf("unsupported bundle kdf %q", s.KDF)
+		}
+		salt, err1 := base64.StdEncoding.DecodeString(s.Salt)
+		nonce, err2 := base64.StdEncoding.DecodeString(s.Nonce)
+		ciphertext, err3 := base64.StdEncoding.DecodeString(s.Ciphertext)
+		if err := errors.Join(err1, err2, err3); err != nil {
+			return nil, fmt.Errorf("parse bundle: %w", err)
+		}
+		aead, err := newAEAD(passphrase, salt)
+		if err != nil {
+			return nil, err
+		}
+		if len(nonce) != aead.NonceSize() {
+			return nil, ErrPassphrase
+		}
+		plain, err := aead.Open(nil, nonce, ciphertext, nil)
+		if err != nil {
+			return nil, ErrPassphrase
+		}
+		data = plain
+	}
+	var b Bundle
+	if err := yaml.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("parse bundle: %w", err)
+	}
+	if b.Version != bundleVersion {
+		return nil, fmt.Errorf("not an rpa invite bundle (version %d)", b.Version)
+	}
+	if b.Name == "" || b.Host == "" || b.User == "" || b.PrivateKey == "" {
+		return nil, errors.New("bundle is incomplete")
+	}
+	if !namePattern.MatchString(b.Name) {
+		return nil, fmt.Errorf("bundle name %q is not a valid name", b.Name)
+	}
+	for _, hop := range b.JumpHosts {
+		for _, opt := range hop.Options {
+			if !HopOptionAllowed(opt) {
+				return nil, fmt.Errorf("bundle jump host %s: option %q is not allowed", hop.Spec(), opt)
+			}
+		}
+	}
+	return &b, nil
+}
+
+func newAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Record is what the inviter keeps about an invitation, without the private
+// key, so it can be listed and revoked later.
+type Record struct {
+	Name           string     `yaml:"name"`
+	CreatedAt      time.Time  `yaml:"created_at"`
+	RevokedAt      *time.Time `yaml:"revoked_at,omitempty"`
+	User           string     `yaml:"user"`
+	Host           string     `yaml:"host"`
+	Fingerprint    string     `yaml:"fingerprint"`
+	PublicKey      string     `yaml:"public_key"`
+	AuthorizedKeys string     `yaml:"authorized_keys"`
+	LocalForwards  []string   `yaml:"local_forwards,omitempty"`
+}
+
+// Dir is where invitation records are kept.
+func Dir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("resolve home dir: %w", err)
+	}
+	return filepath.Join(home, ".rpa", "invites"), nil
+}
+
+// SaveRecord writes r to dir/<name>.yaml.
+func SaveRecord(dir string, r Record) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, r.Name+".yaml"), data, 0o600)
+}
+
+// LoadRecord reads the record of name; a missing one is os.ErrNotExist.
+func LoadRecord(dir, name string) (*Record, error) {
+	data, err := os.ReadFile(filepath.Join(dir, name+".yaml"))
+	if err != nil {
+		return nil, err
+	}
+	var r Record
+	if err := yaml.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("parse invite %s: %w", name, err)
+	}
+	return &r, nil
+}
+
+// Records returns every record in dir, by name.
+func Records(dir string) ([]Record, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	out := make([]Record, 0, len(matches))
+	for _, path := range matches {
+		r, err := LoadRecord(dir, strings.TrimSuffix(filepath.Base(path), ".yaml"))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *r)
+	}
+	return out, nil
+}
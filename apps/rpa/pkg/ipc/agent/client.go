@@ -31,8 +31,21 @@ func Query(cfg *config.Config, command string) (*Response, error) {
 	return send(cfg, command, nil)
 }
 
-func AddRemoteForward(cfg *config.Config, forward string) (*Response, error) {
+// AddRemoteForward sends the entry's metadata along with its spec so the
+// running agent can report it by name.
+func AddRemoteForward(cfg *config.Config, entry config.ForwardEntry) (*Response, error) {
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
 	return send(cfg, "add_forward", map[string]string{
+		"remote_forward": entry.Spec,
+		"entry":          string(meta),
+	})
+}
+
+func DisableRemoteForward(cfg *config.Config, forward string) (*Response, error) {
+	return send(cfg, "disable_forward", map[string]string{
 		"remote_forward": forward,
 	})
 }
@@ -47,6 +60,32 @@ func ClearRemoteForwards(cfg *config.Config) (*Response, error) {
 	return send(cfg, "clear_forwards", nil)
 }
 
+func AddRoute(cfg *config.Config, host, pathPrefix, target string) (*Response, error) {
+	return send(cfg, "add_route", map[string]string{
+		"host":        host,
+		"path_prefix": pathPrefix,
+		"target":      target,
+	})
+}
+
+func RemoveRoute(cfg *config.Config, host, pathPrefix string) (*Response, error) {
+	return send(cfg, "remove_route", map[string]string{
+		"host":        host,
+		"path_prefix": pathPrefix,
+	})
+}
+
+// SetRouteAccess replaces a route's access rules; access is a JSON array of
+// config.AccessConfig.
+func SetRouteAccess(cfg *config.Config, host, pathPrefix, access, linkSecret string) (*Response, error) {
+	return send(cfg, "set_access", map[string]string{
+		"host":        host,
+		"path_prefix": pathPrefix,
+		"access":      access,
+		"link_secret": linkSecret,
+	})
+}
+
 func send(cfg *config.Config, command string, args map[string]string) (*Response, error) {
 	socketPath, err := config.SocketPath(cfg)
 	if err != nil {
@@ -36,10 +36,16 @@ func Query(cfg *config.Config, command string) (*Response, error) {
 	return send(cfg, request{Command: command})
 }
 
-func AddLocalForward(cfg *config.Config, forward string) (*Response, error) {
+// AddLocalForward sends the entry's metadata along with its spec so the
+// running client can report it by name.
+func AddLocalForward(cfg *config.Config, entry config.ForwardEntry) (*Response, error) {
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
 	return send(cfg, request{
 		Command: "add_local_forward",
-		Args:    map[string]string{"local_forward": forward},
+		Args:    map[string]string{"local_forward": entry.Spec, "entry": string(meta)},
 	})
 }
 
@@ -50,6 +56,20 @@ func RemoveLocalForward(cfg *config.Config, forward string) (*Response, error) {
 	})
 }
 
+func AddDynamicForward(cfg *config.Config, forward string) (*Response, error) {
+	return send(cfg, request{
+		Command: "add_dynamic_forward",
+		Args:    map[string]string{"dynamic_forward": forward},
+	})
+}
+
+func RemoveDynamicForward(cfg *config.Config, forward string) (*Response, error) {
+	return send(cfg, request{
+		Command: "remove_dynamic_forward",
+		Args:    map[string]string{"dynamic_forward": forward},
+	})
+}
+
 func ClearLocalForwards(cfg *config.Config) (*Response, error) {
 	return send(cfg, request{Command: "clear_local_forwards"})
 }
@@ -0,0 +1,211 @@
+// Package probe runs protocol-aware end-to-end checks through forwarded ports.
+// It is used by the agent and client supervisors to detect black-holed tunnels.
+
+package probe
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"reverse-proxy-agent/pkg/config"
+)
+
+// DialFunc opens a stream to the far end of a forward.
+type DialFunc func(ctx context.Context) (io.ReadWriteCloser, error)
+
+type Probe struct {
+	Forward   string
+	Type      string
+	Interval  time.Duration
+	Timeout   time.Duration
+	Threshold int
+
+	cfg  config.ProbeConfig
+	dial DialFunc
+}
+
+// postgresSSLRequestCode goes in the 8-byte SSLRequest startup packet; any
+// server replies with a single 'S' or 'N' byte without needing credentials.
+const postgresSSLRequestCode = 80877103
+
+// Status is the latest result of a probe. Ordinal is the probe's 1-based
+// position among the probes of its forward, so several probes on one
+// forward keep separate statuses.
+type Status struct {
+	Forward   string
+	Ordinal   int
+	Type      string
+	Status    string
+	Error     string
+	Failures  int
+	LastCheck time.Time
+}
+
+// StatusKey is the status key of st, given the label of its forward.
+func StatusKey(label string, st Status) string {
+	return fmt.Sprintf("probe.%s#%d", label, st.Ordinal)
+}
+
+// FormatStatus renders st for `rpa status`.
+func FormatStatus(st Status) string {
+	out := fmt.Sprintf("%s %s", st.Type, st.Status)
+	if st.Failures > 0 {
+		out += fmt.Sprintf(" failures=%d", st.Failures)
+	}
+	if !st.LastCheck.IsZero() {
+		out += fmt.Sprintf(" checked_unix=%d", st.LastCheck.Unix())
+	}
+	if st.Error != "" {
+		out += ": " + st.Error
+	}
+	return out
+}
+
+func New(cfg config.ProbeConfig, dial DialFunc) *Probe {
+	threshold := cfg.FailureThreshold
+	if threshold <= 0 {
+		threshold = 1
+	}
+	return &Probe{
+		Forward:   strings.TrimSpace(cfg.Forward),
+		Type:      cfg.Type,
+		Interval:  time.Duration(cfg.IntervalSec) * time.Second,
+		Timeout:   time.Duration(cfg.TimeoutMs) * time.Millisecond,
+		Threshold: threshold,
+		cfg:       cfg,
+		dial:      dial,
+	}
+}
+
+func TCPDialer(addr string) DialFunc {
+	return func(ctx context.Context) (io.ReadWriteCloser, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", addr)
+	}
+}
+
+// Check runs a single probe, closing the stream when the timeout elapses so
+// blocked reads on black-holed tunnels return promptly.
+func (p *Probe) Check(ctx context.Context) error {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	conn, err := p.dial(ctx)
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+	defer conn.Close()
+
+	err = p.speak(conn)
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("%s probe timed out", p.Type)
+	}
+	return err
+}
+
+func (p *Probe) speak(conn io.ReadWriteCloser) error {
+	switch p.Type {
+	case "tcp":
+		return nil
+	case "banner":
+		return expectBanner(conn, p.cfg.Expect)
+	case "http":
+		return expectHTTP(conn, p.cfg)
+	case "redis":
+		return expectRedis(conn)
+	case "postgres":
+		return expectPostgres(conn)
+	default:
+		return fmt.Errorf("unknown probe type %q", p.Type)
+	}
+}
+
+func expectBanner(conn io.Reader, expect string) error {
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && line == "" {
+		return fmt.Errorf("read banner: %w", err)
+	}
+	line = strings.TrimSpace(line)
+	if expect != "" && !strings.HasPrefix(line, expect) {
+		return fmt.Errorf("unexpected banner %q (want prefix %q)", truncate(line, 64), expect)
+	}
+	return nil
+}
+
+func expectHTTP(conn io.ReadWriter, cfg config.ProbeConfig) error {
+	host := "localhost"
+	if cfg.Address != "" {
+		host = cfg.Address
+	}
+	req := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\nUser-Agent: rpa-probe\r\nConnection: close\r\n\r\n", cfg.Path, host)
+	if _, err := io.WriteString(conn, req); err != nil {
+		return fmt.Errorf("write request: %w", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != cfg.ExpectStatus {
+		return fmt.Errorf("unexpected status %d (want %d)", resp.StatusCode, cfg.ExpectStatus)
+	}
+	return nil
+}
+
+func expectRedis(conn io.ReadWriter) error {
+	if _, err := io.WriteString(conn, "PING\r\n"); err != nil {
+		return fmt.Errorf("write ping: %w", err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && line == "" {
+		return fmt.Errorf("read reply: %w", err)
+	}
+	line = strings.TrimSpace(line)
+	// -NOAUTH still proves a redis server answered through the tunnel.
+	if line != "+PONG" && !strings.HasPrefix(line, "-NOAUTH") {
+		return fmt.Errorf("unexpected reply %q", truncate(line, 64))
+	}
+	return nil
+}
+
+func expectPostgres(conn io.ReadWriter) error {
+	packet := make([]byte, 8)
+	binary.BigEndian.PutUint32(packet[0:4], 8)
+	binary.BigEndian.PutUint32(packet[4:8], postgresSSLRequestCode)
+	if _, err := conn.Write(packet); err != nil {
+		return fmt.Errorf("write startup: %w", err)
+	}
+	reply := make([]byte, 1)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("read startup reply: %w", err)
+	}
+	if reply[0] != 'S' && reply[0] != 'N' {
+		return fmt.Errorf("unexpected startup reply %q", reply[0])
+	}
+	return nil
+}
+
+func truncate(value string, limit int) string {
+	if len(value) > limit {
+		return value[:limit]
+	}
+	return value
+}
@@ -0,0 +1,103 @@
+// Package relay enforces per-forward connection and bandwidth limits.
+// Limits are token buckets shared by every connection on one listener.
+
+package relay
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limits caps one listener; zero values mean unlimited.
+type Limits struct {
+	MaxConnections    int
+	ConnectionsPerMin int
+	BytesPerSec       int64
+}
+
+const copyBufferSize = 32 * 1024
+
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate, burst float64) *bucket {
+	return &bucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (b *bucket) refillLocked(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+func (b *bucket) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refillLocked(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// wait takes n tokens, sleeping until they are available. n must not exceed burst.
+func (b *bucket) wait(ctx context.Context, n int) error {
+	for {
+		b.mu.Lock()
+		b.refillLocked(time.Now())
+		if b.tokens >= float64(n) {
+			b.tokens -= float64(n)
+			b.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((float64(n) - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// copyLimited is io.Copy throttled by a shared byte bucket.
+func copyLimited(ctx context.Context, dst io.Writer, src io.Reader, b *bucket) (int64, error) {
+	if b == nil {
+		return io.Copy(dst, src)
+	}
+	size := copyBufferSize
+	if int(b.burst) < size {
+		size = int(b.burst)
+	}
+	buf := make([]byte, size)
+	var written int64
+	for {
+		n, rerr := src.Read(buf)
+		if n > 0 {
+			if err := b.wait(ctx, n); err != nil {
+				return written, err
+			}
+			m, werr := dst.Write(buf[:n])
+			written += int64(m)
+			if werr != nil {
+				return written, werr
+			}
+		}
+		if rerr != nil {
+			if rerr == io.EOF {
+				return written, nil
+			}
+			return written, rerr
+		}
+	}
+}
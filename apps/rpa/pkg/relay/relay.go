@@ -0,0 +1,318 @@
+// Package relay accepts TCP connections on rpa-owned sockets and pipes them upstream.
+// It backs client managed forwards and agent relays in front of remote forward targets.
+
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"reverse-proxy-agent/pkg/logging"
+)
+
+// DialFunc opens the upstream side for one accepted connection.
+type DialFunc func(ctx context.Context) (io.ReadWriteCloser, error)
+
+// Conn describes one connection; Duration, byte counts and Err are only set
+// once it has finished.
+type Conn struct {
+	Source   string
+	Started  time.Time
+	Duration time.Duration
+	BytesIn  int64
+	BytesOut int64
+	Err      error
+}
+
+type Listener struct {
+	Name        string
+	Addr        string
+	DialTimeout time.Duration
+	Stats       *Stats
+	Limits      Limits
+
+	// Source is called in accept order for each accepted connection and
+	// returns a function that resolves its originating peer, which runs on
+	// the connection's own goroutine. The source defaults to the socket's
+	// remote address.
+	Source func(net.Conn) func() string
+	// OnAccept is called once a connection is admitted, before dialing and
+	// relaying, so it is seen even if the connection never ends.
+	OnAccept func(Conn)
+	// OnDone is called after each connection closes, including failed dials.
+	OnDone func(Conn)
+	// Handshake runs on an admitted connection before dialing; an error
+	// rejects the connection. A non-nil DialFunc replaces the listener's dial
+	// for this connection.
+	Handshake func(net.Conn) (DialFunc, error)
+
+	dial   DialFunc
+	logger *logging.Logger
+
+	mu        sync.Mutex
+	ln        net.Listener
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+	cancel    context.CancelFunc
+	rate      *bucket
+	bandwidth *bucket
+}
+
+func NewListener(name, addr string, dial DialFunc, logger *logging.Logger) *Listener {
+	return &Listener{
+		Name:        name,
+		Addr:        addr,
+		DialTimeout: 30 * time.Second,
+		Stats:       &Stats{},
+		dial:        dial,
+		logger:      logger,
+		conns:       make(map[net.Conn]struct{}),
+	}
+}
+
+func (l *Listener) Start() error {
+	ln, err := net.Listen("tcp", l.Addr)
+	if err != nil {
+		return fmt.Errorf("listen %s: %w", l.Addr, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	l.mu.Lock()
+	l.ln = ln
+	l.cancel = cancel
+	if l.Limits.ConnectionsPerMin > 0 {
+		perMin := float64(l.Limits.ConnectionsPerMin)
+		l.rate = newBucket(perMin/60, perMin)
+	}
+	if l.Limits.BytesPerSec > 0 {
+		bps := float64(l.Limits.BytesPerSec)
+		l.bandwidth = newBucket(bps, bps)
+	}
+	l.mu.Unlock()
+
+	l.wg.Add(1)
+	go l.acceptLoop(ctx, ln)
+	return nil
+}
+
+// Close stops accepting and tears down in-flight connections.
+func (l *Listener) Close() error {
+	l.mu.Lock()
+	ln := l.ln
+	cancel := l.cancel
+	l.ln = nil
+	for conn := range l.conns {
+		_ = conn.Close()
+	}
+	l.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	var err error
+	if ln != nil {
+		err = ln.Close()
+	}
+	l.wg.Wait()
+	return err
+}
+
+func (l *Listener) acceptLoop(ctx context.Context, ln net.Listener) {
+	defer l.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			l.event("ERROR", "listener_accept_failed", map[string]any{"error": err.Error()})
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		if !l.track(conn) {
+			_ = conn.Close()
+			return
+		}
+		var source func() string
+		if l.Source != nil {
+			source = l.Source(conn)
+		}
+		l.wg.Add(1)
+		go func() {
+			defer l.wg.Done()
+			defer l.untrack(conn)
+			l.handle(ctx, conn, source)
+		}()
+	}
+}
+
+// BoundAddr returns the address actually listened on, which differs from Addr
+// when Addr asks for port 0.
+func (l *Listener) BoundAddr() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ln == nil {
+		return ""
+	}
+	return l.ln.Addr().String()
+}
+
+func (l *Listener) handle(ctx context.Context, conn net.Conn, resolve func() string) {
+	started := time.Now()
+	source := conn.RemoteAddr().String()
+	if resolve != nil {
+		source = resolve()
+	}
+	if reason := l.admit(); reason != "" {
+		l.Stats.reject()
+		l.event("WARN", "listener_rejected", map[string]any{
+			"source": source,
+			"reason": reason,
+		})
+		_ = conn.Close()
+		l.finish(Conn{Source: source, Started: started, Err: fmt.Errorf("rejected: %s", reason)})
+		return
+	}
+	dial := l.dial
+	if l.Handshake != nil {
+		override, err := l.Handshake(conn)
+		if err != nil {
+			l.Stats.reject()
+			l.event("WARN", "listener_handshake_failed", map[string]any{
+				"forward": l.Name,
+				"source":  source,
+				"error":   err.Error(),
+			})
+			_ = conn.Close()
+			l.finish(Conn{Source: source, Started: started, Err: fmt.Errorf("handshake: %w", err)})
+			return
+		}
+		if override != nil {
+			dial = override
+		}
+	}
+	l.Stats.accept(started)
+	if l.OnAccept != nil {
+		l.OnAccept(Conn{Source: source, Started: started})
+	}
+	dialCtx := ctx
+	if l.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = context.WithTimeout(ctx, l.DialTimeout)
+		defer cancel()
+	}
+	upstream, err := dial(dialCtx)
+	if err != nil {
+		l.Stats.connectFailed()
+		l.Stats.done(time.Since(started))
+		l.event("WARN", "listener_connect_failed", map[string]any{
+			"forward": l.Name,
+			"source":  source,
+			"error":   err.Error(),
+		})
+		_ = conn.Close()
+		l.finish(Conn{Source: source, Started: started, Duration: time.Since(started), Err: err})
+		return
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = upstream.Close()
+	})
+	defer stop()
+	in, out := pipe(ctx, conn, upstream, l.bandwidth, l.Stats)
+	l.Stats.done(time.Since(started))
+	l.finish(Conn{Source: source, Started: started, Duration: time.Since(started), BytesIn: in, BytesOut: out})
+}
+
+// admit applies Limits to a connection that is already tracked and returns
+// the rejection reason, or "" when it may proceed.
+func (l *Listener) admit() string {
+	l.mu.Lock()
+	active := len(l.conns)
+	rate := l.rate
+	l.mu.Unlock()
+	if l.Limits.MaxConnections > 0 && active > l.Limits.MaxConnections {
+		return "max_connections"
+	}
+	if rate != nil && !rate.allow() {
+		return "rate_limited"
+	}
+	return ""
+}
+
+func (l *Listener) finish(c Conn) {
+	if l.OnDone != nil {
+		l.OnDone(c)
+	}
+}
+
+func (l *Listener) track(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ln == nil {
+		return false
+	}
+	l.conns[conn] = struct{}{}
+	return true
+}
+
+func (l *Listener) untrack(conn net.Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.conns, conn)
+}
+
+func (l *Listener) event(level, name string, fields map[string]any) {
+	if l.logger == nil {
+		return
+	}
+	if fields == nil {
+		fields = map[string]any{}
+	}
+	if _, ok := fields["forward"]; !ok {
+		fields["forward"] = l.Name
+	}
+	l.logger.Event(level, name, fields)
+}
+
+// Pipe copies both directions until each side finishes and returns the byte
+// counts sent upstream and received from upstream.
+func Pipe(downstream, upstream io.ReadWriteCloser) (int64, int64) {
+	return pipe(context.Background(), downstream, upstream, nil, nil)
+}
+
+// pipe adds traffic to stats, when given, as each chunk is written.
+func pipe(ctx context.Context, downstream, upstream io.ReadWriteCloser, bandwidth *bucket, stats *Stats) (int64, int64) {
+	var sent, received int64
+	var sentTo, receivedTo io.Writer = upstream, downstream
+	if stats != nil {
+		sentTo = countingWriter{w: upstream, n: &stats.bytesIn}
+		receivedTo = countingWriter{w: downstream, n: &stats.bytesOut}
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		sent, _ = copyLimited(ctx, sentTo, downstream, bandwidth)
+		closeWrite(upstream)
+	}()
+	go func() {
+		defer wg.Done()
+		received, _ = copyLimited(ctx, receivedTo, upstream, bandwidth)
+		closeWrite(downstream)
+	}()
+	wg.Wait()
+	_ = downstream.Close()
+	_ = upstream.Close()
+	return sent, received
+}
+
+func closeWrite(c io.ReadWriteCloser) {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+		return
+	}
+	_ = c.Close()
+}
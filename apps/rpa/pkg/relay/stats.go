@@ -0,0 +1,114 @@
+// Package relay keeps per-forward connection and traffic counters.
+// Counters are cumulative for the life of the process and safe for concurrent use.
+
+package relay
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats accumulates counters for one forward. BytesIn is traffic received from
+// accepted connections and sent upstream; BytesOut is the reverse direction.
+// Bytes are counted per chunk as they are relayed, so long-lived connections
+// show their traffic while they are open.
+type Stats struct {
+	mu            sync.Mutex
+	accepted      int64
+	active        int64
+	closed        int64
+	connectErrors int64
+	rejected      int64
+	duration      time.Duration
+	lastAccepted  time.Time
+
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+}
+
+type StatsSnapshot struct {
+	Accepted      int64
+	Active        int64
+	Closed        int64
+	ConnectErrors int64
+	Rejected      int64
+	BytesIn       int64
+	BytesOut      int64
+	Duration      time.Duration
+	LastAccepted  time.Time
+}
+
+// String renders the snapshot as the key=value list shown in status output.
+func (st StatsSnapshot) String() string {
+	out := fmt.Sprintf("accepted=%d active=%d in=%d out=%d errors=%d",
+		st.Accepted, st.Active, st.BytesIn, st.BytesOut, st.ConnectErrors)
+	if st.Rejected > 0 {
+		out += fmt.Sprintf(" rejected=%d", st.Rejected)
+	}
+	if st.Closed > 0 {
+		out += fmt.Sprintf(" avg_duration=%s", (st.Duration / time.Duration(st.Closed)).Truncate(time.Millisecond))
+	}
+	if !st.LastAccepted.IsZero() {
+		out += fmt.Sprintf(" last_accepted_unix=%d", st.LastAccepted.Unix())
+	}
+	return out
+}
+
+func (s *Stats) accept(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accepted++
+	s.active++
+	s.lastAccepted = now
+}
+
+func (s *Stats) connectFailed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connectErrors++
+}
+
+func (s *Stats) reject() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejected++
+}
+
+func (s *Stats) done(elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+	s.closed++
+	s.duration += elapsed
+}
+
+// countingWriter adds every chunk written to n.
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func (s *Stats) Snapshot() StatsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return StatsSnapshot{
+		Accepted:      s.accepted,
+		Active:        s.active,
+		Closed:        s.closed,
+		ConnectErrors: s.connectErrors,
+		Rejected:      s.rejected,
+		BytesIn:       s.bytesIn.Load(),
+		BytesOut:      s.bytesOut.Load(),
+		Duration:      s.duration,
+		LastAccepted:  s.lastAccepted,
+	}
+}
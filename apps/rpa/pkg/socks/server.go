@@ -0,0 +1,305 @@
+// Package socks serves SOCKS5 CONNECT requests limited to an allowlist of destinations.
+// The agent uses it behind reverse dynamic forwards so server-side users only reach approved LAN hosts.
+
+package socks
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	cmdConnect = 0x01
+
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+
+	replySuccess         = 0x00
+	replyFailure         = 0x01
+	replyNotAllowed      = 0x02
+	replyNetUnreachable  = 0x03
+	replyHostUnreachable = 0x04
+	replyRefused         = 0x05
+	replyTTLExpired      = 0x06
+	replyCmdUnsupported  = 0x07
+	replyAtypUnsupported = 0x08
+
+	resolveTimeout = 5 * time.Second
+)
+
+var ErrNotAllowed = errors.New("destination not allowed")
+
+// Allowlist matches destinations by CIDR, IP, host name or `*.domain`, each
+// optionally restricted to one port (`192.168.1.0/24:22`, `[fd00::1]:443`).
+type Allowlist struct {
+	rules []allowRule
+}
+
+type allowRule struct {
+	network *net.IPNet
+	host    string
+	port    int
+}
+
+func ParseAllowlist(entries []string) (*Allowlist, error) {
+	out := &Allowlist{}
+	for _, entry := range entries {
+		rule, err := parseAllowRule(entry)
+		if err != nil {
+			return nil, err
+		}
+		out.rules = append(out.rules, rule)
+	}
+	return out, nil
+}
+
+// ParseAllowRule validates one allowlist entry.
+func ParseAllowRule(entry string) error {
+	_, err := parseAllowRule(entry)
+	return err
+}
+
+func parseAllowRule(entry string) (allowRule, error) {
+	entry = strings.TrimSpace(entry)
+	if entry == "" {
+		return allowRule{}, errors.New("empty allowlist entry")
+	}
+	host, port := entry, ""
+	switch {
+	case strings.HasPrefix(entry, "["):
+		end := strings.Index(entry, "]")
+		if end < 0 {
+			return allowRule{}, fmt.Errorf("invalid allowlist entry %q", entry)
+		}
+		host = entry[1:end]
+		if rest := entry[end+1:]; rest != "" {
+			if !strings.HasPrefix(rest, ":") {
+				return allowRule{}, fmt.Errorf("invalid allowlist entry %q", entry)
+			}
+			port = rest[1:]
+		}
+	case strings.Count(entry, ":") == 1:
+		i := strings.LastIndex(entry, ":")
+		host, port = entry[:i], entry[i+1:]
+	}
+	rule := allowRule{}
+	if port != "" {
+		n, err := strconv.Atoi(port)
+		if err != nil || n <= 0 || n > 65535 {
+			return allowRule{}, fmt.Errorf("invalid allowlist entry %q: bad port", entry)
+		}
+		rule.port = n
+	}
+	if strings.Contains(host, "/") {
+		_, network, err := net.ParseCIDR(host)
+		if err != nil {
+			return allowRule{}, fmt.Errorf("invalid allowlist entry %q: %w", entry, err)
+		}
+		rule.network = network
+		return rule, nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		rule.network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		return rule, nil
+	}
+	if host == "" {
+		return allowRule{}, fmt.Errorf("invalid allowlist entry %q", entry)
+	}
+	rule.host = strings.ToLower(host)
+	return rule, nil
+}
+
+func (r allowRule) portOK(port int) bool {
+	return r.port == 0 || r.port == port
+}
+
+func (a *Allowlist) allowsIP(ip net.IP, port int) bool {
+	for _, r := range a.rules {
+		if r.network != nil && r.portOK(port) && r.network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Allowlist) allowsName(name string, port int) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, r := range a.rules {
+		if r.host == "" || !r.portOK(port) {
+			continue
+		}
+		switch {
+		case r.host == "*", r.host == name:
+			return true
+		case strings.HasPrefix(r.host, "*.") && strings.HasSuffix(name, r.host[1:]):
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Allowlist) Len() int {
+	return len(a.rules)
+}
+
+// Request is an approved CONNECT. Dial connects to the destination and sends
+// the SOCKS reply, so the client sees the real outcome.
+type Request struct {
+	Target string
+	conn   net.Conn
+	addrs  []string
+}
+
+// ServerConnect negotiates no-auth on conn and reads one CONNECT request. A
+// destination outside allow is refused with ErrNotAllowed; host names only
+// match name rules or, once resolved, address rules, and the checked address
+// is the one dialed.
+func ServerConnect(ctx context.Context, conn net.Conn, allow *Allowlist) (*Request, error) {
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, fmt.Errorf("read greeting: %w", err)
+	}
+	if header[0] != version5 {
+		return nil, fmt.Errorf("unsupported socks version %d", header[0])
+	}
+	methods := make([]byte, int(header[1]))
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return nil, fmt.Errorf("read methods: %w", err)
+	}
+	offered := false
+	for _, m := range methods {
+		if m == methodNoAuth {
+			offered = true
+		}
+	}
+	if !offered {
+		_, _ = conn.Write([]byte{version5, methodNone})
+		return nil, errors.New("client does not offer no-auth")
+	}
+	if _, err := conn.Write([]byte{version5, methodNoAuth}); err != nil {
+		return nil, fmt.Errorf("write method: %w", err)
+	}
+
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return nil, fmt.Errorf("read request: %w", err)
+	}
+	if req[0] != version5 {
+		return nil, fmt.Errorf("unsupported socks version %d", req[0])
+	}
+	if req[1] != cmdConnect {
+		writeReply(conn, replyCmdUnsupported)
+		return nil, fmt.Errorf("unsupported command %d", req[1])
+	}
+	var host string
+	var ip net.IP
+	switch req[3] {
+	case atypIPv4, atypIPv6:
+		size := net.IPv4len
+		if req[3] == atypIPv6 {
+			size = net.IPv6len
+		}
+		ip = make(net.IP, size)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return nil, fmt.Errorf("read address: %w", err)
+		}
+		host = ip.String()
+	case atypDomain:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return nil, fmt.Errorf("read address: %w", err)
+		}
+		name := make([]byte, int(n[0]))
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return nil, fmt.Errorf("read address: %w", err)
+		}
+		host = string(name)
+	default:
+		writeReply(conn, replyAtypUnsupported)
+		return nil, fmt.Errorf("unsupported address type %d", req[3])
+	}
+	portBytes := make([]byte, 2)
+	if _, err := io.ReadFull(conn, portBytes); err != nil {
+		return nil, fmt.Errorf("read port: %w", err)
+	}
+	port := int(binary.BigEndian.Uint16(portBytes))
+	target := net.JoinHostPort(host, strconv.Itoa(port))
+
+	r := &Request{Target: target, conn: conn}
+	switch {
+	case ip != nil:
+		if allow.allowsIP(ip, port) {
+			r.addrs = []string{target}
+		}
+	case allow.allowsName(host, port):
+		r.addrs = []string{target}
+	default:
+		resolveCtx, cancel := context.WithTimeout(ctx, resolveTimeout)
+		addrs, err := net.DefaultResolver.LookupIPAddr(resolveCtx, host)
+		cancel()
+		if err != nil {
+			writeReply(conn, replyHostUnreachable)
+			return nil, fmt.Errorf("resolve %s: %w", host, err)
+		}
+		for _, addr := range addrs {
+			if allow.allowsIP(addr.IP, port) {
+				r.addrs = append(r.addrs, net.JoinHostPort(addr.IP.String(), strconv.Itoa(port)))
+			}
+		}
+	}
+	if len(r.addrs) == 0 {
+		writeReply(conn, replyNotAllowed)
+		return nil, fmt.Errorf("%w: %s", ErrNotAllowed, target)
+	}
+	return r, nil
+}
+
+func (r *Request) Dial(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	var lastErr error
+	for _, addr := range r.addrs {
+		upstream, err := d.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			writeReply(r.conn, replySuccess)
+			return upstream, nil
+		}
+		lastErr = err
+	}
+	writeReply(r.conn, dialReply(lastErr))
+	return nil, lastErr
+}
+
+func dialReply(err error) byte {
+	var opErr *net.OpError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return replyTTLExpired
+	case errors.As(err, &opErr) && strings.Contains(opErr.Err.Error(), "refused"):
+		return replyRefused
+	case errors.As(err, &opErr) && strings.Contains(opErr.Err.Error(), "network is unreachable"):
+		return replyNetUnreachable
+	case errors.As(err, &opErr):
+		return replyHostUnreachable
+	default:
+		return replyFailure
+	}
+}
+
+func writeReply(conn net.Conn, code byte) {
+	_, _ = conn.Write([]byte{version5, code, 0x00, atypIPv4, 0, 0, 0, 0, 0, 0})
+}
@@ -0,0 +1,146 @@
+// Package socks implements the SOCKS5 greeting rpa needs to put authentication in front of `ssh -D`.
+// The server side demands username/password (RFC 1929); the client side negotiates no-auth upstream.
+
+package socks
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	version5        = 0x05
+	methodNoAuth    = 0x00
+	methodUserPass  = 0x02
+	methodNone      = 0xff
+	userPassVersion = 0x01
+
+	handshakeTimeout = 10 * time.Second
+	verifiedTTL      = 5 * time.Minute
+)
+
+var ErrAuthFailed = errors.New("socks authentication failed")
+
+// Users checks usernames against bcrypt hashes. Successful checks are cached
+// briefly because SOCKS clients authenticate on every connection.
+type Users struct {
+	hashes map[string]string
+
+	mu       sync.Mutex
+	verified map[[sha256.Size]byte]time.Time
+}
+
+func NewUsers(hashes map[string]string) *Users {
+	copied := make(map[string]string, len(hashes))
+	for user, hash := range hashes {
+		copied[user] = hash
+	}
+	return &Users{hashes: copied, verified: make(map[[sha256.Size]byte]time.Time)}
+}
+
+func (u *Users) Verify(user, password string) bool {
+	hash, ok := u.hashes[user]
+	if !ok {
+		return false
+	}
+	key := sha256.Sum256([]byte(user + "\x00" + hash + "\x00" + password))
+	now := time.Now()
+	u.mu.Lock()
+	until, cached := u.verified[key]
+	u.mu.Unlock()
+	if cached && now.Before(until) {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false
+	}
+	u.mu.Lock()
+	u.verified[key] = now.Add(verifiedTTL)
+	u.mu.Unlock()
+	return true
+}
+
+// ServerAuth runs the server side of the greeting on conn and requires
+// username/password. It returns the authenticated user.
+func ServerAuth(conn net.Conn, users *Users) (string, error) {
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", fmt.Errorf("read greeting: %w", err)
+	}
+	if header[0] != version5 {
+		return "", fmt.Errorf("unsupported socks version %d", header[0])
+	}
+	methods := make([]byte, int(header[1]))
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", fmt.Errorf("read methods: %w", err)
+	}
+	offered := false
+	for _, m := range methods {
+		if m == methodUserPass {
+			offered = true
+		}
+	}
+	if !offered {
+		_, _ = conn.Write([]byte{version5, methodNone})
+		return "", errors.New("client does not offer username/password auth")
+	}
+	if _, err := conn.Write([]byte{version5, methodUserPass}); err != nil {
+		return "", fmt.Errorf("write method: %w", err)
+	}
+
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", fmt.Errorf("read auth: %w", err)
+	}
+	if header[0] != userPassVersion {
+		return "", fmt.Errorf("unsupported auth version %d", header[0])
+	}
+	user := make([]byte, int(header[1]))
+	if _, err := io.ReadFull(conn, user); err != nil {
+		return "", fmt.Errorf("read username: %w", err)
+	}
+	plen := make([]byte, 1)
+	if _, err := io.ReadFull(conn, plen); err != nil {
+		return "", fmt.Errorf("read password: %w", err)
+	}
+	password := make([]byte, int(plen[0]))
+	if _, err := io.ReadFull(conn, password); err != nil {
+		return "", fmt.Errorf("read password: %w", err)
+	}
+	if users == nil || !users.Verify(string(user), string(password)) {
+		_, _ = conn.Write([]byte{userPassVersion, 0x01})
+		return string(user), ErrAuthFailed
+	}
+	if _, err := conn.Write([]byte{userPassVersion, 0x00}); err != nil {
+		return "", fmt.Errorf("write auth status: %w", err)
+	}
+	return string(user), nil
+}
+
+// ClientNoAuth negotiates the no-auth method with an upstream SOCKS5 server,
+// after which the caller's request can be passed through unchanged.
+func ClientNoAuth(conn net.Conn) error {
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	if _, err := conn.Write([]byte{version5, 1, methodNoAuth}); err != nil {
+		return fmt.Errorf("write greeting: %w", err)
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("read method: %w", err)
+	}
+	if reply[0] != version5 || reply[1] != methodNoAuth {
+		return fmt.Errorf("upstream refused no-auth (method %d)", reply[1])
+	}
+	return nil
+}
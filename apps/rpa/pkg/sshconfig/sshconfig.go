@@ -0,0 +1,411 @@
+// Package sshconfig resolves the effective OpenSSH client settings for a host alias.
+// It follows ssh_config(5): Host and Match blocks, Include, wildcards and first-value-wins, recording where each value came from.
+
+package sshconfig
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxIncludeDepth matches OpenSSH's limit on nested Include directives.
+const maxIncludeDepth = 16
+
+// Value is one setting with the file and line it was read from.
+type Value struct {
+	Value  string
+	Source string
+}
+
+// Settings are the options that apply to an alias, in the order ssh reads them.
+type Settings struct {
+	Alias    string
+	values   map[string][]Value
+	order    []string
+	Warnings []string
+}
+
+// multiValued keywords accumulate instead of keeping the first value.
+var multiValued = map[string]bool{
+	"identityfile":    true,
+	"certificatefile": true,
+	"localforward":    true,
+	"remoteforward":   true,
+	"dynamicforward":  true,
+	"sendenv":         true,
+	"setenv":          true,
+}
+
+// DefaultFiles returns the user and system config paths ssh reads by default.
+func DefaultFiles() []string {
+	files := []string{"/etc/ssh/ssh_config"}
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append([]string{filepath.Join(home, ".ssh", "config")}, files...)
+	}
+	return files
+}
+
+// Resolve reads files in order and returns the settings that apply to alias.
+// Missing files are skipped.
+func Resolve(alias string, files ...string) (*Settings, error) {
+	s := &Settings{Alias: alias, values: make(map[string][]Value)}
+	for _, file := range files {
+		p := &parser{settings: s, system: !isUserConfig(file)}
+		if err := p.parseFile(file, 0); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func isUserConfig(file string) bool {
+	home, err := os.UserHomeDir()
+	return err == nil && strings.HasPrefix(filepath.Clean(file), filepath.Join(home, ".ssh")+string(filepath.Separator))
+}
+
+// Get returns the first value of key (case-insensitive).
+func (s *Settings) Get(key string) (Value, bool) {
+	values := s.values[strings.ToLower(key)]
+	if len(values) == 0 {
+		return Value{}, false
+	}
+	return values[0], true
+}
+
+// All returns every value of key; only multi-valued keywords have more than one.
+func (s *Settings) All(key string) []Value {
+	return s.values[strings.ToLower(key)]
+}
+
+// Keys returns the keywords that were set, in the order they were first seen.
+func (s *Settings) Keys() []string {
+	return append([]string(nil), s.order...)
+}
+
+// HostName is the real host name, defaulting to the alias.
+func (s *Settings) HostName() Value {
+	if v, ok := s.Get("hostname"); ok {
+		v.Value = strings.ReplaceAll(v.Value, "%h", s.Alias)
+		return v
+	}
+	return Value{Value: s.Alias, Source: "alias"}
+}
+
+// User is the remote user, defaulting to the local user.
+func (s *Settings) User() Value {
+	if v, ok := s.Get("user"); ok {
+		return v
+	}
+	return Value{Value: localUser(), Source: "default"}
+}
+
+// Port is the remote port, defaulting to 22.
+func (s *Settings) Port() (int, Value, error) {
+	v, ok := s.Get("port")
+	if !ok {
+		return 22, Value{Value: "22", Source: "default"}, nil
+	}
+	port, err := strconv.Atoi(v.Value)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, v, fmt.Errorf("%s: invalid port %q", v.Source, v.Value)
+	}
+	return port, v, nil
+}
+
+// IdentityFiles returns the identity files with `~` and %-tokens expanded.
+func (s *Settings) IdentityFiles() []Value {
+	values := s.All("identityfile")
+	out := make([]Value, 0, len(values))
+	for _, v := range values {
+		if strings.EqualFold(v.Value, "none") {
+			continue
+		}
+		v.Value = s.expandTokens(v.Value)
+		out = append(out, v)
+	}
+	return out
+}
+
+func (s *Settings) expandTokens(value string) string {
+	home, _ := os.UserHomeDir()
+	if strings.HasPrefix(value, "~/") {
+		value = filepath.Join(home, value[2:])
+	}
+	port, _, _ := s.Port()
+	replacer := strings.NewReplacer(
+		"%%", "%",
+		"%d", home,
+		"%h", s.HostName().Value,
+		"%n", s.Alias,
+		"%p", strconv.Itoa(port),
+		"%r", s.User().Value,
+		"%u", localUser(),
+	)
+	return replacer.Replace(value)
+}
+
+func (s *Settings) set(key, value, source string) {
+	key = strings.ToLower(key)
+	existing := s.values[key]
+	if len(existing) == 0 {
+		s.order = append(s.order, key)
+	} else if !multiValued[key] {
+		return
+	}
+	s.values[key] = append(existing, Value{Value: value, Source: source})
+}
+
+type parser struct {
+	settings *Settings
+	system   bool
+}
+
+func (p *parser) parseFile(path string, depth int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	active := true
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		source := fmt.Sprintf("%s:%d", path, line)
+		key, args, err := splitLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+		if key == "" {
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "host":
+			active = p.matchHost(args)
+		case "match":
+			active, err = p.matchCriteria(args, source)
+			if err != nil {
+				return err
+			}
+		case "include":
+			if !active {
+				continue
+			}
+			if depth >= maxIncludeDepth {
+				return fmt.Errorf("%s: too many nested includes", source)
+			}
+			for _, pattern := range args {
+				if err := p.include(pattern, depth); err != nil {
+					return err
+				}
+			}
+		default:
+			if active && len(args) > 0 {
+				p.settings.set(key, strings.Join(args, " "), source)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// include parses every file matching pattern; relative paths are taken from
+// ~/.ssh for user files and /etc/ssh for system files.
+func (p *parser) include(pattern string, depth int) error {
+	if strings.HasPrefix(pattern, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		pattern = filepath.Join(home, pattern[2:])
+	} else if !filepath.IsAbs(pattern) {
+		base := "/etc/ssh"
+		if !p.system {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return err
+			}
+			base = filepath.Join(home, ".ssh")
+		}
+		pattern = filepath.Join(base, pattern)
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("include %s: %w", pattern, err)
+	}
+	for _, path := range matches {
+		if err := p.parseFile(path, depth+1); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchHost applies a Host line: any positive pattern must match and no
+// negated pattern may.
+func (p *parser) matchHost(patterns []string) bool {
+	return matchList(p.settings.Alias, patterns)
+}
+
+// matchCriteria evaluates a Match line. `exec` and `localnetwork` are not
+// run; they count as not matching and leave a warning.
+func (p *parser) matchCriteria(args []string, source string) (bool, error) {
+	if len(args) == 0 {
+		return false, fmt.Errorf("%s: Match needs criteria", source)
+	}
+	result := true
+	for i := 0; i < len(args); i++ {
+		criterion := strings.ToLower(args[i])
+		negate := strings.HasPrefix(criterion, "!")
+		criterion = strings.TrimPrefix(criterion, "!")
+		var ok bool
+		switch criterion {
+		case "all":
+			ok = true
+		case "canonical", "final":
+			ok = true
+		default:
+			if i+1 >= len(args) {
+				return false, fmt.Errorf("%s: Match %s needs an argument", source, criterion)
+			}
+			i++
+			patterns := strings.Split(args[i], ",")
+			switch criterion {
+			case "host":
+				ok = matchList(p.settings.HostName().Value, patterns)
+			case "originalhost":
+				ok = matchList(p.settings.Alias, patterns)
+			case "user":
+				ok = matchList(p.settings.User().Value, patterns)
+			case "localuser":
+				ok = matchList(localUser(), patterns)
+			case "exec", "localnetwork", "tagged":
+				p.settings.Warnings = append(p.settings.Warnings, fmt.Sprintf("%s: Match %s is not evaluated; block skipped", source, criterion))
+				ok = false
+			default:
+				return false, fmt.Errorf("%s: unsupported Match criterion %q", source, criterion)
+			}
+		}
+		if negate {
+			ok = !ok
+		}
+		result = result && ok
+	}
+	return result, nil
+}
+
+func matchList(value string, patterns []string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		for _, pat := range strings.Split(pattern, ",") {
+			pat = strings.TrimSpace(pat)
+			if pat == "" {
+				continue
+			}
+			if strings.HasPrefix(pat, "!") {
+				if matchPattern(strings.ToLower(value), strings.ToLower(pat[1:])) {
+					return false
+				}
+				continue
+			}
+			if matchPattern(strings.ToLower(value), strings.ToLower(pat)) {
+				matched = true
+			}
+		}
+	}
+	return matched
+}
+
+// matchPattern implements ssh's `*` and `?` wildcards.
+func matchPattern(value, pattern string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			pattern = pattern[1:]
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(value); i++ {
+				if matchPattern(value[i:], pattern) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if value == "" {
+				return false
+			}
+		default:
+			if value == "" || value[0] != pattern[0] {
+				return false
+			}
+		}
+		value = value[1:]
+		pattern = pattern[1:]
+	}
+	return value == ""
+}
+
+// splitLine returns the keyword and arguments of a config line, handling
+// `Key=Value`, double quotes and comments.
+func splitLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, nil
+	}
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return line, nil, nil
+	}
+	key := line[:end]
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimPrefix(rest, "=")
+	args, err := splitArgs(rest)
+	return key, args, err
+}
+
+func splitArgs(value string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inQuote, inArg := false, false
+	for _, r := range value {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			inArg = true
+		case !inQuote && (r == ' ' || r == '\t'):
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		case !inQuote && r == '#' && !inArg:
+			return args, nil
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+func localUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
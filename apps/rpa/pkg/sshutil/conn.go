@@ -0,0 +1,59 @@
+// Package sshutil adapts ssh stdio forwarding (`ssh -W`) into a stream.
+// It is used by probes and relays that reach targets through the SSH session.
+
+package sshutil
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+)
+
+type CommandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+
+	closeOnce sync.Once
+}
+
+// DialCommand starts cmd and returns its stdin/stdout as a single stream.
+func DialCommand(cmd *exec.Cmd) (*CommandConn, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start %s: %w", cmd.Path, err)
+	}
+	return &CommandConn{cmd: cmd, stdin: stdin, stdout: stdout}, nil
+}
+
+func (c *CommandConn) Read(p []byte) (int, error) {
+	return c.stdout.Read(p)
+}
+
+func (c *CommandConn) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+// CloseWrite signals EOF to the remote end while keeping the read side open.
+func (c *CommandConn) CloseWrite() error {
+	return c.stdin.Close()
+}
+
+func (c *CommandConn) Close() error {
+	c.closeOnce.Do(func() {
+		_ = c.stdin.Close()
+		if c.cmd.Process != nil {
+			_ = c.cmd.Process.Kill()
+		}
+		_ = c.cmd.Wait()
+	})
+	return nil
+}
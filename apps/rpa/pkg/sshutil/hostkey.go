@@ -0,0 +1,60 @@
+// Package sshutil renders pinned host key settings into ssh arguments.
+// The options go ahead of ssh.options because ssh keeps the first value it sees for each keyword.
+
+package sshutil
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/hostkey"
+)
+
+// HostKeyArgs returns the options that make ssh verify the server only
+// against rpa's known_hosts, or nil when no keys are pinned.
+func HostKeyArgs(cfg *config.Config) []string {
+	if !config.HostKeysPinned(cfg) {
+		return nil
+	}
+	path, err := config.KnownHostsPath(cfg)
+	if err != nil {
+		path = "~/.rpa/known_hosts"
+	}
+	return []string{
+		"-o", "StrictHostKeyChecking=yes",
+		"-o", "UserKnownHostsFile=" + path,
+		"-o", "GlobalKnownHostsFile=/dev/null",
+		"-o", "CheckHostIP=no",
+	}
+}
+
+// SyncKnownHosts drops keys of the server that are no longer pinned from
+// rpa's known_hosts and writes the host CA line. It fails when nothing
+// could verify the server, so the session does not start just to be
+// rejected.
+func SyncKnownHosts(cfg *config.Config) error {
+	if !config.HostKeysPinned(cfg) {
+		return nil
+	}
+	path, err := config.KnownHostsPath(cfg)
+	if err != nil {
+		return err
+	}
+	var ca ssh.PublicKey
+	if value := strings.TrimSpace(cfg.SSH.HostKeyCA); value != "" {
+		if ca, err = hostkey.ParseCA(value); err != nil {
+			return err
+		}
+	}
+	n, err := hostkey.Sync(path, cfg.SSH.Host, cfg.SSH.Port, cfg.SSH.HostKeyFingerprints, ca)
+	if err != nil {
+		return fmt.Errorf("known_hosts: %w", err)
+	}
+	if n == 0 && ca == nil {
+		return fmt.Errorf("no pinned host key for %s in %s: run `rpa hostkey pin` after verifying the server's fingerprint", cfg.SSH.Host, path)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// Package sshutil renders ssh.jump_hosts into ssh arguments.
+// Plain hops become `-J`; hops with their own identity file or options need a generated ProxyCommand.
+
+package sshutil
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"reverse-proxy-agent/pkg/config"
+)
+
+// JumpArgs returns the arguments that route ssh through hops, in order.
+func JumpArgs(hops []config.JumpHost) []string {
+	if len(hops) == 0 {
+		return nil
+	}
+	if plainHops(hops) {
+		return []string{"-J", config.JumpChain(hops)}
+	}
+	return []string{"-o", "ProxyCommand=" + proxyCommand(hops)}
+}
+
+// HopArgs returns the identity, option and port arguments for connecting to
+// hop itself, followed by its [user@]host.
+func HopArgs(hop config.JumpHost) []string {
+	var args []string
+	if hop.IdentityFile != "" {
+		args = append(args, "-i", expandTilde(hop.IdentityFile))
+	}
+	for _, opt := range hop.Options {
+		if strings.TrimSpace(opt) == "" {
+			continue
+		}
+		args = append(args, "-o", opt)
+	}
+	if hop.Port > 0 {
+		args = append(args, "-p", strconv.Itoa(hop.Port))
+	}
+	dest := strings.TrimSpace(hop.Host)
+	if hop.User != "" {
+		dest = hop.User + "@" + dest
+	}
+	return append(args, dest)
+}
+
+func plainHops(hops []config.JumpHost) bool {
+	for _, h := range hops {
+		if h.IdentityFile != "" || len(h.Options) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// proxyCommand connects to the last hop, itself routed through the earlier
+// ones, and relays to %h:%p. A nested ProxyCommand is %-escaped because the
+// outer ssh expands tokens before the inner one sees them.
+func proxyCommand(hops []config.JumpHost) string {
+	last := len(hops) - 1
+	args := []string{"ssh"}
+	if inner := JumpArgs(hops[:last]); len(inner) > 0 {
+		if inner[0] == "-o" {
+			inner[1] = strings.ReplaceAll(inner[1], "%", "%%")
+		}
+		args = append(args, inner...)
+	}
+	hop := HopArgs(hops[last])
+	args = append(args, hop[:len(hop)-1]...)
+	args = append(args, "-W", "%h:%p", hop[len(hop)-1])
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	return strings.Join(quoted, " ")
+}
+
+func shellQuote(value string) string {
+	if value != "" && strings.IndexFunc(value, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:@%=,+", r))
+	}) < 0 {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func expandTilde(path string) string {
+	if path == "" || path[0] != '~' {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	if path == "~" {
+		return home
+	}
+	return filepath.Join(home, path[2:])
+}
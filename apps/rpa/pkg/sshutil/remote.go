@@ -0,0 +1,58 @@
+// Package sshutil builds the connection part of every ssh command rpa runs.
+// Sessions, stdio streams and one-off remote commands all reach ssh.host the same way.
+
+package sshutil
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"reverse-proxy-agent/pkg/config"
+)
+
+// ConnectionArgs returns the host key, identity, option, jump and port
+// arguments for reaching ssh.host, followed by its [user@]host.
+func ConnectionArgs(cfg *config.Config) []string {
+	args := HostKeyArgs(cfg)
+	if cfg.SSH.IdentityFile != "" {
+		args = append(args, "-i", expandTilde(cfg.SSH.IdentityFile))
+	}
+
+	for _, opt := range cfg.SSH.Options {
+		if strings.TrimSpace(opt) == "" {
+			continue
+		}
+		args = append(args, "-o", opt)
+	}
+
+	args = append(args, JumpArgs(cfg.SSH.JumpHosts)...)
+
+	if cfg.SSH.Port > 0 {
+		args = append(args, "-p", strconv.Itoa(cfg.SSH.Port))
+	}
+
+	host := cfg.SSH.Host
+	if alias := strings.TrimSpace(cfg.SSH.ConfigAlias); alias != "" {
+		// Connect by alias so ssh applies the rest of its Host block.
+		args = append(args, "-o", "HostName="+cfg.SSH.Host)
+		host = alias
+	}
+	if cfg.SSH.User != "" {
+		host = cfg.SSH.User + "@" + host
+	}
+	return append(args, host)
+}
+
+// RemoteCommand runs remote on the server with cfg's connection settings
+// and no forwards.
+func RemoteCommand(cfg *config.Config, remote string) *exec.Cmd {
+	args := []string{
+		"-T",
+		"-o", "BatchMode=yes",
+		"-o", "ClearAllForwardings=yes",
+		"-o", "ConnectTimeout=10",
+	}
+	args = append(args, ConnectionArgs(cfg)...)
+	return exec.Command("ssh", append(args, remote)...)
+}
@@ -15,6 +15,9 @@ type Snapshot struct {
 	LastTrigger     string `json:"last_trigger,omitempty"`
 	LastSuccessUnix int64  `json:"last_success_unix,omitempty"`
 	UpdatedUnix     int64  `json:"updated_unix,omitempty"`
+	// AllocatedPorts maps port-0 remote forwards to the port the server
+	// last assigned them.
+	AllocatedPorts map[string]int `json:"allocated_ports,omitempty"`
 }
 
 func Write(path string, snap Snapshot) error {